@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"telemonitor/internal/bot"
+	"telemonitor/internal/config"
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+	"telemonitor/internal/ingestion"
+	"telemonitor/internal/reactor"
+	"telemonitor/internal/secret"
+	"telemonitor/internal/userbot"
+)
+
+const usage = `Usage: telemonitor [command]
+
+Commands:
+  run                 Start the monitoring service (default)
+  rotate-session-key  Re-encrypt stored sessions with the current session key
+  backfill <chat_id> <days>
+                      Import the last days of a monitored chat (refused
+                      while the service runs; it also resumes unfinished
+                      imports)
+  migrate-down <steps>
+                      Roll back the last applied migrations (pending ones
+                      are not applied first)
+`
+
+// commands are the commands main accepts
+var commands = map[string]bool{
+	"run":                true,
+	"rotate-session-key": true,
+	"backfill":           true,
+	"migrate-down":       true,
+}
+
+func main() {
+	command := "run"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	if !commands[command] {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	keyring, err := secret.LoadKeyring(cfg.SessionEncryption)
+	if err != nil {
+		log.Fatalf("Failed to load session encryption key: %v", err)
+	}
+
+	db, err := database.NewConnection(cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	// migrate-down must not apply the migrations it is asked to roll back
+	if command != "migrate-down" {
+		if err := database.RunMigrations(db); err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch command {
+	case "run":
+		err = run(ctx, cfg, db, repository.NewSessionRepository(db, keyring))
+	case "rotate-session-key":
+		err = rotateSessionKey(ctx, repository.NewSessionRepository(db, keyring), keyring)
+	case "backfill":
+		err = backfill(ctx, cfg, db, repository.NewSessionRepository(db, keyring), os.Args[2:])
+	case "migrate-down":
+		err = migrateDown(db, os.Args[2:])
+	}
+
+	if err != nil {
+		log.Printf("%s: %v", command, err)
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+// run starts the long-running service and blocks until shutdown
+func run(ctx context.Context, cfg *config.Config, db *database.DB, sessions *repository.SessionRepository) error {
+	lock, err := database.LockService(ctx, db, false)
+	if errors.Is(err, database.ErrServiceLocked) {
+		return fmt.Errorf("a backfill is running from the command line")
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	chats := repository.NewMonitoredChatRepository(db)
+	messages := repository.NewRawMessageRepository(db)
+	pool, err := userbot.NewPool(cfg, sessions, repository.NewAccountRepository(db), chats)
+	if err != nil {
+		return err
+	}
+
+	triggers := repository.NewTriggerRepository(db)
+	senderRepo := repository.NewSenderRepository(db)
+	alerts := reactor.New(triggers, chats, senderRepo)
+	if err := alerts.Refresh(); err != nil {
+		return err
+	}
+
+	pipeline := ingestion.NewPipeline(cfg.Ingestion, chats, messages, repository.NewForumTopicRepository(db), alerts)
+	if err := pipeline.Refresh(); err != nil {
+		return err
+	}
+	catchup := ingestion.NewCatchup(pipeline, chats, pool)
+	pipeline.SetGapHandler(func(chatID int64) {
+		catchup.Gap(ctx, chatID)
+	})
+	senders := ingestion.NewSenders(senderRepo)
+	pipeline.SetSenders(senders)
+	pool.SetMessageHandler(pipeline)
+	pool.SetReadyHandler(catchup.Chats)
+
+	telegramTranscriber := ingestion.NewTelegramTranscriber()
+	pool.SetTranscriptionHandler(telegramTranscriber)
+	transcriber := ingestion.NewTranscriber(cfg.Transcription, telegramTranscriber)
+	transcription := ingestion.NewTranscription(cfg.RateLimiting, transcriber, messages, chats, pool, alerts)
+	pipeline.SetTranscription(transcription)
+
+	importer := ingestion.NewBackfill(repository.NewBackfillRepository(db), chats, messages, pool)
+	importer.SetSenders(senders)
+
+	adminBot, err := bot.New(ctx, cfg.Telegram, pool, bot.Repositories{
+		Chats:    chats,
+		Messages: messages,
+		Triggers: triggers,
+		Senders:  senderRepo,
+	})
+	if err != nil {
+		return err
+	}
+	pool.SetNotifier(adminBot.Notify)
+	adminBot.SetBackfill(importer)
+	alerts.SetNotifier(adminBot.Notify)
+	adminBot.SetReactor(alerts)
+	adminBot.SetPipeline(pipeline)
+	go alerts.Run(ctx)
+	go alerts.Watch(ctx, cfg.Database.GetDSN())
+	go transcription.Run(ctx)
+
+	pipelineErr := make(chan error, 1)
+	go func() {
+		pipelineErr <- pipeline.Run(ctx)
+	}()
+
+	userbotErr := make(chan error, 1)
+	go func() {
+		userbotErr <- pool.Run(ctx)
+	}()
+	go adminBot.Start()
+
+	if err := importer.Resume(ctx); err != nil {
+		log.Printf("Failed to resume backfill jobs: %v", err)
+	}
+
+	log.Println("TeleMonitor started")
+
+	select {
+	case <-ctx.Done():
+		// Let the pipeline write what is still queued
+		<-pipelineErr
+	case err := <-userbotErr:
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("userbot stopped: %w", err)
+		}
+	case err := <-pipelineErr:
+		if err != nil {
+			return fmt.Errorf("ingestion stopped: %w", err)
+		}
+	}
+
+	log.Println("Shutting down")
+	return nil
+}
+
+// rotateSessionKey re-encrypts all session_storage rows with the current key.
+// Run it after promoting a new key_version and moving the old key to
+// previous_keys; the old key can be removed once it reports success.
+func rotateSessionKey(ctx context.Context, sessions *repository.SessionRepository, keyring *secret.Keyring) error {
+	log.Printf("Re-encrypting sessions with key version %d...", keyring.CurrentVersion())
+
+	count, err := sessions.RotateKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Re-encrypted %d session(s)", count)
+	return nil
+}
+
+// migrateDown rolls back the most recently applied migrations
+func migrateDown(db *database.DB, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: telemonitor migrate-down <steps>")
+	}
+	steps, err := strconv.Atoi(args[0])
+	if err != nil || steps < 1 {
+		return fmt.Errorf("invalid number of steps %q", args[0])
+	}
+	return database.RollbackMigrations(db, steps)
+}
+
+// backfill imports the history of one chat from the command line
+func backfill(ctx context.Context, cfg *config.Config, db *database.DB, sessions *repository.SessionRepository, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: telemonitor backfill <chat_id> <days>")
+	}
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chat ID %q", args[0])
+	}
+	days, err := strconv.Atoi(args[1])
+	if err != nil || days < 1 {
+		return fmt.Errorf("invalid number of days %q", args[1])
+	}
+
+	// The pool below connects every account and may rebalance chats, so it
+	// must not run next to the service using the same sessions
+	lock, err := database.LockService(ctx, db, true)
+	if errors.Is(err, database.ErrServiceLocked) {
+		return fmt.Errorf("the service is running; stop it or use /backfill in the admin bot")
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	chats := repository.NewMonitoredChatRepository(db)
+	pool, err := userbot.NewPool(cfg, sessions, repository.NewAccountRepository(db), chats)
+	if err != nil {
+		return err
+	}
+	importer := ingestion.NewBackfill(repository.NewBackfillRepository(db), chats, repository.NewRawMessageRepository(db), pool)
+	importer.SetReporter(logReporter{})
+	importer.SetSenders(ingestion.NewSenders(repository.NewSenderRepository(db)))
+
+	job, err := importer.Create(chatID, days)
+	if err != nil {
+		return err
+	}
+
+	poolCtx, stopPool := context.WithCancel(ctx)
+	defer stopPool()
+	go pool.Run(poolCtx)
+
+	return importer.Run(ctx, job)
+}
+
+// logReporter prints backfill progress for the CLI
+type logReporter struct{}
+
+func (logReporter) ReportBackfill(job *database.BackfillJob) {
+	log.Printf("Backfill of chat %d: %s, fetched %d, new %d, at message %d",
+		job.ChatID, job.Status, job.Fetched, job.Stored, job.OffsetID)
+}
@@ -8,4 +8,8 @@ require (
 	gopkg.in/telebot.v3 v3.2.1
 	gopkg.in/yaml.v3 v3.0.1
 	github.com/robfig/cron/v3 v3.0.1
+	rsc.io/qr v0.2.0
+	golang.org/x/net v0.20.0
+	golang.org/x/text v0.14.0
+	github.com/blevesearch/snowballstem v0.9.0
 )
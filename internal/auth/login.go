@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tgauth "github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tg"
+)
+
+// DefaultTimeout is how long the login flow waits for each admin reply and
+// for a QR code to be scanned
+const DefaultTimeout = 5 * time.Minute
+
+var (
+	// ErrCancelled is returned when the admin aborts the login with /cancel
+	ErrCancelled = errors.New("login cancelled")
+	// ErrTimeout is returned when the admin does not reply or scan in time
+	ErrTimeout = errors.New("login timed out waiting for reply")
+	// ErrInProgress is returned when a login is already running for the admin
+	ErrInProgress = errors.New("login already in progress")
+	// ErrSignUpNotSupported is returned when the phone has no Telegram account
+	ErrSignUpNotSupported = errors.New("phone number is not registered, sign up is not supported")
+)
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{7,15}$`)
+
+// State is the step of the login conversation with an admin
+type State int
+
+const (
+	StateIdle State = iota
+	StateAwaitingPhone
+	StateAwaitingCode
+	StateAwaitingPassword
+)
+
+// String returns a human readable state name
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateAwaitingPhone:
+		return "awaiting phone"
+	case StateAwaitingCode:
+		return "awaiting code"
+	case StateAwaitingPassword:
+		return "awaiting password"
+	default:
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+}
+
+// Messenger is the part of the bot the login flow talks through
+type Messenger interface {
+	Send(ctx context.Context, chatID int64, text string) error
+	SendPhoto(ctx context.Context, chatID int64, png []byte, caption string) (int, error)
+	Delete(ctx context.Context, chatID int64, messageID int) error
+}
+
+type reply struct {
+	messageID int
+	text      string
+}
+
+// Manager tracks one login conversation per admin and routes bot messages
+// to the conversation waiting for them
+type Manager struct {
+	messenger Messenger
+	timeout   time.Duration
+
+	mu            sync.Mutex
+	conversations map[int64]*Conversation
+}
+
+// NewManager creates a login Manager; timeout bounds each admin reply
+func NewManager(messenger Messenger, timeout time.Duration) *Manager {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Manager{
+		messenger:     messenger,
+		timeout:       timeout,
+		conversations: make(map[int64]*Conversation),
+	}
+}
+
+// Begin starts a login conversation with adminID. The returned Conversation
+// implements gotd's auth.UserAuthenticator and must be closed when the flow
+// finishes.
+func (m *Manager) Begin(adminID int64) (*Conversation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.conversations[adminID]; ok {
+		return nil, ErrInProgress
+	}
+
+	c := &Conversation{
+		manager:   m,
+		adminID:   adminID,
+		replies:   make(chan reply, 1),
+		cancelled: make(chan struct{}),
+	}
+	m.conversations[adminID] = c
+	return c, nil
+}
+
+// State returns the current step of adminID's login
+func (m *Manager) State(adminID int64) State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.conversations[adminID]; ok {
+		return c.state
+	}
+	return StateIdle
+}
+
+// HandleMessage delivers a text message from adminID to its waiting login
+// step. It reports whether the message was consumed by the login flow.
+func (m *Manager) HandleMessage(adminID int64, messageID int, text string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.conversations[adminID]
+	if !ok || c.state == StateIdle {
+		return false
+	}
+
+	select {
+	case c.replies <- reply{messageID: messageID, text: text}:
+	default:
+		// A reply is already queued for this step; drop the duplicate
+	}
+	return true
+}
+
+// Cancel aborts adminID's login. It reports whether a login was running.
+func (m *Manager) Cancel(adminID int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.conversations[adminID]
+	if !ok {
+		return false
+	}
+	c.cancelOnce.Do(func() { close(c.cancelled) })
+	return true
+}
+
+// Conversation is a single admin's login flow
+type Conversation struct {
+	manager *Manager
+	adminID int64
+
+	// state is guarded by manager.mu
+	state      State
+	replies    chan reply
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+
+	// qrMessageID is the bot message holding the current QR code
+	qrMessageID int
+}
+
+var _ tgauth.UserAuthenticator = (*Conversation)(nil)
+
+// Close ends the conversation so a new login can be started
+func (c *Conversation) Close() {
+	c.manager.mu.Lock()
+	defer c.manager.mu.Unlock()
+
+	if c.manager.conversations[c.adminID] == c {
+		delete(c.manager.conversations, c.adminID)
+	}
+}
+
+// Phone asks the admin for the account phone number
+func (c *Conversation) Phone(ctx context.Context) (string, error) {
+	prompt := "📱 Send the phone number of the userbot account in international format, e.g. +79991234567.\nSend /cancel to abort."
+	for {
+		text, err := c.ask(ctx, StateAwaitingPhone, prompt, false)
+		if err != nil {
+			return "", err
+		}
+
+		phone := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(text)
+		if phonePattern.MatchString(phone) {
+			return phone, nil
+		}
+		prompt = "❌ That does not look like a phone number. Send it in international format, e.g. +79991234567."
+	}
+}
+
+// Code asks the admin for the login code Telegram just sent
+func (c *Conversation) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	prompt := "🔑 Enter the login code Telegram sent you.\n" +
+		"Separate the digits with spaces (e.g. 1 2 3 4 5), otherwise Telegram may expire the code."
+	for {
+		text, err := c.ask(ctx, StateAwaitingCode, prompt, true)
+		if err != nil {
+			return "", err
+		}
+
+		code := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, text)
+		if code != "" {
+			return code, nil
+		}
+		prompt = "❌ The code must contain digits. Try again."
+	}
+}
+
+// Password asks the admin for the 2FA cloud password
+func (c *Conversation) Password(ctx context.Context) (string, error) {
+	return c.ask(ctx, StateAwaitingPassword, "🔒 This account has two-step verification. Send the cloud password.", true)
+}
+
+// AcceptTermsOfService is only called during sign up, which is not supported
+func (c *Conversation) AcceptTermsOfService(ctx context.Context, tos tg.HelpTermsOfService) error {
+	return ErrSignUpNotSupported
+}
+
+// SignUp is not supported; the userbot must use an existing account
+func (c *Conversation) SignUp(ctx context.Context) (tgauth.UserInfo, error) {
+	return tgauth.UserInfo{}, ErrSignUpNotSupported
+}
+
+// ask sends prompt, moves to state and waits for the admin's reply. Replies
+// to sensitive prompts are deleted from the bot chat once read.
+func (c *Conversation) ask(ctx context.Context, state State, prompt string, sensitive bool) (string, error) {
+	c.setState(state)
+	defer c.setState(StateIdle)
+
+	// Discard anything queued for a previous step
+	select {
+	case <-c.replies:
+	default:
+	}
+
+	if err := c.manager.messenger.Send(ctx, c.adminID, prompt); err != nil {
+		return "", fmt.Errorf("failed to send login prompt: %w", err)
+	}
+
+	timer := time.NewTimer(c.manager.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-c.replies:
+		if sensitive {
+			if err := c.manager.messenger.Delete(ctx, c.adminID, r.messageID); err != nil {
+				log.Printf("Failed to delete login reply from admin %d: %v", c.adminID, err)
+			}
+		}
+		return strings.TrimSpace(r.text), nil
+	case <-c.cancelled:
+		return "", ErrCancelled
+	case <-timer.C:
+		return "", ErrTimeout
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (c *Conversation) setState(state State) {
+	c.manager.mu.Lock()
+	defer c.manager.mu.Unlock()
+	c.state = state
+}
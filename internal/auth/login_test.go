@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	tgauth "github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/auth/qrlogin"
+	"github.com/gotd/td/tg"
+)
+
+const testAdmin int64 = 42
+
+// fakeBot records prompts and deletions instead of talking to Telegram
+type fakeBot struct {
+	mu      sync.Mutex
+	sent    []string
+	deleted []int
+	photos  int
+	prompts chan string
+}
+
+func newFakeBot() *fakeBot {
+	return &fakeBot{prompts: make(chan string, 16)}
+}
+
+func (f *fakeBot) Send(ctx context.Context, chatID int64, text string) error {
+	f.mu.Lock()
+	f.sent = append(f.sent, text)
+	f.mu.Unlock()
+	f.prompts <- text
+	return nil
+}
+
+func (f *fakeBot) SendPhoto(ctx context.Context, chatID int64, png []byte, caption string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.photos++
+	return 1000 + f.photos, nil
+}
+
+func (f *fakeBot) Delete(ctx context.Context, chatID int64, messageID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, messageID)
+	return nil
+}
+
+func (f *fakeBot) deletedIDs() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int(nil), f.deleted...)
+}
+
+func (f *fakeBot) waitPrompt(t *testing.T) string {
+	t.Helper()
+	select {
+	case p := <-f.prompts:
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for bot prompt")
+		return ""
+	}
+}
+
+// fakeFlowClient emulates Telegram's sign in endpoints
+type fakeFlowClient struct {
+	code     string
+	password string
+
+	gotPhone    string
+	gotCode     string
+	gotPassword string
+}
+
+func (f *fakeFlowClient) SendCode(ctx context.Context, phone string, _ tgauth.SendCodeOptions) (tg.AuthSentCodeClass, error) {
+	f.gotPhone = phone
+	return &tg.AuthSentCode{PhoneCodeHash: "hash"}, nil
+}
+
+func (f *fakeFlowClient) SignIn(ctx context.Context, phone, code, codeHash string) (*tg.AuthAuthorization, error) {
+	f.gotCode = code
+	if code != f.code {
+		return nil, errors.New("PHONE_CODE_INVALID")
+	}
+	if f.password != "" {
+		return nil, tgauth.ErrPasswordAuthNeeded
+	}
+	return &tg.AuthAuthorization{}, nil
+}
+
+func (f *fakeFlowClient) Password(ctx context.Context, password string) (*tg.AuthAuthorization, error) {
+	f.gotPassword = password
+	if password != f.password {
+		return nil, tgauth.ErrPasswordInvalid
+	}
+	return &tg.AuthAuthorization{}, nil
+}
+
+func (f *fakeFlowClient) SignUp(ctx context.Context, s tgauth.SignUp) (*tg.AuthAuthorization, error) {
+	return nil, errors.New("unexpected sign up")
+}
+
+func waitState(t *testing.T, m *Manager, want State) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if m.State(testAdmin) == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("state = %s, want %s", m.State(testAdmin), want)
+}
+
+func TestFlowWithPassword(t *testing.T) {
+	bot := newFakeBot()
+	m := NewManager(bot, time.Second)
+
+	conv, err := m.Begin(testAdmin)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer conv.Close()
+
+	client := &fakeFlowClient{code: "12345", password: "hunter2"}
+	done := make(chan error, 1)
+	go func() {
+		done <- tgauth.NewFlow(conv, tgauth.SendCodeOptions{}).Run(context.Background(), client)
+	}()
+
+	bot.waitPrompt(t)
+	waitState(t, m, StateAwaitingPhone)
+	if !m.HandleMessage(testAdmin, 1, "+7 999 123-45-67") {
+		t.Fatal("phone reply was not consumed")
+	}
+
+	bot.waitPrompt(t)
+	waitState(t, m, StateAwaitingCode)
+	m.HandleMessage(testAdmin, 2, "1 2 3 4 5")
+
+	bot.waitPrompt(t)
+	waitState(t, m, StateAwaitingPassword)
+	m.HandleMessage(testAdmin, 3, "hunter2")
+
+	if err := <-done; err != nil {
+		t.Fatalf("flow: %v", err)
+	}
+	if client.gotPhone != "+79991234567" {
+		t.Errorf("phone = %q", client.gotPhone)
+	}
+	if client.gotCode != "12345" {
+		t.Errorf("code = %q", client.gotCode)
+	}
+	if client.gotPassword != "hunter2" {
+		t.Errorf("password = %q", client.gotPassword)
+	}
+
+	// The phone is not secret; code and password must be removed from the chat
+	deleted := bot.deletedIDs()
+	if len(deleted) != 2 || deleted[0] != 2 || deleted[1] != 3 {
+		t.Errorf("deleted messages = %v, want [2 3]", deleted)
+	}
+	if got := m.State(testAdmin); got != StateIdle {
+		t.Errorf("state after flow = %s, want idle", got)
+	}
+}
+
+func TestPhoneReprompt(t *testing.T) {
+	bot := newFakeBot()
+	m := NewManager(bot, time.Second)
+	conv, _ := m.Begin(testAdmin)
+	defer conv.Close()
+
+	done := make(chan string, 1)
+	go func() {
+		phone, _ := conv.Phone(context.Background())
+		done <- phone
+	}()
+
+	bot.waitPrompt(t)
+	waitState(t, m, StateAwaitingPhone)
+	m.HandleMessage(testAdmin, 1, "not a phone")
+
+	bot.waitPrompt(t)
+	waitState(t, m, StateAwaitingPhone)
+	m.HandleMessage(testAdmin, 2, "+441234567890")
+
+	if got := <-done; got != "+441234567890" {
+		t.Errorf("phone = %q", got)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	bot := newFakeBot()
+	m := NewManager(bot, time.Minute)
+	conv, _ := m.Begin(testAdmin)
+	defer conv.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conv.Code(context.Background(), &tg.AuthSentCode{})
+		done <- err
+	}()
+
+	bot.waitPrompt(t)
+	if !m.Cancel(testAdmin) {
+		t.Fatal("Cancel reported no running login")
+	}
+	if err := <-done; !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrCancelled", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	bot := newFakeBot()
+	m := NewManager(bot, 20*time.Millisecond)
+	conv, _ := m.Begin(testAdmin)
+	defer conv.Close()
+
+	_, err := conv.Password(context.Background())
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	bot := newFakeBot()
+	m := NewManager(bot, time.Minute)
+	conv, _ := m.Begin(testAdmin)
+	defer conv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := conv.Phone(ctx)
+		done <- err
+	}()
+
+	bot.waitPrompt(t)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestSingleLoginPerAdmin(t *testing.T) {
+	m := NewManager(newFakeBot(), time.Second)
+
+	conv, err := m.Begin(testAdmin)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if _, err := m.Begin(testAdmin); !errors.Is(err, ErrInProgress) {
+		t.Fatalf("second Begin err = %v, want ErrInProgress", err)
+	}
+
+	conv.Close()
+	if _, err := m.Begin(testAdmin); err != nil {
+		t.Fatalf("Begin after Close: %v", err)
+	}
+}
+
+func TestIdleMessagesIgnored(t *testing.T) {
+	m := NewManager(newFakeBot(), time.Second)
+
+	if m.HandleMessage(testAdmin, 1, "hello") {
+		t.Error("message consumed without a login")
+	}
+
+	conv, _ := m.Begin(testAdmin)
+	defer conv.Close()
+	if m.HandleMessage(testAdmin, 2, "hello") {
+		t.Error("message consumed while no step is waiting")
+	}
+	if m.Cancel(testAdmin + 1) {
+		t.Error("Cancel succeeded for another admin")
+	}
+}
+
+func TestShowQRReplacesPreviousCode(t *testing.T) {
+	bot := newFakeBot()
+	m := NewManager(bot, time.Second)
+	conv, _ := m.Begin(testAdmin)
+	defer conv.Close()
+
+	expires := int(time.Now().Add(30 * time.Second).Unix())
+	ctx := context.Background()
+	if err := conv.ShowQR(ctx, qrlogin.NewToken([]byte("first"), expires)); err != nil {
+		t.Fatalf("ShowQR: %v", err)
+	}
+	if err := conv.ShowQR(ctx, qrlogin.NewToken([]byte("second"), expires)); err != nil {
+		t.Fatalf("ShowQR: %v", err)
+	}
+
+	if deleted := bot.deletedIDs(); len(deleted) != 1 || deleted[0] != 1001 {
+		t.Fatalf("deleted = %v, want the first QR message [1001]", deleted)
+	}
+
+	conv.ClearQR(ctx)
+	if deleted := bot.deletedIDs(); len(deleted) != 2 || deleted[1] != 1002 {
+		t.Fatalf("deleted = %v, want the second QR message removed", deleted)
+	}
+}
+
+func TestContextCancelledByAdmin(t *testing.T) {
+	m := NewManager(newFakeBot(), time.Second)
+	conv, _ := m.Begin(testAdmin)
+	defer conv.Close()
+
+	ctx, stop := conv.Context(context.Background())
+	defer stop()
+
+	m.Cancel(testAdmin)
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("context not cancelled by /cancel")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrCancelled) {
+		t.Fatalf("cause = %v, want ErrCancelled", cause)
+	}
+}
+
+func TestContextTimeout(t *testing.T) {
+	m := NewManager(newFakeBot(), 20*time.Millisecond)
+	conv, _ := m.Begin(testAdmin)
+	defer conv.Close()
+
+	ctx, stop := conv.Context(context.Background())
+	defer stop()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("context not cancelled after the login timeout")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrTimeout) {
+		t.Fatalf("cause = %v, want ErrTimeout", cause)
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"log"
+	"time"
+
+	"github.com/gotd/td/telegram/auth/qrlogin"
+	"rsc.io/qr"
+)
+
+const qrCaption = "📷 Scan this code in Telegram on a logged in device:\n" +
+	"Settings → Devices → Link Desktop Device.\n" +
+	"The code refreshes automatically; this one expires at %s UTC.\nSend /cancel to abort."
+
+// ShowQR renders a login token as a PNG QR code and sends it to the admin,
+// replacing the previously shown code once the token is refreshed
+func (c *Conversation) ShowQR(ctx context.Context, token qrlogin.Token) error {
+	img, err := token.Image(qr.M)
+	if err != nil {
+		return fmt.Errorf("failed to render QR code: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return fmt.Errorf("failed to encode QR code: %w", err)
+	}
+
+	caption := fmt.Sprintf(qrCaption, token.Expires().UTC().Format(time.TimeOnly))
+	messageID, err := c.manager.messenger.SendPhoto(ctx, c.adminID, buf.Bytes(), caption)
+	if err != nil {
+		return fmt.Errorf("failed to send QR code: %w", err)
+	}
+
+	c.ClearQR(ctx)
+	c.qrMessageID = messageID
+	return nil
+}
+
+// ClearQR deletes the last QR code sent to the admin, so an expired or
+// already used token is not left in the chat
+func (c *Conversation) ClearQR(ctx context.Context) {
+	if c.qrMessageID == 0 {
+		return
+	}
+	if err := c.manager.messenger.Delete(ctx, c.adminID, c.qrMessageID); err != nil {
+		log.Printf("Failed to delete QR code for admin %d: %v", c.adminID, err)
+	}
+	c.qrMessageID = 0
+}
+
+// Context returns a context derived from parent that is cancelled with
+// ErrCancelled as its cause when the admin sends /cancel, or with ErrTimeout
+// once the manager timeout passes. It lets flows that do not wait on a reply,
+// like QR login, honour cancellation and give up when abandoned.
+func (c *Conversation) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	ctx, stopTimeout := context.WithTimeoutCause(ctx, c.manager.timeout, ErrTimeout)
+	go func() {
+		select {
+		case <-c.cancelled:
+			cancel(ErrCancelled)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		stopTimeout()
+		cancel(context.Canceled)
+	}
+}
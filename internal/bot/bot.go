@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+	"gopkg.in/telebot.v3/middleware"
+
+	"telemonitor/internal/auth"
+	"telemonitor/internal/config"
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+	"telemonitor/internal/ingestion"
+	"telemonitor/internal/reactor"
+	"telemonitor/internal/userbot"
+)
+
+// Repositories groups the data access the bot commands need
+type Repositories struct {
+	Chats    *repository.MonitoredChatRepository
+	Messages *repository.RawMessageRepository
+	Triggers *repository.TriggerRepository
+	Senders  *repository.SenderRepository
+}
+
+// Bot is the admin ChatOps interface built on the Telegram Bot API
+type Bot struct {
+	tb       *telebot.Bot
+	cfg      config.TelegramConfig
+	pool     *userbot.Pool
+	repos    Repositories
+	logins   *auth.Manager
+	backfill *ingestion.Backfill
+	reactor  *reactor.Reactor
+	pipeline *ingestion.Pipeline
+
+	// progress holds the message showing each backfill job
+	progressMu sync.Mutex
+	progress   map[int]*backfillProgress
+
+	ctx       context.Context
+	startedAt time.Time
+}
+
+// New creates the admin bot. Only cfg.AdminID may use it; everyone else is
+// ignored silently. ctx bounds the logins, backfills and notifications the
+// bot starts, and stops polling when cancelled.
+func New(ctx context.Context, cfg config.TelegramConfig, pool *userbot.Pool, repos Repositories) (*Bot, error) {
+	tb, err := telebot.NewBot(telebot.Settings{
+		Token:  cfg.BotToken,
+		Poller: &telebot.LongPoller{Timeout: 10 * time.Second},
+		OnError: func(err error, c telebot.Context) {
+			log.Printf("Bot handler error: %v", err)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bot: %w", err)
+	}
+
+	b := &Bot{
+		tb:        tb,
+		cfg:       cfg,
+		pool:      pool,
+		repos:     repos,
+		ctx:       ctx,
+		progress:  make(map[int]*backfillProgress),
+		startedAt: time.Now(),
+	}
+	b.logins = auth.NewManager(b, auth.DefaultTimeout)
+
+	tb.Use(middleware.Whitelist(cfg.AdminID))
+	b.registerHandlers()
+
+	return b, nil
+}
+
+// SetBackfill enables /backfill and makes the bot report job progress
+func (b *Bot) SetBackfill(backfill *ingestion.Backfill) {
+	b.backfill = backfill
+	backfill.SetReporter(b)
+}
+
+// SetReactor makes trigger commands take effect immediately
+func (b *Bot) SetReactor(r *reactor.Reactor) {
+	b.reactor = r
+}
+
+// SetPipeline makes /status report the ingestion queue and counters
+func (b *Bot) SetPipeline(p *ingestion.Pipeline) {
+	b.pipeline = p
+}
+
+// Start polls for updates until the context given to New is cancelled
+func (b *Bot) Start() {
+	go b.tb.Start()
+	log.Printf("Bot @%s started", b.tb.Me.Username)
+
+	<-b.ctx.Done()
+	b.tb.Stop()
+}
+
+// Send implements auth.Messenger
+func (b *Bot) Send(ctx context.Context, chatID int64, text string) error {
+	_, err := b.tb.Send(telebot.ChatID(chatID), text)
+	return err
+}
+
+// SendPhoto implements auth.Messenger
+func (b *Bot) SendPhoto(ctx context.Context, chatID int64, png []byte, caption string) (int, error) {
+	msg, err := b.tb.Send(telebot.ChatID(chatID), &telebot.Photo{
+		File:    telebot.FromReader(bytes.NewReader(png)),
+		Caption: caption,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return msg.ID, nil
+}
+
+// Delete implements auth.Messenger
+func (b *Bot) Delete(ctx context.Context, chatID int64, messageID int) error {
+	return b.tb.Delete(&telebot.StoredMessage{
+		MessageID: strconv.Itoa(messageID),
+		ChatID:    chatID,
+	})
+}
+
+// Notify sends a message to the admin
+func (b *Bot) Notify(text string) {
+	if err := b.Send(b.ctx, b.cfg.AdminID, text); err != nil {
+		log.Printf("Failed to notify admin: %v", err)
+	}
+}
+
+// backfillProgressInterval limits how often a progress message is edited
+const backfillProgressInterval = 5 * time.Second
+
+// backfillProgress is the admin message showing a backfill job
+type backfillProgress struct {
+	msg      *telebot.Message
+	editedAt time.Time
+}
+
+// ReportBackfill implements ingestion.BackfillReporter. The first report of
+// a job sends a message that later reports edit in place.
+func (b *Bot) ReportBackfill(job *database.BackfillJob) {
+	text := formatBackfill(job)
+	finished := job.Status != database.BackfillRunning
+
+	b.progressMu.Lock()
+	defer b.progressMu.Unlock()
+
+	p, ok := b.progress[job.ID]
+	if !ok {
+		msg, err := b.tb.Send(telebot.ChatID(b.cfg.AdminID), text)
+		if err != nil {
+			log.Printf("Failed to report backfill progress: %v", err)
+			return
+		}
+		b.progress[job.ID] = &backfillProgress{msg: msg, editedAt: time.Now()}
+		return
+	}
+
+	if !finished && time.Since(p.editedAt) < backfillProgressInterval {
+		return
+	}
+	if _, err := b.tb.Edit(p.msg, text); err != nil {
+		log.Printf("Failed to update backfill progress: %v", err)
+	}
+	p.editedAt = time.Now()
+	if finished {
+		delete(b.progress, job.ID)
+	}
+}
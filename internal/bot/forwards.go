@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// sourcesDays is the period /sources looks at without an argument
+	sourcesDays = 7
+	// maxSources is how many upstream chats /sources lists
+	maxSources = 15
+	// spreadHours is the period /spread looks at without an argument
+	spreadHours = 24
+	// minSpreadChats is how many monitored chats a post must reach to be
+	// listed by /spread
+	minSpreadChats = 2
+	// maxSpreadPosts is how many posts /spread lists
+	maxSpreadPosts = 10
+)
+
+// handleSources lists the upstream chats whose posts are forwarded into a
+// monitored chat most: /sources <chat_id> [days]
+func (b *Bot) handleSources(c telebot.Context) error {
+	args := c.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return c.Send("Usage: /sources <chat_id> [days]")
+	}
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return c.Send("❌ Invalid chat ID")
+	}
+	days := sourcesDays
+	if len(args) == 2 {
+		if days, err = strconv.Atoi(args[1]); err != nil || days < 1 || days > maxBackfillDays {
+			return c.Send(fmt.Sprintf("❌ Days must be between 1 and %d", maxBackfillDays))
+		}
+	}
+
+	sources, err := b.repos.Messages.GetForwardSources(chatID, time.Now().AddDate(0, 0, -days), maxSources)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to get sources: %v", err))
+	}
+	if len(sources) == 0 {
+		return c.Send(fmt.Sprintf("No forwards in %s in the last %d days", b.chatName(chatID), days))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📡 Sources of %s, last %d days:\n\n", b.chatName(chatID), days)
+	for _, s := range sources {
+		fmt.Fprintf(&sb, "• %s — %d forward(s), last %s\n",
+			b.sourceName(s.SourceID, s.SourceName.String), s.Forwards, s.LastForwardAt.Format("2006-01-02 15:04"))
+	}
+	return c.Send(sb.String())
+}
+
+// handleSpread lists channel posts forwarded into several monitored chats,
+// with when each chat first got them: /spread [hours]
+func (b *Bot) handleSpread(c telebot.Context) error {
+	hours := spreadHours
+	if args := c.Args(); len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > maxBackfillDays*24 {
+			return c.Send(fmt.Sprintf("❌ Hours must be between 1 and %d", maxBackfillDays*24))
+		}
+		hours = n
+	}
+
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	posts, err := b.repos.Messages.GetSpreading(since, minSpreadChats, maxSpreadPosts)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to get spreading posts: %v", err))
+	}
+	if len(posts) == 0 {
+		return c.Send(fmt.Sprintf("No post reached %d or more monitored chats in the last %d hours", minSpreadChats, hours))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🌊 Spreading posts, last %d hours:\n", hours)
+	for _, p := range posts {
+		fmt.Fprintf(&sb, "\n%s post #%d", b.sourceName(p.SourceID, p.SourceName.String), p.SourceMsgID)
+		if p.OriginalDate.Valid {
+			fmt.Fprintf(&sb, " from %s", p.OriginalDate.Time.Format("2006-01-02 15:04"))
+		}
+		fmt.Fprintf(&sb, " — %d chats:\n", len(p.Copies))
+		for _, cp := range p.Copies {
+			fmt.Fprintf(&sb, "• %s %s (#%d)\n", cp.FirstSeen.Format("01-02 15:04"), b.chatName(cp.ChatID), cp.TelegramMsgID)
+		}
+	}
+	return c.Send(sb.String())
+}
+
+// sourceName renders the origin of forwards as "Name (id)"; name is the one
+// stored with the forwards, if any
+func (b *Bot) sourceName(id int64, name string) string {
+	if name == "" {
+		name = b.chatName(id)
+	}
+	if name == strconv.FormatInt(id, 10) {
+		return name
+	}
+	return fmt.Sprintf("%s (%d)", name, id)
+}
+
+// chatName returns the title of a monitored chat, or its ID
+func (b *Bot) chatName(chatID int64) string {
+	chat, err := b.repos.Chats.GetByChatID(chatID)
+	if err != nil || chat == nil || !chat.Title.Valid {
+		return strconv.FormatInt(chatID, 10)
+	}
+	return chat.Title.String
+}
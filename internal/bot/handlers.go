@@ -0,0 +1,276 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"telemonitor/internal/auth"
+	"telemonitor/internal/database"
+	"telemonitor/internal/ingestion"
+	"telemonitor/internal/userbot"
+)
+
+func (b *Bot) registerHandlers() {
+	b.tb.Handle("/start", b.handleStart)
+	b.tb.Handle("/login", b.handleLogin)
+	b.tb.Handle("/cancel", b.handleCancel)
+	b.tb.Handle("/logout", b.handleLogout)
+	b.tb.Handle("/status", b.handleStatus)
+	b.tb.Handle("/backfill", b.handleBackfill)
+	b.tb.Handle("/sources", b.handleSources)
+	b.tb.Handle("/spread", b.handleSpread)
+	b.tb.Handle("/triggers", b.handleTriggers)
+	b.tb.Handle("/add_trigger", b.handleAddTrigger)
+	b.tb.Handle("/del_trigger", b.handleDelTrigger)
+	b.tb.Handle("/trigger_scope", b.handleTriggerScope)
+	b.tb.Handle("/sender", b.handleSender)
+	b.tb.Handle("/renames", b.handleRenames)
+	b.tb.Handle(telebot.OnText, b.handleText)
+}
+
+// handleStart confirms admin access
+func (b *Bot) handleStart(c telebot.Context) error {
+	return c.Send("✅ Access granted. TeleMonitor is running.")
+}
+
+// handleLogin starts the interactive userbot login: /login [account] [qr].
+// "qr" logs in by scanning a QR code instead of entering a phone code.
+func (b *Bot) handleLogin(c telebot.Context) error {
+	name, useQR := userbot.DefaultAccount, false
+	for _, arg := range c.Args() {
+		if arg == "qr" {
+			useQR = true
+		} else {
+			name = arg
+		}
+	}
+
+	client, err := b.pool.Account(name)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to prepare account %s: %v", name, err))
+	}
+	if client.Authorized() {
+		return c.Send(fmt.Sprintf("✅ Account %s is already logged in. Use /logout %s first.", name, name))
+	}
+
+	adminID := c.Sender().ID
+	conv, err := b.logins.Begin(adminID)
+	if errors.Is(err, auth.ErrInProgress) {
+		return c.Send("⏳ A login is already in progress. Send /cancel to abort it.")
+	}
+	if err != nil {
+		return err
+	}
+
+	go b.runLogin(conv, client, useQR)
+	return nil
+}
+
+// runLogin drives the gotd auth flow and reports the outcome to the admin
+func (b *Bot) runLogin(conv *auth.Conversation, client *userbot.Client, useQR bool) {
+	defer conv.Close()
+
+	var err error
+	if useQR {
+		ctx, stop := conv.Context(b.ctx)
+		err = client.LoginQR(ctx, conv)
+		if ctx.Err() != nil && b.ctx.Err() == nil {
+			err = context.Cause(ctx)
+		}
+		stop()
+		conv.ClearQR(b.ctx)
+	} else {
+		err = client.Login(b.ctx, conv)
+	}
+
+	switch {
+	case err == nil:
+		b.Notify(fmt.Sprintf("✅ Account %s logged in successfully", client.Name()))
+	case errors.Is(err, auth.ErrCancelled):
+		b.Notify("🚫 Login cancelled")
+	case errors.Is(err, auth.ErrTimeout):
+		b.Notify("⌛ Login timed out. Send /login to start again.")
+	default:
+		b.Notify(fmt.Sprintf("❌ Login failed: %v", err))
+	}
+}
+
+// handleCancel aborts a pending login
+func (b *Bot) handleCancel(c telebot.Context) error {
+	if !b.logins.Cancel(c.Sender().ID) {
+		return c.Send("Nothing to cancel.")
+	}
+	return nil
+}
+
+// handleLogout terminates a userbot session: /logout [account]
+func (b *Bot) handleLogout(c telebot.Context) error {
+	name := userbot.DefaultAccount
+	if len(c.Args()) > 0 {
+		name = c.Args()[0]
+	}
+
+	if err := b.pool.Logout(b.ctx, name); err != nil {
+		return c.Send(fmt.Sprintf("❌ Logout failed: %v", err))
+	}
+	return c.Send(fmt.Sprintf("👋 Account %s logged out and session removed", name))
+}
+
+// handleStatus reports system health and the state of every account
+func (b *Bot) handleStatus(c telebot.Context) error {
+	health, err := b.pool.Health()
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to get account status: %v", err))
+	}
+	messages, err := b.repos.Messages.CountTotal()
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to count messages: %v", err))
+	}
+	chats, err := b.repos.Chats.GetActive()
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to list chats: %v", err))
+	}
+
+	healthy := 0
+	for _, h := range health {
+		if h.Running && h.Authorized {
+			healthy++
+		}
+	}
+
+	icon := "🟢"
+	if healthy == 0 {
+		icon = "🔴"
+	} else if healthy < len(health) {
+		icon = "🟡"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s System Status\n\n", icon)
+	fmt.Fprintf(&sb, "Uptime: %s\n", formatDuration(time.Since(b.startedAt)))
+	fmt.Fprintf(&sb, "Messages Processed: %d\n", messages)
+	fmt.Fprintf(&sb, "Active Chats: %d\n", len(chats))
+	if b.pipeline != nil {
+		st := b.pipeline.Stats()
+		fmt.Fprintf(&sb, "Ingestion Queue: %d/%d\n", st.Queued, st.Capacity)
+		fmt.Fprintf(&sb, "Since Start: %d stored, %d duplicate, %d edited, %d deleted, %d dropped\n",
+			st.Stored, st.Skipped, st.Edited, st.Deleted, st.Dropped)
+	}
+	fmt.Fprintf(&sb, "\nAccounts (%d/%d healthy):\n", healthy, len(health))
+	for _, h := range health {
+		sb.WriteString(formatAccountHealth(h))
+	}
+
+	return c.Send(sb.String())
+}
+
+// maxBackfillDays is the longest history /backfill imports
+const maxBackfillDays = 365
+
+// handleBackfill imports the history of a monitored chat:
+// /backfill <chat_id> <days>
+func (b *Bot) handleBackfill(c telebot.Context) error {
+	if b.backfill == nil {
+		return c.Send("❌ Backfill is not available")
+	}
+
+	args := c.Args()
+	if len(args) != 2 {
+		return c.Send("Usage: /backfill <chat_id> <days>")
+	}
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return c.Send("❌ Invalid chat ID")
+	}
+	days, err := strconv.Atoi(args[1])
+	if err != nil || days < 1 || days > maxBackfillDays {
+		return c.Send(fmt.Sprintf("❌ Days must be between 1 and %d", maxBackfillDays))
+	}
+
+	_, err = b.backfill.Start(b.ctx, chatID, days)
+	if errors.Is(err, ingestion.ErrBackfillRunning) {
+		return c.Send("⏳ A backfill is already running for this chat")
+	}
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to start backfill: %v", err))
+	}
+	// Progress is reported by ReportBackfill
+	return nil
+}
+
+// handleText routes free-form replies to a pending login step
+func (b *Bot) handleText(c telebot.Context) error {
+	b.logins.HandleMessage(c.Sender().ID, c.Message().ID, c.Text())
+	return nil
+}
+
+func formatAccountHealth(h userbot.AccountHealth) string {
+	name := h.Name
+	if h.Username != "" {
+		name += " (@" + h.Username + ")"
+	}
+
+	var state string
+	switch {
+	case h.Status == database.AccountBanned:
+		state = "⛔ banned"
+	case h.Status == database.AccountLoggedOut:
+		state = "🔒 logged out"
+	case h.Status == database.AccountDisabled:
+		state = "⏸️ disabled"
+	case h.Running && h.Authorized:
+		state = "🟢 connected"
+	case h.Running:
+		state = "🟡 awaiting /login"
+	default:
+		state = "🔴 disconnected"
+	}
+
+	line := fmt.Sprintf("• %s: %s, %d chats", name, state, h.Chats)
+	if h.Restarts > 0 {
+		line += fmt.Sprintf(", %d restarts", h.Restarts)
+	}
+	if h.Throttle.Throttled {
+		line += "\n  ⏳ userbot " + h.Throttle.String()
+	}
+	if h.LastError != "" {
+		line += "\n  Last error: " + h.LastError
+	}
+	return line + "\n"
+}
+
+// formatDuration renders a duration as "3d 14h 22m"
+func formatDuration(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	}
+	if hours > 0 {
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+func formatBackfill(job *database.BackfillJob) string {
+	var icon, state string
+	switch job.Status {
+	case database.BackfillDone:
+		icon, state = "✅", "done"
+	case database.BackfillFailed:
+		icon, state = "❌", "failed: "+job.LastError.String
+	default:
+		icon, state = "⏳", "running"
+	}
+
+	return fmt.Sprintf("%s Backfill of chat %d since %s: %s\nFetched %d message(s), %d new",
+		icon, job.ChatID, job.Since.Format("2006-01-02"), state, job.Fetched, job.Stored)
+}
@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+
+	"telemonitor/internal/database"
+)
+
+const (
+	// defaultRenameDays is the period /renames looks at without an argument
+	defaultRenameDays = 7
+	// maxRenamedSenders is how many senders /renames lists
+	maxRenamedSenders = 20
+)
+
+// handleSender shows a sender and the names they used before:
+// /sender <user_id|@username>
+func (b *Bot) handleSender(c telebot.Context) error {
+	args := c.Args()
+	if len(args) != 1 {
+		return c.Send("Usage: /sender <user_id|@username>")
+	}
+
+	var (
+		s   *database.Sender
+		err error
+	)
+	if id, parseErr := strconv.ParseInt(args[0], 10, 64); parseErr == nil {
+		s, err = b.repos.Senders.GetByID(id)
+	} else {
+		s, err = b.repos.Senders.GetByUsername(args[0])
+	}
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to get sender: %v", err))
+	}
+	if s == nil {
+		return c.Send(fmt.Sprintf("❌ Sender %s not seen yet", args[0]))
+	}
+
+	history, err := b.repos.Senders.GetHistory(s.UserID)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to get sender history: %v", err))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "👤 %s\n", s)
+	fmt.Fprintf(&sb, "ID: %d\n", s.UserID)
+	if s.IsPremium {
+		sb.WriteString("Premium: yes\n")
+	}
+	fmt.Fprintf(&sb, "First seen: %s\n", s.FirstSeen.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&sb, "Last seen: %s\n", s.LastSeen.Format("2006-01-02 15:04"))
+
+	if len(history) > 0 {
+		fmt.Fprintf(&sb, "\nPrevious names (%d):\n", len(history))
+		for _, n := range history {
+			fmt.Fprintf(&sb, "• until %s: %s\n", n.ReplacedAt.Format("2006-01-02 15:04"), formatSenderName(n))
+		}
+	}
+	return c.Send(sb.String())
+}
+
+// handleRenames lists the senders that changed their name recently:
+// /renames [days]
+func (b *Bot) handleRenames(c telebot.Context) error {
+	days := defaultRenameDays
+	if args := c.Args(); len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > maxBackfillDays {
+			return c.Send(fmt.Sprintf("❌ Days must be between 1 and %d", maxBackfillDays))
+		}
+		days = n
+	}
+
+	senders, err := b.repos.Senders.GetRenamed(time.Now().AddDate(0, 0, -days), maxRenamedSenders)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to list renamed senders: %v", err))
+	}
+	if len(senders) == 0 {
+		return c.Send(fmt.Sprintf("No senders changed their name in the last %d days", days))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🔄 Renamed in the last %d days:\n\n", days)
+	for _, s := range senders {
+		fmt.Fprintf(&sb, "• %s — %d change(s), ID %d\n", &s.Sender, s.Renames, s.UserID)
+	}
+	sb.WriteString("\nDetails: /sender <user_id>")
+	return c.Send(sb.String())
+}
+
+// formatSenderName renders a previous name like database.Sender.String
+func formatSenderName(n *database.SenderName) string {
+	s := database.Sender{UserID: n.UserID, Username: n.Username, FirstName: n.FirstName, LastName: n.LastName}
+	return s.String()
+}
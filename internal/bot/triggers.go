@@ -0,0 +1,351 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+
+	"telemonitor/internal/database"
+	"telemonitor/internal/reactor"
+)
+
+// triggerUsage explains /add_trigger
+const triggerUsage = `Usage: /add_trigger <phrase> [level] [exact|word|stem|expression] [raw|normalized] [deleted:<minutes>]
+
+phrase: text to look for, /regex/ for a regular expression, or * for any message
+level: info (default), warning or critical
+exact|word|stem: find the phrase anywhere (default), as whole words, or as whole words in any inflection, so that "взлом" also finds "взломали"
+expression: the phrase combines words with AND, OR, NOT, NEAR/n and parentheses, e.g. (airdrop OR claim) AND NOT scam NEAR/5 wallet. Words are matched whole, "quoted phrases" as written and ~word in any inflection
+raw|normalized: match the text as written, or normalized (Unicode forms, ё as е, look-alike Latin and Cyrillic letters, invisible characters, emoji and repeated punctuation folded away). Phrases are normalized and regexes raw by default
+deleted:<minutes>: alert when a matching message is deleted within that many minutes of being posted, or whenever catch-up finds a deletion missed while offline`
+
+// triggerScopeUsage explains /trigger_scope
+const triggerScopeUsage = `Usage: /trigger_scope <id> [chat:<chat_id>,...] [exclude:<chat_id>,...] [sender:<user_id|@username>,...] [forwards]
+/trigger_scope <id> clear
+
+Without limits shows the scope of the trigger; with them replaces it, so the trigger only fires in the given chats, never in the excluded ones, only on messages of the given senders and only on forwarded messages. clear makes it fire everywhere again.`
+
+// handleTriggers lists the alert triggers
+func (b *Bot) handleTriggers(c telebot.Context) error {
+	triggers, err := b.repos.Triggers.GetAll()
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to list triggers: %v", err))
+	}
+	if len(triggers) == 0 {
+		return c.Send("No triggers yet. Add one with /add_trigger")
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🔔 Triggers (%d):\n\n", len(triggers))
+	for _, t := range triggers {
+		sb.WriteString(formatTrigger(t) + "\n")
+	}
+	return c.Send(sb.String())
+}
+
+// handleAddTrigger creates a trigger: see triggerUsage
+func (b *Bot) handleAddTrigger(c telebot.Context) error {
+	if strings.TrimSpace(c.Message().Payload) == "" {
+		return c.Send(triggerUsage)
+	}
+	t, err := parseTrigger(c.Message().Payload)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ %v\n\n%s", err, triggerUsage))
+	}
+
+	if err := b.repos.Triggers.Create(t); err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to add trigger: %v", err))
+	}
+	b.refreshTriggers()
+	return c.Send("✅ Added " + formatTrigger(t))
+}
+
+// handleDelTrigger removes a trigger: /del_trigger <id>
+func (b *Bot) handleDelTrigger(c telebot.Context) error {
+	args := c.Args()
+	if len(args) != 1 {
+		return c.Send("Usage: /del_trigger <id>")
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil {
+		return c.Send("❌ Invalid trigger ID")
+	}
+
+	t, err := b.repos.Triggers.GetByID(id)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to get trigger: %v", err))
+	}
+	if t == nil {
+		return c.Send(fmt.Sprintf("❌ Trigger #%d not found", id))
+	}
+	if err := b.repos.Triggers.Delete(id); err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to remove trigger: %v", err))
+	}
+	b.refreshTriggers()
+	return c.Send("🗑 Removed " + formatTrigger(t))
+}
+
+// handleTriggerScope shows or replaces the scope of a trigger: see
+// triggerScopeUsage
+func (b *Bot) handleTriggerScope(c telebot.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return c.Send(triggerScopeUsage)
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil {
+		return c.Send("❌ Invalid trigger ID")
+	}
+	t, err := b.repos.Triggers.GetByID(id)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to get trigger: %v", err))
+	}
+	if t == nil {
+		return c.Send(fmt.Sprintf("❌ Trigger #%d not found", id))
+	}
+	if len(args) == 1 {
+		return c.Send(formatTrigger(t) + "\n\n" + b.describeScope(t.Scope))
+	}
+
+	var scope database.TriggerScope
+	if !(len(args) == 2 && args[1] == "clear") {
+		if scope, err = b.parseScope(args[1:]); err != nil {
+			return c.Send(fmt.Sprintf("❌ %v\n\n%s", err, triggerScopeUsage))
+		}
+	}
+	if err := b.repos.Triggers.SetScope(t.ID, scope); err != nil {
+		return c.Send(fmt.Sprintf("❌ Failed to set trigger scope: %v", err))
+	}
+	b.refreshTriggers()
+	t.Scope = scope
+	return c.Send("✅ " + formatTrigger(t) + "\n\n" + b.describeScope(scope))
+}
+
+// parseScope reads the limits of /trigger_scope, looking senders given by
+// username up in the senders directory
+func (b *Bot) parseScope(args []string) (database.TriggerScope, error) {
+	var scope database.TriggerScope
+	for _, arg := range args {
+		if arg == "forwards" {
+			scope.ForwardsOnly = true
+			continue
+		}
+		kind, values, ok := strings.Cut(arg, ":")
+		if !ok || values == "" {
+			return scope, fmt.Errorf("unknown limit %q", arg)
+		}
+		for _, value := range strings.Split(values, ",") {
+			if kind == "sender" {
+				senderID, err := b.resolveSender(value)
+				if err != nil {
+					return scope, err
+				}
+				scope.SenderIDs = append(scope.SenderIDs, senderID)
+				continue
+			}
+
+			chatID, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return scope, fmt.Errorf("invalid chat ID %q", value)
+			}
+			switch kind {
+			case "chat":
+				scope.ChatIDs = append(scope.ChatIDs, chatID)
+			case "exclude":
+				scope.ExcludedChatIDs = append(scope.ExcludedChatIDs, chatID)
+			default:
+				return scope, fmt.Errorf("unknown limit %q", arg)
+			}
+		}
+	}
+
+	for _, id := range scope.ExcludedChatIDs {
+		if slices.Contains(scope.ChatIDs, id) {
+			return scope, fmt.Errorf("chat %d is both included and excluded", id)
+		}
+	}
+	return scope, nil
+}
+
+// resolveSender reads a user ID, or a username of a sender seen before
+func (b *Bot) resolveSender(value string) (int64, error) {
+	if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return id, nil
+	}
+	if !strings.HasPrefix(value, "@") {
+		return 0, fmt.Errorf("invalid sender %q, expected a user ID or @username", value)
+	}
+	s, err := b.repos.Senders.GetByUsername(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get sender: %w", err)
+	}
+	if s == nil {
+		return 0, fmt.Errorf("sender %s not seen yet, use their user ID", value)
+	}
+	return s.UserID, nil
+}
+
+// describeScope renders a trigger scope with chat and sender names
+func (b *Bot) describeScope(scope database.TriggerScope) string {
+	if scope.IsEmpty() {
+		return "Scope: every message"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Scope:\n")
+	if len(scope.ChatIDs) > 0 {
+		sb.WriteString("• only in: " + b.chatNames(scope.ChatIDs) + "\n")
+	}
+	if len(scope.ExcludedChatIDs) > 0 {
+		sb.WriteString("• not in: " + b.chatNames(scope.ExcludedChatIDs) + "\n")
+	}
+	if len(scope.SenderIDs) > 0 {
+		names := make([]string, len(scope.SenderIDs))
+		for i, id := range scope.SenderIDs {
+			names[i] = strconv.FormatInt(id, 10)
+			if s, err := b.repos.Senders.GetByID(id); err == nil && s != nil {
+				names[i] = fmt.Sprintf("%s (%d)", s, id)
+			}
+		}
+		sb.WriteString("• only from: " + strings.Join(names, ", ") + "\n")
+	}
+	if scope.ForwardsOnly {
+		sb.WriteString("• forwarded messages only\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// chatNames lists chats by title and ID
+func (b *Bot) chatNames(ids []int64) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = b.sourceName(id, "")
+	}
+	return strings.Join(names, ", ")
+}
+
+// refreshTriggers makes the reactor pick up a trigger change without
+// waiting for the database notification
+func (b *Bot) refreshTriggers() {
+	if b.reactor == nil {
+		return
+	}
+	if err := b.reactor.Refresh(); err != nil {
+		log.Printf("Failed to refresh triggers: %v", err)
+	}
+}
+
+// parseTrigger reads the arguments of /add_trigger. Options are taken from
+// the end of the payload so the phrase may contain spaces.
+func parseTrigger(payload string) (*database.Trigger, error) {
+	t := &database.Trigger{AlertLevel: "info", Event: database.TriggerOnMessage}
+
+	rest := strings.TrimSpace(payload)
+	textMode, matchMode := "", ""
+	for {
+		i := strings.LastIndexAny(rest, " \t\n")
+		if i < 0 {
+			break
+		}
+		switch token := rest[i+1:]; token {
+		case "raw", "normalized":
+			textMode = token
+			rest = strings.TrimSpace(rest[:i])
+			continue
+		case database.MatchExact, database.MatchWord, database.MatchStem, database.MatchExpression:
+			matchMode = token
+			rest = strings.TrimSpace(rest[:i])
+			continue
+		}
+		ok, err := parseTriggerOption(t, rest[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		rest = strings.TrimSpace(rest[:i])
+	}
+
+	t.MatchMode = database.MatchExact
+	if matchMode != "" {
+		t.MatchMode = matchMode
+	}
+	switch {
+	case rest == "*":
+		rest = ""
+	case len(rest) > 2 && strings.HasPrefix(rest, "/") && strings.HasSuffix(rest, "/"):
+		if matchMode != "" {
+			return nil, fmt.Errorf("a /regex/ cannot also be a %q match", matchMode)
+		}
+		rest = rest[1 : len(rest)-1]
+		t.MatchMode = database.MatchRegex
+	}
+	if rest == "" && t.Event == database.TriggerOnMessage {
+		return nil, errors.New("a trigger on every message needs deleted:<minutes>")
+	}
+	t.Phrase = rest
+	t.Normalize = textMode == "normalized" || textMode == "" && t.MatchMode != database.MatchRegex
+	if err := reactor.Check(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseTriggerOption applies token to t if it is an option
+func parseTriggerOption(t *database.Trigger, token string) (bool, error) {
+	switch token {
+	case "info", "warning", "critical":
+		t.AlertLevel = token
+		return true, nil
+	}
+
+	value, ok := strings.CutPrefix(token, "deleted:")
+	if !ok {
+		return false, nil
+	}
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes < 1 {
+		return false, fmt.Errorf("invalid deletion window %q, expected minutes", value)
+	}
+	t.Event = database.TriggerOnDeleted
+	t.WithinMinutes = sql.NullInt64{Int64: int64(minutes), Valid: true}
+	return true, nil
+}
+
+func formatTrigger(t *database.Trigger) string {
+	phrase := fmt.Sprintf("%q", t.Phrase)
+	switch {
+	case t.Phrase == "":
+		phrase = "any message"
+	case t.MatchMode == database.MatchRegex:
+		phrase = "/" + t.Phrase + "/"
+	case t.MatchMode == database.MatchWord, t.MatchMode == database.MatchStem:
+		phrase += " (" + t.MatchMode + ")"
+	case t.MatchMode == database.MatchExpression:
+		phrase = t.Phrase + " (expression)"
+	}
+
+	line := fmt.Sprintf("#%d [%s] %s", t.ID, t.AlertLevel, phrase)
+	// Only the choice that is not the default is worth showing
+	switch {
+	case t.Phrase == "":
+	case t.MatchMode == database.MatchRegex && t.Normalize:
+		line += ", normalized"
+	case t.MatchMode != database.MatchRegex && !t.Normalize:
+		line += ", raw"
+	}
+	if t.Event == database.TriggerOnDeleted {
+		line += fmt.Sprintf(", deleted within %d min", t.WithinMinutes.Int64)
+	}
+	if !t.Scope.IsEmpty() {
+		line += ", scoped"
+	}
+	return line
+}
@@ -0,0 +1,52 @@
+package bot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"telemonitor/internal/database"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		args []string
+		want database.TriggerScope
+		err  string
+	}{
+		{args: nil, want: database.TriggerScope{}},
+		{args: []string{"forwards"}, want: database.TriggerScope{ForwardsOnly: true}},
+		{
+			args: []string{"chat:-1001,-1002", "exclude:-1003"},
+			want: database.TriggerScope{ChatIDs: []int64{-1001, -1002}, ExcludedChatIDs: []int64{-1003}},
+		},
+		{
+			args: []string{"sender:42,43", "chat:-1001", "forwards"},
+			want: database.TriggerScope{ChatIDs: []int64{-1001}, SenderIDs: []int64{42, 43}, ForwardsOnly: true},
+		},
+		{args: []string{"chat:-1001", "exclude:-1002,-1001"}, err: "chat -1001 is both included and excluded"},
+		{args: []string{"chat:abc"}, err: `invalid chat ID "abc"`},
+		{args: []string{"chat:"}, err: `unknown limit "chat:"`},
+		{args: []string{"everywhere"}, err: `unknown limit "everywhere"`},
+		{args: []string{"topic:5"}, err: `unknown limit "topic:5"`},
+		{args: []string{"sender:alice"}, err: `invalid sender "alice", expected a user ID or @username`},
+	}
+	b := &Bot{}
+	for _, tt := range tests {
+		got, err := b.parseScope(tt.args)
+		args := strings.Join(tt.args, " ")
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseScope(%q) error = %v, want %q", args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseScope(%q): %v", args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseScope(%q) = %+v, want %+v", args, got, tt.want)
+		}
+	}
+}
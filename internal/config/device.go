@@ -0,0 +1,72 @@
+package config
+
+import "sort"
+
+// DefaultDeviceProfile is used when telegram.device_profile is not set
+const DefaultDeviceProfile = "desktop-windows"
+
+// DeviceProfile is a consistent set of values the userbot reports to
+// Telegram in initConnection. Mixing values from different official apps
+// (e.g. a Desktop app version with an Android system string) makes the
+// session stand out, so they are only configurable as a whole.
+type DeviceProfile struct {
+	Name           string
+	DeviceModel    string
+	SystemVersion  string
+	AppVersion     string
+	LangCode       string
+	SystemLangCode string
+	LangPack       string
+}
+
+// DeviceProfiles are the fingerprints selectable via telegram.device_profile.
+// Accounts keep the profile they first logged in with, so editing an entry
+// here changes the fingerprint of existing sessions.
+var DeviceProfiles = map[string]DeviceProfile{
+	"desktop-windows": {
+		Name:           "desktop-windows",
+		DeviceModel:    "Desktop",
+		SystemVersion:  "Windows 10",
+		AppVersion:     "4.9.0",
+		LangCode:       "en",
+		SystemLangCode: "en-US",
+		LangPack:       "tdesktop",
+	},
+	"desktop-macos": {
+		Name:           "desktop-macos",
+		DeviceModel:    "MacBook Pro",
+		SystemVersion:  "macOS 13.4",
+		AppVersion:     "4.9.0",
+		LangCode:       "en",
+		SystemLangCode: "en-US",
+		LangPack:       "tdesktop",
+	},
+	"android": {
+		Name:           "android",
+		DeviceModel:    "Samsung SM-G991B",
+		SystemVersion:  "SDK 33",
+		AppVersion:     "10.2.0 (40133)",
+		LangCode:       "en",
+		SystemLangCode: "en-us",
+		LangPack:       "android",
+	},
+	"ios": {
+		Name:           "ios",
+		DeviceModel:    "iPhone 14 Pro",
+		SystemVersion:  "17.1",
+		AppVersion:     "10.2 (27078)",
+		LangCode:       "en",
+		SystemLangCode: "en-US",
+		LangPack:       "ios",
+	},
+}
+
+// DeviceProfileNames returns the available profile names in sorted order
+func DeviceProfileNames() []string {
+	names := make([]string, 0, len(DeviceProfiles))
+	for name := range DeviceProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -5,8 +5,6 @@ import (
 	"embed"
 	"fmt"
 	"log"
-	"path/filepath"
-	"sort"
 
 	_ "github.com/lib/pq"
 	"telemonitor/internal/config"
@@ -44,43 +42,6 @@ func NewConnection(cfg config.DatabaseConfig) (*DB, error) {
 	return &DB{db}, nil
 }
 
-// RunMigrations runs all SQL migrations in order
-func RunMigrations(db *DB) error {
-	log.Println("Running database migrations...")
-
-	// Read all migration files
-	entries, err := migrationsFS.ReadDir("migrations")
-	if err != nil {
-		return fmt.Errorf("failed to read migrations directory: %w", err)
-	}
-
-	// Sort migration files by name (they're numbered)
-	var migrationFiles []string
-	for _, entry := range entries {
-		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".sql" {
-			migrationFiles = append(migrationFiles, entry.Name())
-		}
-	}
-	sort.Strings(migrationFiles)
-
-	// Execute each migration
-	for _, fileName := range migrationFiles {
-		log.Printf("Applying migration: %s", fileName)
-
-		content, err := migrationsFS.ReadFile(filepath.Join("migrations", fileName))
-		if err != nil {
-			return fmt.Errorf("failed to read migration %s: %w", fileName, err)
-		}
-
-		if _, err := db.Exec(string(content)); err != nil {
-			return fmt.Errorf("failed to execute migration %s: %w", fileName, err)
-		}
-	}
-
-	log.Println("All migrations applied successfully")
-	return nil
-}
-
 // Close closes the database connection
 func (db *DB) Close() error {
 	if db.DB != nil {
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// serviceLockID is the pg_advisory_lock key held while the service runs.
+// The service takes it shared so several instances may share a database;
+// commands that drive the userbots themselves take it exclusively.
+const serviceLockID int64 = 0x74656c656d7376 // "telemsv"
+
+// ErrServiceLocked is returned when the service lock is held in a mode that
+// conflicts with the requested one
+var ErrServiceLocked = errors.New("service lock is held")
+
+// ServiceLock is a held service lock, bound to its own connection
+type ServiceLock struct {
+	conn      *sql.Conn
+	exclusive bool
+}
+
+// LockService takes the service lock without waiting. It returns
+// ErrServiceLocked when the service is running and exclusive is set, or when
+// an exclusive holder is running and it is not.
+func LockService(ctx context.Context, db *DB, exclusive bool) (*ServiceLock, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock connection: %w", err)
+	}
+
+	query := `SELECT pg_try_advisory_lock_shared($1)`
+	if exclusive {
+		query = `SELECT pg_try_advisory_lock($1)`
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, query, serviceLockID).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire service lock: %w", err)
+	}
+	if !locked {
+		conn.Close()
+		return nil, ErrServiceLocked
+	}
+
+	return &ServiceLock{conn: conn, exclusive: exclusive}, nil
+}
+
+// Release gives the lock up and returns its connection to the pool
+func (l *ServiceLock) Release() {
+	query := `SELECT pg_advisory_unlock_shared($1)`
+	if l.exclusive {
+		query = `SELECT pg_advisory_unlock($1)`
+	}
+	if _, err := l.conn.ExecContext(context.Background(), query, serviceLockID); err != nil {
+		log.Printf("Failed to release service lock: %v", err)
+	}
+	l.conn.Close()
+}
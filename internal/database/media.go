@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Media types
+const (
+	MediaPhoto     = "photo"
+	MediaVideo     = "video"
+	MediaVideoNote = "video_note"
+	MediaVoice     = "voice"
+	MediaAudio     = "audio"
+	MediaAnimation = "animation"
+	MediaSticker   = "sticker"
+	MediaDocument  = "document"
+	MediaPoll      = "poll"
+	MediaGeo       = "geo"
+	MediaGeoLive   = "geo_live"
+	MediaVenue     = "venue"
+	MediaContact   = "contact"
+	MediaWebPage   = "webpage"
+	MediaDice      = "dice"
+	MediaStory     = "story"
+	MediaOther     = "other"
+)
+
+// Media describes what a non-text message carried. It is stored as JSONB in
+// raw_messages.media; the zero value means the message had no media and is
+// stored as NULL.
+type Media struct {
+	Type string `json:"type"`
+
+	// Files
+	FileName string `json:"file_name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	// Duration of audio and video in seconds
+	Duration int `json:"duration,omitempty"`
+
+	// Polls
+	Question string   `json:"question,omitempty"`
+	Options  []string `json:"options,omitempty"`
+
+	// Locations and venues
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	Address   string  `json:"address,omitempty"`
+
+	// Contacts
+	Phone string `json:"phone,omitempty"`
+
+	// Title is the web page, venue, audio track or contact name; URL is the
+	// link of a web page preview
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// Valid reports whether the message had media
+func (m Media) Valid() bool {
+	return m.Type != ""
+}
+
+// Value implements driver.Valuer. The JSON is returned as a string: pq
+// writes []byte in COPY as a bytea literal, which jsonb does not accept.
+func (m Media) Value() (driver.Value, error) {
+	if !m.Valid() {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Equal reports whether m and o are stored as the same JSON
+func (m Media) Equal(o Media) bool {
+	a, errA := m.Value()
+	b, errB := o.Value()
+	return errA == nil && errB == nil && a == b
+}
+
+// Scan implements sql.Scanner
+func (m *Media) Scan(src any) error {
+	*m = Media{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("cannot scan %T into Media", src)
+	}
+}
+
+// String renders the media as a short line, e.g.
+// "document report.pdf (application/pdf, 1.2 MB)"
+func (m Media) String() string {
+	if !m.Valid() {
+		return ""
+	}
+
+	parts := []string{m.Type}
+	switch m.Type {
+	case MediaPoll:
+		parts = append(parts, fmt.Sprintf("%q: %s", m.Question, strings.Join(m.Options, " / ")))
+	case MediaGeo, MediaGeoLive:
+		parts = append(parts, fmt.Sprintf("%.5f, %.5f", m.Latitude, m.Longitude))
+	case MediaVenue:
+		parts = append(parts, m.Title, m.Address, fmt.Sprintf("(%.5f, %.5f)", m.Latitude, m.Longitude))
+	case MediaContact:
+		parts = append(parts, m.Title, m.Phone)
+	case MediaWebPage:
+		parts = append(parts, m.Title, m.URL)
+	default:
+		parts = append(parts, m.FileName, m.Title)
+		var details []string
+		if m.MimeType != "" {
+			details = append(details, m.MimeType)
+		}
+		if m.Size > 0 {
+			details = append(details, formatSize(m.Size))
+		}
+		if m.Duration > 0 {
+			details = append(details, fmt.Sprintf("%d:%02d", m.Duration/60, m.Duration%60))
+		}
+		if len(details) > 0 {
+			parts = append(parts, "("+strings.Join(details, ", ")+")")
+		}
+	}
+
+	var nonEmpty []string
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, " ")
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
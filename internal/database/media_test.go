@@ -0,0 +1,47 @@
+package database
+
+import "testing"
+
+func TestMediaValue(t *testing.T) {
+	v, err := Media{}.Value()
+	if err != nil || v != nil {
+		t.Errorf("Value of no media = %v, %v, want nil", v, err)
+	}
+
+	m := Media{Type: MediaDocument, FileName: "report.pdf", Size: 2048}
+	v, err = m.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A string, as pq writes []byte in COPY as bytea
+	s, ok := v.(string)
+	if !ok {
+		t.Fatalf("Value is %T, want string", v)
+	}
+	if want := `{"type":"document","file_name":"report.pdf","size":2048}`; s != want {
+		t.Errorf("Value = %s, want %s", s, want)
+	}
+
+	var back Media
+	if err := back.Scan(s); err != nil {
+		t.Fatal(err)
+	}
+	if back.String() != m.String() {
+		t.Errorf("scanned back as %q, want %q", back, m)
+	}
+}
+
+func TestMediaEqual(t *testing.T) {
+	poll := Media{Type: MediaPoll, Question: "Lunch?", Options: []string{"yes", "no"}}
+	same := Media{Type: MediaPoll, Question: "Lunch?", Options: []string{"yes", "no"}}
+	if !poll.Equal(same) {
+		t.Error("identical polls are not equal")
+	}
+	same.Options[1] = "later"
+	if poll.Equal(same) {
+		t.Error("polls with different options are equal")
+	}
+	if (Media{}).Equal(poll) {
+		t.Error("no media equals a poll")
+	}
+}
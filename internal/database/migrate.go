@@ -0,0 +1,356 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationLockID is the pg_advisory_lock key guarding schema changes so that
+// two instances booting at the same time never migrate concurrently
+const migrationLockID int64 = 0x74656c656d6f6e // "telemon"
+
+const createSchemaMigrations = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)
+`
+
+// Migration is a single versioned schema change with its rollback script
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// AppliedMigration is a row of the schema_migrations ledger
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt sql.NullTime
+}
+
+// LoadMigrations reads the embedded migration files and pairs every
+// NNN_name.sql with its NNN_name.down.sql, sorted by version
+func LoadMigrations() ([]*Migration, error) {
+	return loadMigrations(migrationsFS)
+}
+
+// loadMigrations reads the migrations directory of fsys
+func loadMigrations(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || path.Ext(fileName) != ".sql" {
+			continue
+		}
+
+		isDown := strings.HasSuffix(fileName, ".down.sql")
+		base := strings.TrimSuffix(strings.TrimSuffix(fileName, ".sql"), ".down")
+
+		prefix, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNN_name.sql", fileName)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version prefix: %w", fileName, err)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join("migrations", fileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", fileName, err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %03d has conflicting names %q and %q", version, m.Name, name)
+		}
+
+		if isDown {
+			m.Down = string(content)
+		} else {
+			if m.Up != "" {
+				return nil, fmt.Errorf("duplicate migration version %03d", version)
+			}
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %03d_%s has a down file but no up file", m.Version, m.Name)
+		}
+		if m.Down == "" {
+			return nil, fmt.Errorf("migration %03d_%s is missing its .down.sql file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// RunMigrations applies all pending migrations in order. Each migration runs
+// in its own transaction together with its schema_migrations ledger row.
+// It refuses to proceed if an already applied migration file was modified.
+func RunMigrations(db *DB) error {
+	log.Println("Running database migrations...")
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	return withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := verifyChecksums(migrations, applied); err != nil {
+			return err
+		}
+
+		count := 0
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+
+			log.Printf("Applying migration: %03d_%s", m.Version, m.Name)
+			if err := applyMigration(ctx, conn, m); err != nil {
+				return err
+			}
+			count++
+		}
+
+		if count == 0 {
+			log.Println("Database schema is up to date")
+		} else {
+			log.Printf("Applied %d migration(s) successfully", count)
+		}
+		return nil
+	})
+}
+
+// RollbackMigrations reverts the most recently applied migrations, newest
+// first, by running their .down.sql scripts
+func RollbackMigrations(db *DB, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("rollback steps must be positive, got %d", steps)
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	byVersion := make(map[int]*Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	ctx := context.Background()
+	return withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := verifyChecksums(migrations, applied); err != nil {
+			return err
+		}
+
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		if steps > len(versions) {
+			steps = len(versions)
+		}
+		for _, version := range versions[:steps] {
+			m, ok := byVersion[version]
+			if !ok {
+				return fmt.Errorf("cannot roll back migration %03d: no migration file embedded", version)
+			}
+
+			log.Printf("Rolling back migration: %03d_%s", m.Version, m.Name)
+			if err := revertMigration(ctx, conn, m); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Rolled back %d migration(s)", steps)
+		return nil
+	})
+}
+
+// GetAppliedMigrations returns the schema_migrations ledger ordered by version
+func GetAppliedMigrations(db *DB) ([]*AppliedMigration, error) {
+	rows, err := db.Query(`
+		SELECT version, name, checksum, applied_at
+		FROM schema_migrations
+		ORDER BY version
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*AppliedMigration
+	for rows.Next() {
+		am := &AppliedMigration{}
+		if err := rows.Scan(&am.Version, &am.Name, &am.Checksum, &am.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		result = append(result, am)
+	}
+
+	return result, rows.Err()
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the session
+// level advisory lock; the lock is bound to that connection
+func withMigrationLock(ctx context.Context, db *DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			log.Printf("Failed to release migration lock: %v", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]*AppliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]*AppliedMigration)
+	for rows.Next() {
+		am := &AppliedMigration{}
+		if err := rows.Scan(&am.Version, &am.Name, &am.Checksum, &am.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[am.Version] = am
+	}
+
+	return applied, rows.Err()
+}
+
+// verifyChecksums fails if an applied migration no longer matches the file
+// shipped in the binary
+func verifyChecksums(migrations []*Migration, applied map[int]*AppliedMigration) error {
+	for _, m := range migrations {
+		am, ok := applied[m.Version]
+		if !ok {
+			continue
+		}
+		if am.Checksum != m.Checksum {
+			return fmt.Errorf(
+				"migration %03d_%s was modified after being applied (file checksum %s, recorded %s); add a new migration instead",
+				m.Version, m.Name, m.Checksum, am.Checksum,
+			)
+		}
+	}
+
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	for version, am := range applied {
+		if !known[version] {
+			log.Printf("Warning: database has migration %03d_%s applied that this build does not know about", version, am.Name)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m *Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %03d: %w", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+		return fmt.Errorf("failed to execute migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+		m.Version, m.Name, m.Checksum,
+	); err != nil {
+		return fmt.Errorf("failed to record migration %03d: %w", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %03d: %w", m.Version, err)
+	}
+	return nil
+}
+
+func revertMigration(ctx context.Context, conn *sql.Conn, m *Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin rollback of %03d: %w", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+		return fmt.Errorf("failed to roll back migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+		return fmt.Errorf("failed to remove migration %03d from ledger: %w", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rollback of %03d: %w", m.Version, err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// migrationFiles builds a file system holding the given migration files
+func migrationFiles(names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		fsys["migrations/"+name] = &fstest.MapFile{Data: []byte("-- " + name)}
+	}
+	return fsys
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+		err   string
+	}{
+		{
+			name:  "sorted by version",
+			files: migrationFiles("010_c.sql", "010_c.down.sql", "002_b.sql", "002_b.down.sql", "001_a.sql", "001_a.down.sql"),
+			want:  "1:a 2:b 10:c",
+		},
+		{
+			name:  "other files ignored",
+			files: migrationFiles("001_a.sql", "001_a.down.sql", "README.md"),
+			want:  "1:a",
+		},
+		{
+			name:  "name with underscores",
+			files: migrationFiles("003_add_message_media.sql", "003_add_message_media.down.sql"),
+			want:  "3:add_message_media",
+		},
+		{
+			name:  "missing down file",
+			files: migrationFiles("001_a.sql", "001_a.down.sql", "002_b.sql"),
+			err:   "migration 002_b is missing its .down.sql file",
+		},
+		{
+			name:  "missing up file",
+			files: migrationFiles("001_a.down.sql"),
+			err:   "migration 001_a has a down file but no up file",
+		},
+		{
+			name:  "duplicate version",
+			files: migrationFiles("001_a.sql", "1_a.sql", "001_a.down.sql"),
+			err:   "duplicate migration version 001",
+		},
+		{
+			name:  "conflicting names",
+			files: migrationFiles("001_a.sql", "001_b.sql"),
+			err:   `migration 001 has conflicting names`,
+		},
+		{
+			name:  "no version",
+			files: migrationFiles("init.sql"),
+			err:   "migration init.sql: expected NNN_name.sql",
+		},
+		{
+			name:  "invalid version",
+			files: migrationFiles("v1_a.sql"),
+			err:   "migration v1_a.sql: invalid version prefix",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("loadMigrations error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, len(migrations))
+			for i, m := range migrations {
+				got[i] = fmt.Sprintf("%d:%s", m.Version, m.Name)
+				if m.Up == "" || m.Down == "" || len(m.Checksum) != 64 {
+					t.Errorf("migration %s loaded incompletely: %+v", got[i], m)
+				}
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("loadMigrations = %s, want %s", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d is version %03d, want consecutive versions from 001", i, m.Version)
+		}
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles("001_a.sql", "001_a.down.sql", "002_b.sql", "002_b.down.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := migrations[0].Checksum
+
+	tests := []struct {
+		name    string
+		applied map[int]*AppliedMigration
+		err     string
+	}{
+		{"nothing applied", map[int]*AppliedMigration{}, ""},
+		{"unchanged", map[int]*AppliedMigration{1: {Version: 1, Name: "a", Checksum: sum}}, ""},
+		{"unknown to the build", map[int]*AppliedMigration{1: {Version: 1, Name: "a", Checksum: sum}, 9: {Version: 9, Name: "z"}}, ""},
+		{"modified", map[int]*AppliedMigration{1: {Version: 1, Name: "a", Checksum: "0123"}}, "migration 001_a was modified after being applied"},
+	}
+	for _, tt := range tests {
+		err := verifyChecksums(migrations, tt.applied)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
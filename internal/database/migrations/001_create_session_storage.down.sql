@@ -0,0 +1,3 @@
+-- Rollback: Drop session_storage table
+
+DROP TABLE IF EXISTS session_storage;
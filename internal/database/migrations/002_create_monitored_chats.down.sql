@@ -0,0 +1,3 @@
+-- Rollback: Drop monitored_chats table
+
+DROP TABLE IF EXISTS monitored_chats;
@@ -0,0 +1,3 @@
+-- Rollback: Drop raw_messages table
+
+DROP TABLE IF EXISTS raw_messages;
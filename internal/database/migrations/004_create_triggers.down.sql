@@ -0,0 +1,3 @@
+-- Rollback: Drop triggers table
+
+DROP TABLE IF EXISTS triggers;
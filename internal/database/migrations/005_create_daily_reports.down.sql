@@ -0,0 +1,3 @@
+-- Rollback: Drop daily_reports table
+
+DROP TABLE IF EXISTS daily_reports;
@@ -0,0 +1,5 @@
+-- Rollback: Remove account assignment and accounts table
+
+ALTER TABLE monitored_chats DROP COLUMN IF EXISTS account_id;
+
+DROP TABLE IF EXISTS accounts;
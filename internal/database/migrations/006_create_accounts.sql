@@ -0,0 +1,28 @@
+-- Migration: Create accounts table and assign monitored chats to accounts
+-- Purpose: Run several userbot accounts, each reading its own subset of chats
+
+CREATE TABLE accounts (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    telegram_user_id BIGINT,
+    username TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CHECK (status IN ('active', 'logged_out', 'banned', 'disabled'))
+);
+
+-- Index for picking healthy accounts
+CREATE INDEX idx_accounts_status ON accounts(status);
+
+-- The single-account deployment stored its session as userbot:default
+INSERT INTO accounts (name) VALUES ('default');
+
+ALTER TABLE monitored_chats
+    ADD COLUMN account_id INTEGER REFERENCES accounts(id) ON DELETE SET NULL;
+
+UPDATE monitored_chats SET account_id = (SELECT id FROM accounts WHERE name = 'default');
+
+-- Index for per-account chat lookups
+CREATE INDEX idx_monitored_chats_account_id ON monitored_chats(account_id);
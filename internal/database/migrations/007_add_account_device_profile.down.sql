@@ -0,0 +1,3 @@
+-- Rollback: Remove pinned device profiles
+
+ALTER TABLE accounts DROP COLUMN IF EXISTS device_profile;
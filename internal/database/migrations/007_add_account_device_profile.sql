@@ -0,0 +1,5 @@
+-- Migration: Pin a device profile to each account
+-- Purpose: A session keeps the fingerprint it first logged in with, even if
+-- telegram.device_profile changes later
+
+ALTER TABLE accounts ADD COLUMN device_profile TEXT;
@@ -0,0 +1,3 @@
+-- Rollback: Drop backfill_jobs table
+
+DROP TABLE IF EXISTS backfill_jobs;
@@ -0,0 +1,22 @@
+-- Migration: Create backfill_jobs table
+-- Purpose: Track historical imports so they resume after a restart
+
+CREATE TABLE backfill_jobs (
+    id SERIAL PRIMARY KEY,
+    chat_id BIGINT NOT NULL REFERENCES monitored_chats(chat_id) ON DELETE CASCADE,
+    since TIMESTAMP NOT NULL,
+    offset_id INTEGER NOT NULL DEFAULT 0,
+    fetched INTEGER NOT NULL DEFAULT 0,
+    stored INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CHECK (status IN ('running', 'done', 'failed'))
+);
+
+-- Index for resuming unfinished jobs on startup
+CREATE INDEX idx_backfill_jobs_status ON backfill_jobs(status) WHERE status <> 'done';
+
+-- Index for per-chat lookups
+CREATE INDEX idx_backfill_jobs_chat_id ON backfill_jobs(chat_id);
@@ -0,0 +1,13 @@
+-- Rollback: Stop tracking message edits and deletions
+
+ALTER TABLE triggers
+    DROP CONSTRAINT IF EXISTS triggers_within_minutes_check,
+    DROP CONSTRAINT IF EXISTS triggers_event_check,
+    DROP COLUMN IF EXISTS within_minutes,
+    DROP COLUMN IF EXISTS event;
+
+DROP TABLE IF EXISTS raw_message_versions;
+
+ALTER TABLE raw_messages
+    DROP COLUMN IF EXISTS deleted_at,
+    DROP COLUMN IF EXISTS edited_at;
@@ -0,0 +1,32 @@
+-- Migration: Track message edits and deletions
+-- Purpose: Keep every prior text of an edited message, mark deleted messages
+-- and let triggers fire when a message disappears soon after posting
+
+ALTER TABLE raw_messages
+    ADD COLUMN edited_at TIMESTAMP,
+    ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE TABLE raw_message_versions (
+    id SERIAL PRIMARY KEY,
+    raw_message_id INTEGER NOT NULL REFERENCES raw_messages(id) ON DELETE CASCADE,
+    message_text TEXT,
+    written_at TIMESTAMP NOT NULL,
+    replaced_at TIMESTAMP NOT NULL,
+    recorded_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Index for listing the history of a message
+CREATE INDEX idx_raw_message_versions_message ON raw_message_versions(raw_message_id, replaced_at);
+
+-- Index for reports on recently deleted messages
+CREATE INDEX idx_raw_messages_deleted_at ON raw_messages(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- 'message' triggers match new and edited messages; 'deleted' triggers match
+-- messages deleted within within_minutes of being posted
+ALTER TABLE triggers
+    ADD COLUMN event VARCHAR(20) NOT NULL DEFAULT 'message',
+    ADD COLUMN within_minutes INTEGER,
+    ADD CONSTRAINT triggers_event_check CHECK (event IN ('message', 'deleted')),
+    ADD CONSTRAINT triggers_within_minutes_check CHECK (
+        (event = 'deleted' AND within_minutes > 0) OR (event = 'message' AND within_minutes IS NULL)
+    );
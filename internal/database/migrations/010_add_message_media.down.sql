@@ -0,0 +1,5 @@
+-- Rollback: Remove media metadata from raw_messages
+
+DROP INDEX IF EXISTS idx_raw_messages_media_type;
+
+ALTER TABLE raw_messages DROP COLUMN IF EXISTS media;
@@ -0,0 +1,8 @@
+-- Migration: Add media metadata to raw_messages
+-- Purpose: Describe photos, documents, polls, locations, contacts and link
+-- previews instead of reducing them to a caption
+
+ALTER TABLE raw_messages ADD COLUMN media JSONB;
+
+-- Index for filtering messages by media type
+CREATE INDEX idx_raw_messages_media_type ON raw_messages((media->>'type')) WHERE media IS NOT NULL;
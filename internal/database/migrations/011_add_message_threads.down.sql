@@ -0,0 +1,12 @@
+-- Rollback: Remove reply chains, threads and forum topics
+
+DROP TABLE IF EXISTS forum_topics;
+
+DROP INDEX IF EXISTS idx_raw_messages_topic;
+DROP INDEX IF EXISTS idx_raw_messages_reply_to_top;
+DROP INDEX IF EXISTS idx_raw_messages_reply_to;
+
+ALTER TABLE raw_messages
+    DROP COLUMN IF EXISTS topic_id,
+    DROP COLUMN IF EXISTS reply_to_top_id,
+    DROP COLUMN IF EXISTS reply_to_msg_id;
@@ -0,0 +1,25 @@
+-- Migration: Add reply chains, threads and forum topics
+-- Purpose: Keep the conversation structure of messages so reports can follow
+-- threads instead of raw chronology
+
+-- reply_to_msg_id is the message replied to in the same chat, reply_to_top_id
+-- the top message of a comment thread or forum topic, topic_id the forum
+-- topic the message was posted in
+ALTER TABLE raw_messages
+    ADD COLUMN reply_to_msg_id INTEGER,
+    ADD COLUMN reply_to_top_id INTEGER,
+    ADD COLUMN topic_id INTEGER;
+
+-- Indexes for walking threads
+CREATE INDEX idx_raw_messages_reply_to ON raw_messages(chat_id, reply_to_msg_id) WHERE reply_to_msg_id IS NOT NULL;
+CREATE INDEX idx_raw_messages_reply_to_top ON raw_messages(chat_id, reply_to_top_id) WHERE reply_to_top_id IS NOT NULL;
+CREATE INDEX idx_raw_messages_topic ON raw_messages(chat_id, topic_id) WHERE topic_id IS NOT NULL;
+
+-- Titles of forum topics, learned from topic service messages and catch-up
+CREATE TABLE forum_topics (
+    chat_id BIGINT NOT NULL REFERENCES monitored_chats(chat_id) ON DELETE CASCADE,
+    topic_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (chat_id, topic_id)
+);
@@ -0,0 +1,7 @@
+-- Rollback: Stop tracking voice message transcription
+
+DROP INDEX IF EXISTS idx_raw_messages_untranscribed;
+
+ALTER TABLE raw_messages
+    DROP COLUMN IF EXISTS transcript,
+    DROP COLUMN IF EXISTS transcription_error;
@@ -0,0 +1,14 @@
+-- Migration: Track voice message transcription
+-- Purpose: Let the transcription worker find pending voice and video notes
+-- after a restart, stop retrying the ones that cannot be transcribed and
+-- keep transcripts apart from the captions they are appended to
+
+-- message_text of a transcribed message is its caption followed by the
+-- transcript, which is also kept so an edited caption does not drop it
+ALTER TABLE raw_messages
+    ADD COLUMN transcription_error TEXT,
+    ADD COLUMN transcript TEXT;
+
+-- Index for the transcription queue
+CREATE INDEX idx_raw_messages_untranscribed ON raw_messages(created_at)
+    WHERE NOT is_transcribed AND transcription_error IS NULL AND media->>'type' IN ('voice', 'video_note');
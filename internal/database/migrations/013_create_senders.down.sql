@@ -0,0 +1,4 @@
+-- Rollback: Drop senders directory
+
+DROP TABLE IF EXISTS sender_name_history;
+DROP TABLE IF EXISTS senders;
@@ -0,0 +1,32 @@
+-- Migration: Create senders directory
+-- Purpose: Know who wrote a message beyond the name snapshot in raw_messages
+-- and keep the names an account used over time
+
+CREATE TABLE senders (
+    user_id BIGINT PRIMARY KEY,
+    username TEXT,
+    first_name TEXT,
+    last_name TEXT,
+    is_bot BOOLEAN NOT NULL DEFAULT false,
+    is_premium BOOLEAN NOT NULL DEFAULT false,
+    first_seen TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Index for looking senders up by username
+CREATE INDEX idx_senders_username ON senders(LOWER(username)) WHERE username IS NOT NULL;
+
+-- Names a sender had before a change; replaced_at is when the change was
+-- first seen
+CREATE TABLE sender_name_history (
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES senders(user_id) ON DELETE CASCADE,
+    username TEXT,
+    first_name TEXT,
+    last_name TEXT,
+    replaced_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Indexes for a sender's history and recent renames
+CREATE INDEX idx_sender_name_history_user ON sender_name_history(user_id, replaced_at);
+CREATE INDEX idx_sender_name_history_replaced_at ON sender_name_history(replaced_at);
@@ -0,0 +1,9 @@
+-- Rollback: Remove forward provenance
+
+DROP INDEX IF EXISTS idx_raw_messages_forward_post;
+DROP INDEX IF EXISTS idx_raw_messages_forward_from;
+
+ALTER TABLE raw_messages
+    DROP COLUMN IF EXISTS forward_date,
+    DROP COLUMN IF EXISTS forward_msg_id,
+    DROP COLUMN IF EXISTS forward_from_id;
@@ -0,0 +1,15 @@
+-- Migration: Add forward provenance
+-- Purpose: Know which upstream chat and post a forwarded message came from,
+-- so sources feeding a chat and posts spreading across chats can be found
+
+-- forward_from_id is the original chat or user in the same ID format as
+-- chat_id, forward_msg_id the original post when it came from a channel and
+-- forward_date when the original was posted
+ALTER TABLE raw_messages
+    ADD COLUMN forward_from_id BIGINT,
+    ADD COLUMN forward_msg_id INTEGER,
+    ADD COLUMN forward_date TIMESTAMP;
+
+-- Indexes for sources of a chat and copies of an original post
+CREATE INDEX idx_raw_messages_forward_from ON raw_messages(chat_id, forward_from_id) WHERE forward_from_id IS NOT NULL;
+CREATE INDEX idx_raw_messages_forward_post ON raw_messages(forward_from_id, forward_msg_id) WHERE forward_msg_id IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Rollback: Stop announcing trigger changes
+
+DROP TRIGGER IF EXISTS triggers_notify ON triggers;
+DROP FUNCTION IF EXISTS notify_triggers_changed();
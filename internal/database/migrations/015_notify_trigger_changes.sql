@@ -0,0 +1,16 @@
+-- Migration: Announce trigger changes
+-- Purpose: Let the reactor recompile its triggers as soon as they are written,
+-- whichever process or tool writes them
+
+CREATE OR REPLACE FUNCTION notify_triggers_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('triggers_changed', TG_OP);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- One notification per statement; NOTIFY is delivered on commit and
+-- identical ones in a transaction are folded together
+CREATE TRIGGER triggers_notify
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON triggers
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_triggers_changed();
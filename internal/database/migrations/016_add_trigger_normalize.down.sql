@@ -0,0 +1,3 @@
+-- Rollback: Remove per-trigger text normalization
+
+ALTER TABLE triggers DROP COLUMN IF EXISTS normalize;
@@ -0,0 +1,8 @@
+-- Migration: Add per-trigger text normalization
+-- Purpose: Match triggers against normalized text (NFKC, case, ё, homoglyphs,
+-- invisible characters, emoji, repeated punctuation) unless raw text is asked for
+
+ALTER TABLE triggers ADD COLUMN normalize BOOLEAN NOT NULL DEFAULT true;
+
+-- Existing regular expressions were written against the raw text
+UPDATE triggers SET normalize = false WHERE is_regex;
@@ -0,0 +1,10 @@
+-- Rollback: Remove trigger match modes
+-- Word and stem triggers fall back to matching their phrase anywhere
+
+ALTER TABLE triggers ADD COLUMN is_regex BOOLEAN DEFAULT FALSE;
+
+UPDATE triggers SET is_regex = (match_mode = 'regex');
+
+ALTER TABLE triggers
+    DROP CONSTRAINT IF EXISTS triggers_match_mode_check,
+    DROP COLUMN IF EXISTS match_mode;
@@ -0,0 +1,12 @@
+-- Migration: Add trigger match modes
+-- Purpose: Match a phrase anywhere in the text ('exact'), as whole words
+-- ('word'), in any inflection of its words ('stem') or as a regular
+-- expression ('regex'), replacing the is_regex flag
+
+ALTER TABLE triggers
+    ADD COLUMN match_mode VARCHAR(20) NOT NULL DEFAULT 'exact',
+    ADD CONSTRAINT triggers_match_mode_check CHECK (match_mode IN ('exact', 'word', 'stem', 'regex'));
+
+UPDATE triggers SET match_mode = 'regex' WHERE is_regex;
+
+ALTER TABLE triggers DROP COLUMN is_regex;
@@ -0,0 +1,7 @@
+-- Rollback: Remove boolean trigger expressions
+
+DELETE FROM triggers WHERE match_mode = 'expression';
+
+ALTER TABLE triggers
+    DROP CONSTRAINT IF EXISTS triggers_match_mode_check,
+    ADD CONSTRAINT triggers_match_mode_check CHECK (match_mode IN ('exact', 'word', 'stem', 'regex'));
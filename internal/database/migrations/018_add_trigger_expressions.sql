@@ -0,0 +1,7 @@
+-- Migration: Add boolean trigger expressions
+-- Purpose: Let a trigger phrase combine words with AND, OR, NOT and NEAR/n
+-- ('expression' match mode)
+
+ALTER TABLE triggers
+    DROP CONSTRAINT triggers_match_mode_check,
+    ADD CONSTRAINT triggers_match_mode_check CHECK (match_mode IN ('exact', 'word', 'stem', 'regex', 'expression'));
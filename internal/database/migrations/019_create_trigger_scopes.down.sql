@@ -0,0 +1,3 @@
+-- Rollback: Remove trigger scopes
+
+DROP TABLE IF EXISTS trigger_scopes;
@@ -0,0 +1,22 @@
+-- Migration: Add trigger scopes
+-- Purpose: Limit a trigger to some chats, keep it out of others, limit it to
+-- some senders or to forwarded messages. A trigger without scope rows applies
+-- everywhere.
+
+CREATE TABLE trigger_scopes (
+    id SERIAL PRIMARY KEY,
+    trigger_id INTEGER NOT NULL REFERENCES triggers(id) ON DELETE CASCADE,
+    -- 'chat' and 'exclude_chat' hold a chat ID, 'sender' a user ID and
+    -- 'forwards' no value
+    kind VARCHAR(20) NOT NULL,
+    value BIGINT,
+    CONSTRAINT trigger_scopes_kind_check CHECK (kind IN ('chat', 'exclude_chat', 'sender', 'forwards')),
+    CONSTRAINT trigger_scopes_value_check CHECK ((kind = 'forwards') = (value IS NULL))
+);
+
+CREATE UNIQUE INDEX idx_trigger_scopes_unique ON trigger_scopes(trigger_id, kind, COALESCE(value, 0));
+
+-- A scope change is a trigger change for the reactor, see migration 015
+CREATE TRIGGER trigger_scopes_notify
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON trigger_scopes
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_triggers_changed();
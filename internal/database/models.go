@@ -2,6 +2,9 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -11,6 +14,49 @@ type SessionStorage struct {
 	Value []byte
 }
 
+// Account status values
+const (
+	AccountActive    = "active"
+	AccountLoggedOut = "logged_out"
+	AccountBanned    = "banned"
+	AccountDisabled  = "disabled"
+)
+
+// Account represents a userbot Telegram account
+type Account struct {
+	ID             int
+	Name           string
+	TelegramUserID sql.NullInt64
+	Username       sql.NullString
+	Status         string
+	LastError      sql.NullString
+	DeviceProfile  sql.NullString
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Backfill job status values
+const (
+	BackfillRunning = "running"
+	BackfillDone    = "done"
+	BackfillFailed  = "failed"
+)
+
+// BackfillJob tracks a historical import of one chat. OffsetID is the oldest
+// message fetched so far; the next page starts below it.
+type BackfillJob struct {
+	ID        int
+	ChatID    int64
+	Since     time.Time
+	OffsetID  int
+	Fetched   int
+	Stored    int
+	Status    string
+	LastError sql.NullString
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
 // MonitoredChat represents a Telegram chat being monitored
 type MonitoredChat struct {
 	ChatID              int64
@@ -20,6 +66,7 @@ type MonitoredChat struct {
 	LastPts             int
 	IsActive            bool
 	AddedAt             time.Time
+	AccountID           sql.NullInt64
 }
 
 // RawMessage represents a collected Telegram message
@@ -30,19 +77,201 @@ type RawMessage struct {
 	SenderID          sql.NullInt64
 	SenderName        sql.NullString
 	MessageText       sql.NullString
+	Media             Media
 	IsTranscribed     bool
 	IsForward         bool
 	ForwardSourceName sql.NullString
 	CreatedAt         time.Time
 	SavedAt           time.Time
+	EditedAt          sql.NullTime
+	DeletedAt         sql.NullTime
+	ReplyToMsgID      sql.NullInt64
+	ReplyToTopID      sql.NullInt64
+	TopicID           sql.NullInt64
+	ForwardFromID     sql.NullInt64
+	ForwardMsgID      sql.NullInt64
+	ForwardDate       sql.NullTime
 }
 
-// Trigger represents a keyword alert trigger
-type Trigger struct {
+// Sender is a Telegram user seen writing in a monitored chat
+type Sender struct {
+	UserID    int64
+	Username  sql.NullString
+	FirstName sql.NullString
+	LastName  sql.NullString
+	IsBot     bool
+	IsPremium bool
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// DisplayName returns the full name of the sender, or the username if the
+// name is empty
+func (s *Sender) DisplayName() string {
+	name := s.FirstName.String
+	if s.LastName.Valid {
+		name = strings.TrimSpace(name + " " + s.LastName.String)
+	}
+	if name == "" {
+		return s.Username.String
+	}
+	return name
+}
+
+// String renders the sender as "Name (@username)", marking bots
+func (s *Sender) String() string {
+	name := s.DisplayName()
+	switch {
+	case name == "":
+		name = fmt.Sprintf("user %d", s.UserID)
+	case !s.Username.Valid:
+	case name == s.Username.String:
+		name = "@" + name
+	default:
+		name += " (@" + s.Username.String + ")"
+	}
+	if s.IsBot {
+		name += " [bot]"
+	}
+	return name
+}
+
+// SameName reports whether two snapshots of a sender have the same username
+// and name
+func (s *Sender) SameName(other *Sender) bool {
+	return s.Username == other.Username && s.FirstName == other.FirstName && s.LastName == other.LastName
+}
+
+// SenderName is a username and name a sender used until ReplacedAt
+type SenderName struct {
 	ID         int
-	Phrase     string
-	IsRegex    bool
-	AlertLevel string
+	UserID     int64
+	Username   sql.NullString
+	FirstName  sql.NullString
+	LastName   sql.NullString
+	ReplacedAt time.Time
+}
+
+// RenamedSender is a sender with the number of name changes in a period
+type RenamedSender struct {
+	Sender
+	Renames int
+}
+
+// ForwardSource is an upstream chat or user whose messages are forwarded
+// into a monitored chat
+type ForwardSource struct {
+	SourceID      int64
+	SourceName    sql.NullString
+	Forwards      int
+	LastForwardAt time.Time
+}
+
+// ForwardCopy is a forward of an original post found in a monitored chat
+type ForwardCopy struct {
+	ChatID        int64
+	TelegramMsgID int
+	FirstSeen     time.Time
+}
+
+// SpreadPost is an original channel post forwarded into several monitored
+// chats. Copies are ordered by when they were first seen.
+type SpreadPost struct {
+	SourceID     int64
+	SourceMsgID  int
+	SourceName   sql.NullString
+	OriginalDate sql.NullTime
+	Copies       []ForwardCopy
+}
+
+// ForumTopic is the title of a topic in a forum supergroup. TopicID is the ID
+// of the message that created the topic.
+type ForumTopic struct {
+	ChatID    int64
+	TopicID   int
+	Title     string
+	UpdatedAt time.Time
+}
+
+// RawMessageVersion is a text a message had before it was edited
+type RawMessageVersion struct {
+	ID           int
+	RawMessageID int
+	MessageText  sql.NullString
+	WrittenAt    time.Time
+	ReplacedAt   time.Time
+	RecordedAt   time.Time
+}
+
+// Trigger events
+const (
+	// TriggerOnMessage matches new and edited messages
+	TriggerOnMessage = "message"
+	// TriggerOnDeleted matches messages deleted within WithinMinutes of
+	// being posted
+	TriggerOnDeleted = "deleted"
+)
+
+// Trigger match modes
+const (
+	// MatchExact finds the phrase anywhere in the text
+	MatchExact = "exact"
+	// MatchWord finds the phrase as whole words
+	MatchWord = "word"
+	// MatchStem finds the words of the phrase in any inflection, e.g.
+	// "взлом" in "взломали"
+	MatchStem = "stem"
+	// MatchRegex matches the phrase as a regular expression
+	MatchRegex = "regex"
+	// MatchExpression matches the phrase as a boolean expression of words,
+	// e.g. "(airdrop OR claim) AND NOT scam"
+	MatchExpression = "expression"
+)
+
+// Trigger represents a keyword alert trigger. An empty phrase matches every
+// message, which is mostly useful for deletion triggers. Normalize matches
+// the phrase against normalized text instead of the raw text.
+type Trigger struct {
+	ID            int
+	Phrase        string
+	MatchMode     string
+	AlertLevel    string
+	Event         string
+	WithinMinutes sql.NullInt64
+	Normalize     bool
+	Scope         TriggerScope
+}
+
+// TriggerScope limits the messages a trigger applies to. Each set limit
+// must hold; the zero value applies everywhere.
+type TriggerScope struct {
+	// ChatIDs, if any, are the only chats the trigger applies in
+	ChatIDs []int64
+	// ExcludedChatIDs are chats the trigger never applies in
+	ExcludedChatIDs []int64
+	// SenderIDs, if any, are the only senders the trigger applies to
+	SenderIDs []int64
+	// ForwardsOnly limits the trigger to forwarded messages
+	ForwardsOnly bool
+}
+
+// IsEmpty reports whether the scope sets no limit
+func (s TriggerScope) IsEmpty() bool {
+	return len(s.ChatIDs) == 0 && len(s.ExcludedChatIDs) == 0 && len(s.SenderIDs) == 0 && !s.ForwardsOnly
+}
+
+// Allows reports whether a message is within the scope
+func (s TriggerScope) Allows(msg *RawMessage) bool {
+	if len(s.ChatIDs) > 0 && !slices.Contains(s.ChatIDs, msg.ChatID) {
+		return false
+	}
+	if slices.Contains(s.ExcludedChatIDs, msg.ChatID) {
+		return false
+	}
+	if len(s.SenderIDs) > 0 && (!msg.SenderID.Valid || !slices.Contains(s.SenderIDs, msg.SenderID.Int64)) {
+		return false
+	}
+	return !s.ForwardsOnly || msg.IsForward
 }
 
 // DailyReport represents an AI-generated intelligence report
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestSenderString(t *testing.T) {
+	str := func(s string) sql.NullString {
+		return sql.NullString{String: s, Valid: s != ""}
+	}
+	tests := []struct {
+		name   string
+		sender Sender
+		want   string
+	}{
+		{"nothing", Sender{UserID: 42}, "user 42"},
+		{"username only", Sender{UserID: 42, Username: str("alice")}, "@alice"},
+		{"first name only", Sender{UserID: 42, FirstName: str("Alice")}, "Alice"},
+		{"last name only", Sender{UserID: 42, LastName: str("Smith")}, "Smith"},
+		{"full name", Sender{UserID: 42, FirstName: str("Alice"), LastName: str("Smith")}, "Alice Smith"},
+		{"name and username", Sender{UserID: 42, Username: str("alice"), FirstName: str("Alice")}, "Alice (@alice)"},
+		{"bot", Sender{UserID: 42, Username: str("helper_bot"), FirstName: str("Helper"), IsBot: true}, "Helper (@helper_bot) [bot]"},
+		{"bot with username only", Sender{UserID: 42, Username: str("helper_bot"), IsBot: true}, "@helper_bot [bot]"},
+		{"bot without names", Sender{UserID: 42, IsBot: true}, "user 42 [bot]"},
+	}
+	for _, tt := range tests {
+		if got := tt.sender.String(); got != tt.want {
+			t.Errorf("%s: String() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTriggerScopeAllows(t *testing.T) {
+	const chat, other = int64(-1001), int64(-1002)
+	sender := sql.NullInt64{Int64: 42, Valid: true}
+	tests := []struct {
+		name  string
+		scope TriggerScope
+		msg   RawMessage
+		want  bool
+	}{
+		{"empty", TriggerScope{}, RawMessage{ChatID: chat}, true},
+		{"included chat", TriggerScope{ChatIDs: []int64{chat}}, RawMessage{ChatID: chat}, true},
+		{"other chat", TriggerScope{ChatIDs: []int64{chat}}, RawMessage{ChatID: other}, false},
+		{"excluded chat", TriggerScope{ExcludedChatIDs: []int64{chat}}, RawMessage{ChatID: chat}, false},
+		{"chat not excluded", TriggerScope{ExcludedChatIDs: []int64{chat}}, RawMessage{ChatID: other}, true},
+		{"sender", TriggerScope{SenderIDs: []int64{42}}, RawMessage{ChatID: chat, SenderID: sender}, true},
+		{"other sender", TriggerScope{SenderIDs: []int64{7}}, RawMessage{ChatID: chat, SenderID: sender}, false},
+		{"no sender", TriggerScope{SenderIDs: []int64{0}}, RawMessage{ChatID: chat}, false},
+		{"no sender without sender limit", TriggerScope{ChatIDs: []int64{chat}}, RawMessage{ChatID: chat}, true},
+		{"forward", TriggerScope{ForwardsOnly: true}, RawMessage{ChatID: chat, IsForward: true}, true},
+		{"not a forward", TriggerScope{ForwardsOnly: true}, RawMessage{ChatID: chat}, false},
+		{"all limits met", TriggerScope{ChatIDs: []int64{chat, other}, SenderIDs: []int64{42}, ForwardsOnly: true}, RawMessage{ChatID: other, SenderID: sender, IsForward: true}, true},
+		{"one limit missed", TriggerScope{ChatIDs: []int64{chat}, SenderIDs: []int64{42}, ForwardsOnly: true}, RawMessage{ChatID: chat, SenderID: sender}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.scope.Allows(&tt.msg); got != tt.want {
+			t.Errorf("%s: Allows = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"telemonitor/internal/database"
+)
+
+// AccountRepository handles accounts operations
+type AccountRepository struct {
+	db *database.DB
+}
+
+// NewAccountRepository creates a new AccountRepository
+func NewAccountRepository(db *database.DB) *AccountRepository {
+	return &AccountRepository{db: db}
+}
+
+// Create inserts a new account, returning the existing one if the name is taken
+func (r *AccountRepository) Create(name string) (*database.Account, error) {
+	query := `
+		INSERT INTO accounts (name)
+		VALUES ($1)
+		ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id, name, telegram_user_id, username, status, last_error, device_profile, created_at, updated_at
+	`
+	
+	account := &database.Account{}
+	err := r.db.QueryRow(query, name).Scan(
+		&account.ID,
+		&account.Name,
+		&account.TelegramUserID,
+		&account.Username,
+		&account.Status,
+		&account.LastError,
+		&account.DeviceProfile,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create account: %w", err)
+	}
+	
+	return account, nil
+}
+
+// GetByName retrieves an account by name
+func (r *AccountRepository) GetByName(name string) (*database.Account, error) {
+	query := `
+		SELECT id, name, telegram_user_id, username, status, last_error, device_profile, created_at, updated_at
+		FROM accounts
+		WHERE name = $1
+	`
+	
+	account := &database.Account{}
+	err := r.db.QueryRow(query, name).Scan(
+		&account.ID,
+		&account.Name,
+		&account.TelegramUserID,
+		&account.Username,
+		&account.Status,
+		&account.LastError,
+		&account.DeviceProfile,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+	
+	return account, nil
+}
+
+// GetAll retrieves all accounts
+func (r *AccountRepository) GetAll() ([]*database.Account, error) {
+	query := `
+		SELECT id, name, telegram_user_id, username, status, last_error, device_profile, created_at, updated_at
+		FROM accounts
+		ORDER BY id
+	`
+	
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all accounts: %w", err)
+	}
+	defer rows.Close()
+	
+	var accounts []*database.Account
+	for rows.Next() {
+		account := &database.Account{}
+		if err := rows.Scan(
+			&account.ID,
+			&account.Name,
+			&account.TelegramUserID,
+			&account.Username,
+			&account.Status,
+			&account.LastError,
+			&account.DeviceProfile,
+			&account.CreatedAt,
+			&account.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan account: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+	
+	return accounts, nil
+}
+
+// SetStatus updates the account status and the error that caused it
+func (r *AccountRepository) SetStatus(id int, status string, lastError sql.NullString) error {
+	query := `
+		UPDATE accounts
+		SET status = $2, last_error = $3, updated_at = NOW()
+		WHERE id = $1
+	`
+	
+	_, err := r.db.Exec(query, id, status, lastError)
+	if err != nil {
+		return fmt.Errorf("failed to set account status: %w", err)
+	}
+	return nil
+}
+
+// SetIdentity records the Telegram user behind the account after login and
+// pins deviceProfile unless the account already has one
+func (r *AccountRepository) SetIdentity(id int, telegramUserID int64, username sql.NullString, deviceProfile string) error {
+	query := `
+		UPDATE accounts
+		SET telegram_user_id = $2, username = $3, status = 'active', last_error = NULL,
+			device_profile = COALESCE(device_profile, $4), updated_at = NOW()
+		WHERE id = $1
+	`
+	
+	_, err := r.db.Exec(query, id, telegramUserID, username, deviceProfile)
+	if err != nil {
+		return fmt.Errorf("failed to set account identity: %w", err)
+	}
+	return nil
+}
+
+// SetDeviceProfile pins the device profile of an account that has none yet
+func (r *AccountRepository) SetDeviceProfile(id int, deviceProfile string) error {
+	query := `
+		UPDATE accounts
+		SET device_profile = $2, updated_at = NOW()
+		WHERE id = $1 AND device_profile IS NULL
+	`
+	
+	_, err := r.db.Exec(query, id, deviceProfile)
+	if err != nil {
+		return fmt.Errorf("failed to set account device profile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"telemonitor/internal/database"
+)
+
+// BackfillRepository handles backfill_jobs operations
+type BackfillRepository struct {
+	db *database.DB
+}
+
+// NewBackfillRepository creates a new BackfillRepository
+func NewBackfillRepository(db *database.DB) *BackfillRepository {
+	return &BackfillRepository{db: db}
+}
+
+// Start creates a running job for a chat. An unfinished job for the same chat
+// is restarted with the new since instead, keeping its position.
+func (r *BackfillRepository) Start(chatID int64, since time.Time) (*database.BackfillJob, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE backfill_jobs
+		SET since = $2, status = 'running', last_error = NULL, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM backfill_jobs
+			WHERE chat_id = $1 AND status <> 'done'
+			ORDER BY id DESC
+			LIMIT 1
+		)
+		RETURNING id, chat_id, since, offset_id, fetched, stored, status, last_error, created_at, updated_at
+	`
+
+	job := &database.BackfillJob{}
+	err = scanBackfillJob(tx.QueryRow(query, chatID, since), job)
+	if err == sql.ErrNoRows {
+		query = `
+			INSERT INTO backfill_jobs (chat_id, since)
+			VALUES ($1, $2)
+			RETURNING id, chat_id, since, offset_id, fetched, stored, status, last_error, created_at, updated_at
+		`
+		err = scanBackfillJob(tx.QueryRow(query, chatID, since), job)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start backfill job: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit backfill job: %w", err)
+	}
+	return job, nil
+}
+
+// GetRunning retrieves the jobs that were interrupted by a restart
+func (r *BackfillRepository) GetRunning() ([]*database.BackfillJob, error) {
+	query := `
+		SELECT id, chat_id, since, offset_id, fetched, stored, status, last_error, created_at, updated_at
+		FROM backfill_jobs
+		WHERE status = 'running'
+		ORDER BY id
+	`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get running backfill jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*database.BackfillJob
+	for rows.Next() {
+		job := &database.BackfillJob{}
+		if err := scanBackfillJob(rows, job); err != nil {
+			return nil, fmt.Errorf("failed to scan backfill job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// SaveProgress records the position reached by a job
+func (r *BackfillRepository) SaveProgress(job *database.BackfillJob) error {
+	query := `
+		UPDATE backfill_jobs
+		SET offset_id = $2, fetched = $3, stored = $4, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := r.db.Exec(query, job.ID, job.OffsetID, job.Fetched, job.Stored)
+	if err != nil {
+		return fmt.Errorf("failed to save backfill progress: %w", err)
+	}
+	return nil
+}
+
+// Finish marks a job as done or failed
+func (r *BackfillRepository) Finish(id int, status string, lastError sql.NullString) error {
+	query := `
+		UPDATE backfill_jobs
+		SET status = $2, last_error = $3, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := r.db.Exec(query, id, status, lastError)
+	if err != nil {
+		return fmt.Errorf("failed to finish backfill job: %w", err)
+	}
+	return nil
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBackfillJob(row rowScanner, job *database.BackfillJob) error {
+	return row.Scan(
+		&job.ID,
+		&job.ChatID,
+		&job.Since,
+		&job.OffsetID,
+		&job.Fetched,
+		&job.Stored,
+		&job.Status,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+}
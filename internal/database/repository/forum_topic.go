@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"fmt"
+
+	"telemonitor/internal/database"
+)
+
+// ForumTopicRepository handles forum_topics operations
+type ForumTopicRepository struct {
+	db *database.DB
+}
+
+// NewForumTopicRepository creates a new ForumTopicRepository
+func NewForumTopicRepository(db *database.DB) *ForumTopicRepository {
+	return &ForumTopicRepository{db: db}
+}
+
+// Save records the title of a topic, replacing a previous one
+func (r *ForumTopicRepository) Save(topic *database.ForumTopic) error {
+	query := `
+		INSERT INTO forum_topics (chat_id, topic_id, title)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (chat_id, topic_id) DO UPDATE
+		SET title = EXCLUDED.title, updated_at = NOW()
+	`
+
+	_, err := r.db.Exec(query, topic.ChatID, topic.TopicID, topic.Title)
+	if err != nil {
+		return fmt.Errorf("failed to save forum topic: %w", err)
+	}
+	return nil
+}
+
+// GetTitles returns the topic titles of a chat keyed by topic ID
+func (r *ForumTopicRepository) GetTitles(chatID int64) (map[int]string, error) {
+	query := `SELECT topic_id, title FROM forum_topics WHERE chat_id = $1`
+
+	rows, err := r.db.Query(query, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get forum topics: %w", err)
+	}
+	defer rows.Close()
+
+	titles := make(map[int]string)
+	for rows.Next() {
+		var (
+			id    int
+			title string
+		)
+		if err := rows.Scan(&id, &title); err != nil {
+			return nil, fmt.Errorf("failed to scan forum topic: %w", err)
+		}
+		titles[id] = title
+	}
+
+	return titles, rows.Err()
+}
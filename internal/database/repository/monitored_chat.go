@@ -21,8 +21,8 @@ func NewMonitoredChatRepository(db *database.DB) *MonitoredChatRepository {
 // Create adds a new monitored chat
 func (r *MonitoredChatRepository) Create(chat *database.MonitoredChat) error {
 	query := `
-		INSERT INTO monitored_chats (chat_id, title, username, is_active, added_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO monitored_chats (chat_id, title, username, is_active, added_at, account_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT (chat_id) DO NOTHING
 	`
 	
@@ -30,7 +30,7 @@ func (r *MonitoredChatRepository) Create(chat *database.MonitoredChat) error {
 		chat.AddedAt = time.Now()
 	}
 	
-	_, err := r.db.Exec(query, chat.ChatID, chat.Title, chat.Username, chat.IsActive, chat.AddedAt)
+	_, err := r.db.Exec(query, chat.ChatID, chat.Title, chat.Username, chat.IsActive, chat.AddedAt, chat.AccountID)
 	if err != nil {
 		return fmt.Errorf("failed to create monitored chat: %w", err)
 	}
@@ -40,7 +40,7 @@ func (r *MonitoredChatRepository) Create(chat *database.MonitoredChat) error {
 // GetByChatID retrieves a chat by ID
 func (r *MonitoredChatRepository) GetByChatID(chatID int64) (*database.MonitoredChat, error) {
 	query := `
-		SELECT chat_id, title, username, last_processed_msg_id, last_pts, is_active, added_at
+		SELECT chat_id, title, username, last_processed_msg_id, last_pts, is_active, added_at, account_id
 		FROM monitored_chats
 		WHERE chat_id = $1
 	`
@@ -54,6 +54,7 @@ func (r *MonitoredChatRepository) GetByChatID(chatID int64) (*database.Monitored
 		&chat.LastPts,
 		&chat.IsActive,
 		&chat.AddedAt,
+		&chat.AccountID,
 	)
 	
 	if err == sql.ErrNoRows {
@@ -69,7 +70,7 @@ func (r *MonitoredChatRepository) GetByChatID(chatID int64) (*database.Monitored
 // GetAll retrieves all monitored chats
 func (r *MonitoredChatRepository) GetAll() ([]*database.MonitoredChat, error) {
 	query := `
-		SELECT chat_id, title, username, last_processed_msg_id, last_pts, is_active, added_at
+		SELECT chat_id, title, username, last_processed_msg_id, last_pts, is_active, added_at, account_id
 		FROM monitored_chats
 		ORDER BY added_at DESC
 	`
@@ -91,6 +92,7 @@ func (r *MonitoredChatRepository) GetAll() ([]*database.MonitoredChat, error) {
 			&chat.LastPts,
 			&chat.IsActive,
 			&chat.AddedAt,
+			&chat.AccountID,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan monitored chat: %w", err)
 		}
@@ -103,7 +105,7 @@ func (r *MonitoredChatRepository) GetAll() ([]*database.MonitoredChat, error) {
 // GetActive retrieves all active monitored chats
 func (r *MonitoredChatRepository) GetActive() ([]*database.MonitoredChat, error) {
 	query := `
-		SELECT chat_id, title, username, last_processed_msg_id, last_pts, is_active, added_at
+		SELECT chat_id, title, username, last_processed_msg_id, last_pts, is_active, added_at, account_id
 		FROM monitored_chats
 		WHERE is_active = TRUE
 		ORDER BY added_at DESC
@@ -115,7 +117,7 @@ func (r *MonitoredChatRepository) GetActive() ([]*database.MonitoredChat, error)
 	}
 	defer rows.Close()
 	
-	var chats []*database.MonitoredChat{}
+	var chats []*database.MonitoredChat
 	for rows.Next() {
 		chat := &database.MonitoredChat{}
 		if err := rows.Scan(
@@ -126,6 +128,7 @@ func (r *MonitoredChatRepository) GetActive() ([]*database.MonitoredChat, error)
 			&chat.LastPts,
 			&chat.IsActive,
 			&chat.AddedAt,
+			&chat.AccountID,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan monitored chat: %w", err)
 		}
@@ -140,7 +143,7 @@ func (r *MonitoredChatRepository) Update(chat *database.MonitoredChat) error {
 	query := `
 		UPDATE monitored_chats
 		SET title = $2, username = $3, last_processed_msg_id = $4, 
-		    last_pts = $5, is_active = $6
+		    last_pts = $5, is_active = $6, account_id = $7
 		WHERE chat_id = $1
 	`
 	
@@ -151,6 +154,7 @@ func (r *MonitoredChatRepository) Update(chat *database.MonitoredChat) error {
 		chat.LastProcessedMsgID,
 		chat.LastPts,
 		chat.IsActive,
+		chat.AccountID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update monitored chat: %w", err)
@@ -173,6 +177,23 @@ func (r *MonitoredChatRepository) UpdateState(chatID int64, lastMsgID, lastPts i
 	return nil
 }
 
+// AdvanceState moves the message tracking state forward. Values lower than
+// the stored ones are ignored, so out of order commits never rewind a chat.
+func (r *MonitoredChatRepository) AdvanceState(chatID int64, lastMsgID, lastPts int) error {
+	query := `
+		UPDATE monitored_chats
+		SET last_processed_msg_id = GREATEST(last_processed_msg_id, $2),
+		    last_pts = GREATEST(last_pts, $3)
+		WHERE chat_id = $1
+	`
+	
+	_, err := r.db.Exec(query, chatID, lastMsgID, lastPts)
+	if err != nil {
+		return fmt.Errorf("failed to advance chat state: %w", err)
+	}
+	return nil
+}
+
 // Delete removes a monitored chat
 func (r *MonitoredChatRepository) Delete(chatID int64) error {
 	query := `DELETE FROM monitored_chats WHERE chat_id = $1`
@@ -192,3 +213,76 @@ func (r *MonitoredChatRepository) SetActive(chatID int64, active bool) error {
 	}
 	return nil
 }
+
+// GetActiveByAccount retrieves the active chats assigned to an account
+func (r *MonitoredChatRepository) GetActiveByAccount(accountID int) ([]*database.MonitoredChat, error) {
+	query := `
+		SELECT chat_id, title, username, last_processed_msg_id, last_pts, is_active, added_at, account_id
+		FROM monitored_chats
+		WHERE is_active = TRUE AND account_id = $1
+		ORDER BY added_at DESC
+	`
+	
+	rows, err := r.db.Query(query, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chats by account: %w", err)
+	}
+	defer rows.Close()
+	
+	var chats []*database.MonitoredChat
+	for rows.Next() {
+		chat := &database.MonitoredChat{}
+		if err := rows.Scan(
+			&chat.ChatID,
+			&chat.Title,
+			&chat.Username,
+			&chat.LastProcessedMsgID,
+			&chat.LastPts,
+			&chat.IsActive,
+			&chat.AddedAt,
+			&chat.AccountID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan monitored chat: %w", err)
+		}
+		chats = append(chats, chat)
+	}
+	
+	return chats, nil
+}
+
+// AssignAccount moves a chat to the given account
+func (r *MonitoredChatRepository) AssignAccount(chatID int64, accountID int) error {
+	query := `UPDATE monitored_chats SET account_id = $2 WHERE chat_id = $1`
+	_, err := r.db.Exec(query, chatID, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to assign chat to account: %w", err)
+	}
+	return nil
+}
+
+// CountActiveByAccount returns the number of active chats per account ID
+func (r *MonitoredChatRepository) CountActiveByAccount() (map[int]int, error) {
+	query := `
+		SELECT account_id, COUNT(*)
+		FROM monitored_chats
+		WHERE is_active = TRUE AND account_id IS NOT NULL
+		GROUP BY account_id
+	`
+	
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count chats by account: %w", err)
+	}
+	defer rows.Close()
+	
+	counts := make(map[int]int)
+	for rows.Next() {
+		var accountID, count int
+		if err := rows.Scan(&accountID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan chat count: %w", err)
+		}
+		counts[accountID] = count
+	}
+	
+	return counts, nil
+}
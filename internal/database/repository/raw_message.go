@@ -3,8 +3,11 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
+
 	"telemonitor/internal/database"
 )
 
@@ -22,10 +25,11 @@ func NewRawMessageRepository(db *database.DB) *RawMessageRepository {
 func (r *RawMessageRepository) Create(msg *database.RawMessage) error {
 	query := `
 		INSERT INTO raw_messages (
-			chat_id, telegram_msg_id, sender_id, sender_name, message_text,
-			is_transcribed, is_forward, forward_source_name, created_at
+			chat_id, telegram_msg_id, sender_id, sender_name, message_text, media,
+			is_transcribed, is_forward, forward_source_name, created_at, edited_at,
+			reply_to_msg_id, reply_to_top_id, topic_id, forward_from_id, forward_msg_id, forward_date
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
 		ON CONFLICT (chat_id, telegram_msg_id) DO NOTHING
 		RETURNING id
 	`
@@ -36,10 +40,18 @@ func (r *RawMessageRepository) Create(msg *database.RawMessage) error {
 		msg.SenderID,
 		msg.SenderName,
 		msg.MessageText,
+		msg.Media,
 		msg.IsTranscribed,
 		msg.IsForward,
 		msg.ForwardSourceName,
 		msg.CreatedAt,
+		msg.EditedAt,
+		msg.ReplyToMsgID,
+		msg.ReplyToTopID,
+		msg.TopicID,
+		msg.ForwardFromID,
+		msg.ForwardMsgID,
+		msg.ForwardDate,
 	).Scan(&msg.ID)
 	
 	if err == sql.ErrNoRows {
@@ -53,11 +65,383 @@ func (r *RawMessageRepository) Create(msg *database.RawMessage) error {
 	return nil
 }
 
+// rawMessageCopyColumns are the columns CreateBatch copies into the staging
+// table, in insert order
+var rawMessageCopyColumns = []string{
+	"chat_id", "telegram_msg_id", "sender_id", "sender_name", "message_text", "media",
+	"is_transcribed", "is_forward", "forward_source_name", "created_at", "edited_at",
+	"reply_to_msg_id", "reply_to_top_id", "topic_id", "forward_from_id", "forward_msg_id", "forward_date",
+}
+
+// CreateBatch inserts many messages in one round-trip: they are copied into
+// a temporary staging table with COPY and moved into raw_messages, skipping
+// the ones already stored. It sets ID on the new messages and returns them.
+func (r *RawMessageRepository) CreateBatch(msgs []*database.RawMessage) ([]*database.RawMessage, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	
+	columns := strings.Join(rawMessageCopyColumns, ", ")
+	query := `
+		CREATE TEMP TABLE raw_messages_staging ON COMMIT DROP AS
+		SELECT ` + columns + ` FROM raw_messages WITH NO DATA
+	`
+	if _, err := tx.Exec(query); err != nil {
+		return nil, fmt.Errorf("failed to create staging table: %w", err)
+	}
+	
+	stmt, err := tx.Prepare(pq.CopyIn("raw_messages_staging", rawMessageCopyColumns...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start copy: %w", err)
+	}
+	for _, msg := range msgs {
+		_, err := stmt.Exec(
+			msg.ChatID,
+			msg.TelegramMsgID,
+			msg.SenderID,
+			msg.SenderName,
+			msg.MessageText,
+			msg.Media,
+			msg.IsTranscribed,
+			msg.IsForward,
+			msg.ForwardSourceName,
+			msg.CreatedAt,
+			msg.EditedAt,
+			msg.ReplyToMsgID,
+			msg.ReplyToTopID,
+			msg.TopicID,
+			msg.ForwardFromID,
+			msg.ForwardMsgID,
+			msg.ForwardDate,
+		)
+		if err != nil {
+			stmt.Close()
+			return nil, fmt.Errorf("failed to copy raw message: %w", err)
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return nil, fmt.Errorf("failed to copy raw messages: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish copy: %w", err)
+	}
+	
+	query = `
+		INSERT INTO raw_messages (` + columns + `)
+		SELECT ` + columns + ` FROM raw_messages_staging
+		ON CONFLICT (chat_id, telegram_msg_id) DO NOTHING
+		RETURNING id, chat_id, telegram_msg_id
+	`
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert raw messages: %w", err)
+	}
+	defer rows.Close()
+	
+	type key struct {
+		chatID int64
+		msgID  int
+	}
+	ids := make(map[key]int, len(msgs))
+	for rows.Next() {
+		var (
+			k  key
+			id int
+		)
+		if err := rows.Scan(&id, &k.chatID, &k.msgID); err != nil {
+			return nil, fmt.Errorf("failed to scan inserted message: %w", err)
+		}
+		ids[k] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to insert raw messages: %w", err)
+	}
+	rows.Close()
+	
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit raw messages: %w", err)
+	}
+	
+	var created []*database.RawMessage
+	for _, msg := range msgs {
+		k := key{msg.ChatID, msg.TelegramMsgID}
+		if id, ok := ids[k]; ok {
+			// A message repeated in the batch is only inserted once
+			msg.ID = id
+			created = append(created, msg)
+			delete(ids, k)
+		}
+	}
+	return created, nil
+}
+
+// RecordEdit stores the new text and media of an edited message and keeps
+// the previous text in raw_message_versions. The transcript of a voice
+// message stays appended to its new caption. It returns false when the text
+// did not change, e.g. when the same edit is delivered again by catch-up. A
+// message that was never stored is inserted as is.
+func (r *RawMessageRepository) RecordEdit(msg *database.RawMessage, editedAt time.Time) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	
+	var (
+		id         int
+		text       sql.NullString
+		media      database.Media
+		transcript sql.NullString
+		createdAt  time.Time
+		lastEdited sql.NullTime
+	)
+	query := `
+		SELECT id, message_text, media, transcript, created_at, edited_at
+		FROM raw_messages
+		WHERE chat_id = $1 AND telegram_msg_id = $2
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, msg.ChatID, msg.TelegramMsgID).Scan(&id, &text, &media, &transcript, &createdAt, &lastEdited)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		msg.EditedAt = sql.NullTime{Time: editedAt, Valid: true}
+		if err := r.Create(msg); err != nil {
+			return false, err
+		}
+		// Zero when the message was inserted concurrently since the select
+		return msg.ID != 0, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get edited message: %w", err)
+	}
+	
+	if transcript.Valid {
+		msg.MessageText = withTranscript(msg.MessageText, transcript.String)
+		msg.IsTranscribed = true
+	}
+	// edit_date has second precision, so a second edit within the same
+	// second is told apart from a repeated delivery by its content
+	if lastEdited.Valid && !editedAt.After(lastEdited.Time) && text == msg.MessageText && media.Equal(msg.Media) {
+		return false, nil
+	}
+	if text == msg.MessageText {
+		// Only the attachment changed, e.g. a replaced photo
+		query = `UPDATE raw_messages SET media = $2, edited_at = $3 WHERE id = $1`
+		if _, err := tx.Exec(query, id, msg.Media, editedAt); err != nil {
+			return false, fmt.Errorf("failed to update edited message: %w", err)
+		}
+		return false, tx.Commit()
+	}
+	
+	writtenAt := createdAt
+	if lastEdited.Valid {
+		writtenAt = lastEdited.Time
+	}
+	query = `
+		INSERT INTO raw_message_versions (raw_message_id, message_text, written_at, replaced_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	if _, err := tx.Exec(query, id, text, writtenAt, editedAt); err != nil {
+		return false, fmt.Errorf("failed to save message version: %w", err)
+	}
+	
+	query = `UPDATE raw_messages SET message_text = $2, media = $3, edited_at = $4 WHERE id = $1`
+	if _, err := tx.Exec(query, id, msg.MessageText, msg.Media, editedAt); err != nil {
+		return false, fmt.Errorf("failed to update edited message: %w", err)
+	}
+	
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit edit: %w", err)
+	}
+	
+	msg.ID = id
+	msg.CreatedAt = createdAt
+	msg.EditedAt = sql.NullTime{Time: editedAt, Valid: true}
+	return true, nil
+}
+
+// MarkDeleted sets deleted_at on the given messages of a chat and returns
+// the ones that were not marked before
+func (r *RawMessageRepository) MarkDeleted(chatID int64, msgIDs []int, deletedAt time.Time) ([]*database.RawMessage, error) {
+	ids := make([]int64, len(msgIDs))
+	for i, id := range msgIDs {
+		ids[i] = int64(id)
+	}
+	
+	query := `
+		UPDATE raw_messages
+		SET deleted_at = $3
+		WHERE chat_id = $1 AND telegram_msg_id = ANY($2) AND deleted_at IS NULL
+		RETURNING ` + rawMessageColumns
+	
+	rows, err := r.db.Query(query, chatID, pq.Array(ids), deletedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark messages deleted: %w", err)
+	}
+	defer rows.Close()
+	
+	var messages []*database.RawMessage
+	for rows.Next() {
+		msg := &database.RawMessage{}
+		if err := scanRawMessage(rows, msg); err != nil {
+			return nil, fmt.Errorf("failed to scan deleted message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	
+	return messages, rows.Err()
+}
+
+// GetVersions retrieves the earlier texts of a message, oldest first
+func (r *RawMessageRepository) GetVersions(rawMessageID int) ([]*database.RawMessageVersion, error) {
+	query := `
+		SELECT id, raw_message_id, message_text, written_at, replaced_at, recorded_at
+		FROM raw_message_versions
+		WHERE raw_message_id = $1
+		ORDER BY replaced_at ASC
+	`
+	
+	rows, err := r.db.Query(query, rawMessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message versions: %w", err)
+	}
+	defer rows.Close()
+	
+	var versions []*database.RawMessageVersion
+	for rows.Next() {
+		v := &database.RawMessageVersion{}
+		if err := rows.Scan(&v.ID, &v.RawMessageID, &v.MessageText, &v.WrittenAt, &v.ReplacedAt, &v.RecordedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	
+	return versions, rows.Err()
+}
+
+// Search retrieves messages whose text or media metadata (file name, title,
+// poll question and options, link) contains query, newest first. chatID 0
+// searches every chat.
+func (r *RawMessageRepository) Search(chatID int64, query string, start, end time.Time, limit int) ([]*database.RawMessage, error) {
+	sqlQuery := `SELECT ` + rawMessageColumns + `
+		FROM raw_messages
+		WHERE ($1::bigint = 0 OR chat_id = $1::bigint)
+		  AND created_at >= $2 AND created_at < $3
+		  AND (
+		      message_text ILIKE $4
+		      OR concat_ws(' ', media->>'file_name', media->>'title', media->>'question',
+		                   media->>'options', media->>'url', media->>'address') ILIKE $4
+		  )
+		ORDER BY created_at DESC
+		LIMIT $5
+	`
+	
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := r.db.Query(sqlQuery, chatID, start, end, pattern, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+	
+	var messages []*database.RawMessage
+	for rows.Next() {
+		msg := &database.RawMessage{}
+		if err := scanRawMessage(rows, msg); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	
+	return messages, rows.Err()
+}
+
+// GetUntranscribed retrieves voice messages and video notes posted after
+// since that were neither transcribed nor given up on, oldest first
+func (r *RawMessageRepository) GetUntranscribed(since time.Time, limit int) ([]*database.RawMessage, error) {
+	query := `SELECT ` + rawMessageColumns + `
+		FROM raw_messages
+		WHERE NOT is_transcribed AND transcription_error IS NULL
+		  AND media->>'type' IN ('voice', 'video_note')
+		  AND created_at >= $1
+		ORDER BY created_at ASC
+		LIMIT $2
+	`
+	
+	rows, err := r.db.Query(query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get untranscribed messages: %w", err)
+	}
+	defer rows.Close()
+	
+	var messages []*database.RawMessage
+	for rows.Next() {
+		msg := &database.RawMessage{}
+		if err := scanRawMessage(rows, msg); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	
+	return messages, rows.Err()
+}
+
+// SaveTranscript appends the transcript of a voice message to its caption,
+// keeps it for later caption edits and marks the message transcribed. It
+// returns the updated message.
+func (r *RawMessageRepository) SaveTranscript(id int, transcript string) (*database.RawMessage, error) {
+	query := `
+		UPDATE raw_messages
+		SET message_text = CASE
+		        WHEN COALESCE(message_text, '') = '' THEN $2
+		        ELSE message_text || E'\n\n' || $2
+		    END,
+		    transcript = $2,
+		    is_transcribed = TRUE,
+		    transcription_error = NULL
+		WHERE id = $1
+		RETURNING ` + rawMessageColumns
+	
+	msg := &database.RawMessage{}
+	err := scanRawMessage(r.db.QueryRow(query, id, transcript), msg)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save transcript: %w", err)
+	}
+	return msg, nil
+}
+
+// withTranscript appends a transcript to a caption like SaveTranscript
+func withTranscript(caption sql.NullString, transcript string) sql.NullString {
+	if caption.String == "" {
+		return sql.NullString{String: transcript, Valid: true}
+	}
+	return sql.NullString{String: caption.String + "\n\n" + transcript, Valid: true}
+}
+
+// FailTranscription records why a message cannot be transcribed so it is not
+// retried
+func (r *RawMessageRepository) FailTranscription(id int, reason string) error {
+	query := `UPDATE raw_messages SET transcription_error = $2 WHERE id = $1`
+	
+	_, err := r.db.Exec(query, id, reason)
+	if err != nil {
+		return fmt.Errorf("failed to record transcription error: %w", err)
+	}
+	return nil
+}
+
 // GetByChatIDAndTimeRange retrieves messages for a chat within a time range
 func (r *RawMessageRepository) GetByChatIDAndTimeRange(chatID int64, start, end time.Time) ([]*database.RawMessage, error) {
-	query := `
-		SELECT id, chat_id, telegram_msg_id, sender_id, sender_name, message_text,
-		       is_transcribed, is_forward, forward_source_name, created_at, saved_at
+	query := `SELECT ` + rawMessageColumns + `
 		FROM raw_messages
 		WHERE chat_id = $1 AND created_at >= $2 AND created_at < $3
 		ORDER BY created_at ASC
@@ -72,19 +456,7 @@ func (r *RawMessageRepository) GetByChatIDAndTimeRange(chatID int64, start, end
 	var messages []*database.RawMessage
 	for rows.Next() {
 		msg := &database.RawMessage{}
-		if err := rows.Scan(
-			&msg.ID,
-			&msg.ChatID,
-			&msg.TelegramMsgID,
-			&msg.SenderID,
-			&msg.SenderName,
-			&msg.MessageText,
-			&msg.IsTranscribed,
-			&msg.IsForward,
-			&msg.ForwardSourceName,
-			&msg.CreatedAt,
-			&msg.SavedAt,
-		); err != nil {
+		if err := scanRawMessage(rows, msg); err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
 		messages = append(messages, msg)
@@ -93,6 +465,138 @@ func (r *RawMessageRepository) GetByChatIDAndTimeRange(chatID int64, start, end
 	return messages, nil
 }
 
+// GetThread retrieves a message and everything that answers it, oldest
+// first: the reply chains below it, and the comment thread or forum topic it
+// is the top message of
+func (r *RawMessageRepository) GetThread(chatID int64, rootMsgID int) ([]*database.RawMessage, error) {
+	query := `
+		WITH RECURSIVE thread(id, telegram_msg_id) AS (
+			SELECT id, telegram_msg_id
+			FROM raw_messages
+			WHERE chat_id = $1
+			  AND (telegram_msg_id = $2 OR reply_to_top_id = $2 OR topic_id = $2)
+			UNION
+			SELECT m.id, m.telegram_msg_id
+			FROM raw_messages m
+			JOIN thread t ON m.reply_to_msg_id = t.telegram_msg_id
+			WHERE m.chat_id = $1
+		)
+		SELECT ` + rawMessageColumns + `
+		FROM raw_messages
+		WHERE id IN (SELECT id FROM thread)
+		ORDER BY created_at ASC, telegram_msg_id ASC
+	`
+	
+	rows, err := r.db.Query(query, chatID, rootMsgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread: %w", err)
+	}
+	defer rows.Close()
+	
+	var messages []*database.RawMessage
+	for rows.Next() {
+		msg := &database.RawMessage{}
+		if err := scanRawMessage(rows, msg); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	
+	return messages, rows.Err()
+}
+
+// GetForwardSources returns the chats and users whose messages were
+// forwarded into a chat since a time, most forwards first
+func (r *RawMessageRepository) GetForwardSources(chatID int64, since time.Time, limit int) ([]*database.ForwardSource, error) {
+	query := `
+		SELECT forward_from_id,
+			(ARRAY_AGG(forward_source_name ORDER BY created_at DESC)
+				FILTER (WHERE forward_source_name IS NOT NULL))[1],
+			COUNT(*), MAX(created_at)
+		FROM raw_messages
+		WHERE chat_id = $1 AND forward_from_id IS NOT NULL AND created_at >= $2
+		GROUP BY forward_from_id
+		ORDER BY COUNT(*) DESC, MAX(created_at) DESC
+		LIMIT $3
+	`
+	
+	rows, err := r.db.Query(query, chatID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get forward sources: %w", err)
+	}
+	defer rows.Close()
+	
+	var sources []*database.ForwardSource
+	for rows.Next() {
+		s := &database.ForwardSource{}
+		if err := rows.Scan(&s.SourceID, &s.SourceName, &s.Forwards, &s.LastForwardAt); err != nil {
+			return nil, fmt.Errorf("failed to scan forward source: %w", err)
+		}
+		sources = append(sources, s)
+	}
+	
+	return sources, rows.Err()
+}
+
+// GetSpreading returns the channel posts forwarded into at least minChats
+// monitored chats since a time, the widest spread first. Each copy is the
+// first forward of the post seen in its chat.
+func (r *RawMessageRepository) GetSpreading(since time.Time, minChats, limit int) ([]*database.SpreadPost, error) {
+	query := `
+		WITH copies AS (
+			SELECT DISTINCT ON (forward_from_id, forward_msg_id, chat_id)
+				forward_from_id, forward_msg_id, chat_id, telegram_msg_id, created_at,
+				forward_source_name, forward_date
+			FROM raw_messages
+			WHERE forward_msg_id IS NOT NULL AND created_at >= $1
+			ORDER BY forward_from_id, forward_msg_id, chat_id, created_at
+		), spread AS (
+			SELECT forward_from_id, forward_msg_id, COUNT(*) AS chats, MIN(created_at) AS first_seen
+			FROM copies
+			GROUP BY forward_from_id, forward_msg_id
+			HAVING COUNT(*) >= $2
+			ORDER BY COUNT(*) DESC, MIN(created_at) DESC
+			LIMIT $3
+		)
+		SELECT c.forward_from_id, c.forward_msg_id, c.forward_source_name, c.forward_date,
+			c.chat_id, c.telegram_msg_id, c.created_at
+		FROM copies c
+		JOIN spread s USING (forward_from_id, forward_msg_id)
+		ORDER BY s.chats DESC, s.first_seen DESC, c.forward_from_id, c.forward_msg_id, c.created_at
+	`
+	
+	rows, err := r.db.Query(query, since, minChats, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreading posts: %w", err)
+	}
+	defer rows.Close()
+	
+	var posts []*database.SpreadPost
+	for rows.Next() {
+		var (
+			p database.SpreadPost
+			c database.ForwardCopy
+		)
+		err := rows.Scan(&p.SourceID, &p.SourceMsgID, &p.SourceName, &p.OriginalDate,
+			&c.ChatID, &c.TelegramMsgID, &c.FirstSeen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan forwarded copy: %w", err)
+		}
+		
+		last := len(posts) - 1
+		if last < 0 || posts[last].SourceID != p.SourceID || posts[last].SourceMsgID != p.SourceMsgID {
+			posts = append(posts, &p)
+			last++
+		}
+		if !posts[last].SourceName.Valid {
+			posts[last].SourceName = p.SourceName
+		}
+		posts[last].Copies = append(posts[last].Copies, c)
+	}
+	
+	return posts, rows.Err()
+}
+
 // GetLast24Hours retrieves messages from the last 24 hours for a chat
 func (r *RawMessageRepository) GetLast24Hours(chatID int64) ([]*database.RawMessage, error) {
 	now := time.Now()
@@ -148,3 +652,33 @@ func (r *RawMessageRepository) CountTotal() (int, error) {
 	
 	return count, nil
 }
+
+// rawMessageColumns lists the raw_messages columns in scanRawMessage order
+const rawMessageColumns = `id, chat_id, telegram_msg_id, sender_id, sender_name, message_text, media,
+		is_transcribed, is_forward, forward_source_name, created_at, saved_at, edited_at, deleted_at,
+		reply_to_msg_id, reply_to_top_id, topic_id, forward_from_id, forward_msg_id, forward_date`
+
+func scanRawMessage(row rowScanner, msg *database.RawMessage) error {
+	return row.Scan(
+		&msg.ID,
+		&msg.ChatID,
+		&msg.TelegramMsgID,
+		&msg.SenderID,
+		&msg.SenderName,
+		&msg.MessageText,
+		&msg.Media,
+		&msg.IsTranscribed,
+		&msg.IsForward,
+		&msg.ForwardSourceName,
+		&msg.CreatedAt,
+		&msg.SavedAt,
+		&msg.EditedAt,
+		&msg.DeletedAt,
+		&msg.ReplyToMsgID,
+		&msg.ReplyToTopID,
+		&msg.TopicID,
+		&msg.ForwardFromID,
+		&msg.ForwardMsgID,
+		&msg.ForwardDate,
+	)
+}
@@ -0,0 +1,340 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"telemonitor/internal/database"
+)
+
+// testDSNEnv names the variable holding a Postgres DSN for the tests that
+// need a database, e.g. postgres://postgres@localhost/telemonitor_test?sslmode=disable
+const testDSNEnv = "TELEMONITOR_TEST_DSN"
+
+// benchBatchSize is the number of messages per CreateBatch call, the page
+// size of a catch-up or a few backfill pages
+const benchBatchSize = 1000
+
+// openTestDB connects to the test database, applies the migrations and
+// creates a monitored chat removed with its messages when tb ends
+func openTestDB(tb testing.TB, chatID int64) *database.DB {
+	tb.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		tb.Skipf("%s is not set", testDSNEnv)
+	}
+
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db := &database.DB{DB: conn}
+	tb.Cleanup(func() { db.Close() })
+	if err := database.RunMigrations(db); err != nil {
+		tb.Fatal(err)
+	}
+
+	chats := NewMonitoredChatRepository(db)
+	if err := chats.Create(&database.MonitoredChat{ChatID: chatID, IsActive: true}); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := chats.Delete(chatID); err != nil {
+			tb.Error(err)
+		}
+	})
+	return db
+}
+
+// testMessages builds n messages of a chat with IDs from first
+func testMessages(chatID int64, first, n int) []*database.RawMessage {
+	msgs := make([]*database.RawMessage, n)
+	for i := range msgs {
+		msgs[i] = &database.RawMessage{
+			ChatID:        chatID,
+			TelegramMsgID: first + i,
+			SenderID:      sql.NullInt64{Int64: 1000 + int64(i%50), Valid: true},
+			SenderName:    sql.NullString{String: fmt.Sprintf("user %d", i%50), Valid: true},
+			MessageText:   sql.NullString{String: fmt.Sprintf("message %d with some ordinary chat text", first+i), Valid: true},
+			CreatedAt:     time.Now().UTC().Add(-time.Duration(n-i) * time.Second),
+		}
+	}
+	return msgs
+}
+
+func TestCreateBatch(t *testing.T) {
+	const chatID = -1009000000001
+	repo := NewRawMessageRepository(openTestDB(t, chatID))
+
+	existing := testMessages(chatID, 1, 1)[0]
+	if err := repo.Create(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	// Message 1 is already stored and message 2 is in the batch twice
+	batch := testMessages(chatID, 1, 3)
+	batch = append(batch, testMessages(chatID, 2, 1)...)
+	media := database.Media{Type: database.MediaPoll, Question: "Which \"wallet\"?", Options: []string{"hot", "cold"}}
+	batch[2].Media = media
+	created, err := repo.CreateBatch(batch)
+	if err != nil {
+		t.Fatalf("CreateBatch: %v", err)
+	}
+
+	if len(created) != 2 {
+		t.Fatalf("created %d messages, want 2", len(created))
+	}
+	for i, msg := range created {
+		if want := i + 2; msg.TelegramMsgID != want {
+			t.Errorf("created[%d] is message %d, want %d", i, msg.TelegramMsgID, want)
+		}
+		if msg.ID == 0 {
+			t.Errorf("message %d has no ID", msg.TelegramMsgID)
+		}
+	}
+	if batch[0].ID != 0 {
+		t.Errorf("stored message got ID %d", batch[0].ID)
+	}
+
+	count, err := repo.CountByChat(chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("chat has %d messages, want 3", count)
+	}
+
+	stored, err := repo.GetByChatIDAndTimeRange(chatID, time.Now().Add(-time.Hour), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var withMedia *database.RawMessage
+	for _, msg := range stored {
+		if msg.TelegramMsgID == 3 {
+			withMedia = msg
+		}
+	}
+	if withMedia == nil {
+		t.Fatal("message 3 not stored")
+	}
+	if !reflect.DeepEqual(withMedia.Media, media) {
+		t.Errorf("message 3 media = %+v, want %+v", withMedia.Media, media)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	const chatID = -1009000000004
+	repo := NewRawMessageRepository(openTestDB(t, chatID))
+
+	msgs := testMessages(chatID, 1, 3)
+	msgs[1].MessageText = sql.NullString{String: "Free AIRDROP today", Valid: true}
+	msgs[2].MessageText = sql.NullString{}
+	msgs[2].Media = database.Media{Type: database.MediaPoll, Question: "Join the airdrop?", Options: []string{"yes", "no"}}
+	for _, msg := range msgs {
+		if err := repo.Create(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start, end := time.Now().Add(-time.Hour), time.Now().Add(time.Minute)
+	tests := []struct {
+		chatID int64
+		query  string
+		want   []int
+	}{
+		{chatID, "airdrop", []int{3, 2}},
+		{0, "airdrop", []int{3, 2}},
+		{chatID, "100%", nil},
+		{chatID, "ordinary", []int{1}},
+	}
+	for _, tt := range tests {
+		found, err := repo.Search(tt.chatID, tt.query, start, end, 10)
+		if err != nil {
+			t.Fatalf("Search(%d, %q): %v", tt.chatID, tt.query, err)
+		}
+		var ids []int
+		for _, msg := range found {
+			if msg.ChatID == chatID {
+				ids = append(ids, msg.TelegramMsgID)
+			}
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+			t.Errorf("Search(%d, %q) = %v, want %v", tt.chatID, tt.query, ids, tt.want)
+		}
+	}
+}
+
+func TestGetThread(t *testing.T) {
+	const chatID = -1009000000006
+	repo := NewRawMessageRepository(openTestDB(t, chatID))
+
+	reply := func(n int) sql.NullInt64 { return sql.NullInt64{Int64: int64(n), Valid: true} }
+	msgs := testMessages(chatID, 1, 7)
+	// 2 and 3 answer 1, 4 answers 3; 5 is a comment on post 1 that
+	// answers nothing, 6 starts another chain and 7 answers it
+	msgs[1].ReplyToMsgID = reply(1)
+	msgs[2].ReplyToMsgID = reply(1)
+	msgs[3].ReplyToMsgID = reply(3)
+	msgs[4].ReplyToTopID = reply(1)
+	msgs[6].ReplyToMsgID = reply(6)
+	for _, msg := range msgs {
+		if err := repo.Create(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		root int
+		want []int
+	}{
+		{1, []int{1, 2, 3, 4, 5}},
+		{3, []int{3, 4}},
+		{6, []int{6, 7}},
+		{99, nil},
+	}
+	for _, tt := range tests {
+		thread, err := repo.GetThread(chatID, tt.root)
+		if err != nil {
+			t.Fatalf("GetThread(%d): %v", tt.root, err)
+		}
+		var ids []int
+		for _, msg := range thread {
+			ids = append(ids, msg.TelegramMsgID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+			t.Errorf("GetThread(%d) = %v, want %v", tt.root, ids, tt.want)
+		}
+	}
+}
+
+func TestGetUntranscribed(t *testing.T) {
+	const chatID = -1009000000008
+	repo := NewRawMessageRepository(openTestDB(t, chatID))
+
+	msgs := testMessages(chatID, 1, 4)
+	for _, msg := range msgs[:3] {
+		msg.MessageText = sql.NullString{}
+		msg.Media = database.Media{Type: database.MediaVoice, Duration: 3}
+	}
+	for _, msg := range msgs {
+		if err := repo.Create(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.FailTranscription(msgs[0].ID, "MSG_VOICE_MISSING"); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := repo.SaveTranscript(msgs[1].ID, "send the seed phrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.IsTranscribed || saved.MessageText.String != "send the seed phrase" {
+		t.Errorf("SaveTranscript = %q, transcribed %v", saved.MessageText.String, saved.IsTranscribed)
+	}
+
+	// Only the voice message neither transcribed nor given up on is pending
+	pending, err := repo.GetUntranscribed(time.Now().Add(-time.Hour), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, msg := range pending {
+		if msg.ChatID == chatID {
+			ids = append(ids, msg.TelegramMsgID)
+		}
+	}
+	if fmt.Sprint(ids) != "[3]" {
+		t.Errorf("GetUntranscribed = %v, want [3]", ids)
+	}
+}
+
+func TestTranscriptSurvivesEdit(t *testing.T) {
+	const chatID = -1009000000007
+	repo := NewRawMessageRepository(openTestDB(t, chatID))
+
+	msg := testMessages(chatID, 1, 1)[0]
+	msg.MessageText = sql.NullString{String: "listen", Valid: true}
+	msg.Media = database.Media{Type: database.MediaVoice, Duration: 4}
+	if err := repo.Create(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.SaveTranscript(msg.ID, "send the seed phrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	edit := testMessages(chatID, 1, 1)[0]
+	edit.MessageText = sql.NullString{String: "listen carefully", Valid: true}
+	edit.Media = msg.Media
+	changed, err := repo.RecordEdit(edit, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("RecordEdit reported the caption unchanged")
+	}
+
+	const want = "listen carefully\n\nsend the seed phrase"
+	if edit.MessageText.String != want || !edit.IsTranscribed {
+		t.Errorf("edit = %q, transcribed %v, want %q", edit.MessageText.String, edit.IsTranscribed, want)
+	}
+	found, err := repo.Search(chatID, "seed phrase", time.Now().Add(-time.Hour), time.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].MessageText.String != want || !found[0].IsTranscribed {
+		t.Fatalf("stored %+v, want the edited caption followed by the transcript", found)
+	}
+
+	// The same edit delivered again by catch-up changes nothing
+	again := testMessages(chatID, 1, 1)[0]
+	again.MessageText = sql.NullString{String: "listen carefully", Valid: true}
+	if changed, err := repo.RecordEdit(again, edit.EditedAt.Time.Add(time.Second)); err != nil || changed {
+		t.Errorf("repeated edit: changed %v, err %v", changed, err)
+	}
+
+	// A further edit within the same second is still recorded
+	quick := testMessages(chatID, 1, 1)[0]
+	quick.MessageText = sql.NullString{String: "listen very carefully", Valid: true}
+	if changed, err := repo.RecordEdit(quick, edit.EditedAt.Time.Add(time.Second)); err != nil || !changed {
+		t.Errorf("edit in the same second: changed %v, err %v", changed, err)
+	}
+}
+
+// BenchmarkCreate inserts benchBatchSize messages one INSERT at a time, as
+// the pipeline does
+func BenchmarkCreate(b *testing.B) {
+	const chatID = -1009000000002
+	repo := NewRawMessageRepository(openTestDB(b, chatID))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, msg := range testMessages(chatID, i*benchBatchSize+1, benchBatchSize) {
+			if err := repo.Create(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(b.N*benchBatchSize)/b.Elapsed().Seconds(), "msgs/s")
+}
+
+// BenchmarkCreateBatch inserts benchBatchSize messages with one CreateBatch
+func BenchmarkCreateBatch(b *testing.B) {
+	const chatID = -1009000000003
+	repo := NewRawMessageRepository(openTestDB(b, chatID))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		created, err := repo.CreateBatch(testMessages(chatID, i*benchBatchSize+1, benchBatchSize))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(created) != benchBatchSize {
+			b.Fatalf("created %d messages, want %d", len(created), benchBatchSize)
+		}
+	}
+	b.ReportMetric(float64(b.N*benchBatchSize)/b.Elapsed().Seconds(), "msgs/s")
+}
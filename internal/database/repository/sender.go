@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"telemonitor/internal/database"
+)
+
+// senderColumns is the column list scanned by scanSender
+const senderColumns = `user_id, username, first_name, last_name, is_bot, is_premium, first_seen, last_seen`
+
+// SenderRepository handles senders and sender_name_history operations
+type SenderRepository struct {
+	db *database.DB
+}
+
+// NewSenderRepository creates a new SenderRepository
+func NewSenderRepository(db *database.DB) *SenderRepository {
+	return &SenderRepository{db: db}
+}
+
+// Save records a snapshot of a sender seen writing at seenAt. When the
+// username or name differs from the stored one, the stored one is moved to
+// sender_name_history. It returns true when the name changed.
+func (r *SenderRepository) Save(s *database.Sender, seenAt time.Time) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var stored database.Sender
+	query := `SELECT username, first_name, last_name FROM senders WHERE user_id = $1 FOR UPDATE`
+	err = tx.QueryRow(query, s.UserID).Scan(&stored.Username, &stored.FirstName, &stored.LastName)
+	if err == sql.ErrNoRows {
+		query = `
+			INSERT INTO senders (user_id, username, first_name, last_name, is_bot, is_premium, first_seen, last_seen)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+			ON CONFLICT (user_id) DO NOTHING
+		`
+		if _, err := tx.Exec(query, s.UserID, s.Username, s.FirstName, s.LastName, s.IsBot, s.IsPremium, seenAt); err != nil {
+			return false, fmt.Errorf("failed to create sender: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return false, fmt.Errorf("failed to commit sender: %w", err)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get sender: %w", err)
+	}
+
+	renamed := !stored.SameName(s)
+	if renamed {
+		query = `
+			INSERT INTO sender_name_history (user_id, username, first_name, last_name)
+			VALUES ($1, $2, $3, $4)
+		`
+		if _, err := tx.Exec(query, s.UserID, stored.Username, stored.FirstName, stored.LastName); err != nil {
+			return false, fmt.Errorf("failed to save sender name: %w", err)
+		}
+	}
+
+	query = `
+		UPDATE senders
+		SET username = $2, first_name = $3, last_name = $4, is_bot = $5, is_premium = $6,
+			first_seen = LEAST(first_seen, $7), last_seen = GREATEST(last_seen, $7)
+		WHERE user_id = $1
+	`
+	if _, err := tx.Exec(query, s.UserID, s.Username, s.FirstName, s.LastName, s.IsBot, s.IsPremium, seenAt); err != nil {
+		return false, fmt.Errorf("failed to update sender: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit sender: %w", err)
+	}
+	return renamed, nil
+}
+
+// GetByID retrieves a sender by Telegram user ID
+func (r *SenderRepository) GetByID(userID int64) (*database.Sender, error) {
+	query := `SELECT ` + senderColumns + ` FROM senders WHERE user_id = $1`
+
+	s := &database.Sender{}
+	err := scanSender(r.db.QueryRow(query, userID), s)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sender: %w", err)
+	}
+	return s, nil
+}
+
+// GetByUsername retrieves a sender by current username, ignoring case and a
+// leading @
+func (r *SenderRepository) GetByUsername(username string) (*database.Sender, error) {
+	query := `SELECT ` + senderColumns + ` FROM senders WHERE LOWER(username) = LOWER($1)`
+
+	s := &database.Sender{}
+	err := scanSender(r.db.QueryRow(query, strings.TrimPrefix(username, "@")), s)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sender: %w", err)
+	}
+	return s, nil
+}
+
+// GetByIDs returns the known senders among userIDs keyed by user ID
+func (r *SenderRepository) GetByIDs(userIDs []int64) (map[int64]*database.Sender, error) {
+	senders := make(map[int64]*database.Sender, len(userIDs))
+	if len(userIDs) == 0 {
+		return senders, nil
+	}
+
+	query := `SELECT ` + senderColumns + ` FROM senders WHERE user_id = ANY($1)`
+
+	rows, err := r.db.Query(query, pq.Array(userIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get senders: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := &database.Sender{}
+		if err := scanSender(rows, s); err != nil {
+			return nil, fmt.Errorf("failed to scan sender: %w", err)
+		}
+		senders[s.UserID] = s
+	}
+
+	return senders, rows.Err()
+}
+
+// GetHistory returns the previous names of a sender, newest first
+func (r *SenderRepository) GetHistory(userID int64) ([]*database.SenderName, error) {
+	query := `
+		SELECT id, user_id, username, first_name, last_name, replaced_at
+		FROM sender_name_history
+		WHERE user_id = $1
+		ORDER BY replaced_at DESC, id DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sender history: %w", err)
+	}
+	defer rows.Close()
+
+	var names []*database.SenderName
+	for rows.Next() {
+		n := &database.SenderName{}
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Username, &n.FirstName, &n.LastName, &n.ReplacedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan sender name: %w", err)
+		}
+		names = append(names, n)
+	}
+
+	return names, rows.Err()
+}
+
+// GetRenamed returns the senders that changed their username or name since
+// a time, most renames first
+func (r *SenderRepository) GetRenamed(since time.Time, limit int) ([]*database.RenamedSender, error) {
+	query := `
+		SELECT s.user_id, s.username, s.first_name, s.last_name, s.is_bot, s.is_premium,
+			s.first_seen, s.last_seen, h.renames
+		FROM senders s
+		JOIN (
+			SELECT user_id, COUNT(*) AS renames
+			FROM sender_name_history
+			WHERE replaced_at >= $1
+			GROUP BY user_id
+		) h ON h.user_id = s.user_id
+		ORDER BY h.renames DESC, s.last_seen DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get renamed senders: %w", err)
+	}
+	defer rows.Close()
+
+	var senders []*database.RenamedSender
+	for rows.Next() {
+		s := &database.RenamedSender{}
+		err := rows.Scan(
+			&s.UserID, &s.Username, &s.FirstName, &s.LastName, &s.IsBot, &s.IsPremium,
+			&s.FirstSeen, &s.LastSeen, &s.Renames,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan renamed sender: %w", err)
+		}
+		senders = append(senders, s)
+	}
+
+	return senders, rows.Err()
+}
+
+// scanSender reads the columns listed in senderColumns
+func scanSender(row rowScanner, s *database.Sender) error {
+	return row.Scan(&s.UserID, &s.Username, &s.FirstName, &s.LastName, &s.IsBot, &s.IsPremium, &s.FirstSeen, &s.LastSeen)
+}
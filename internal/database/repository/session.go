@@ -1,30 +1,45 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log"
 
 	"telemonitor/internal/database"
+	"telemonitor/internal/secret"
 )
 
-// SessionRepository handles session_storage operations
+// SessionRepository handles session_storage operations. Values are sealed
+// with the keyring before they reach the database.
 type SessionRepository struct {
-	db *database.DB
+	db      *database.DB
+	keyring *secret.Keyring
 }
 
 // NewSessionRepository creates a new SessionRepository
-func NewSessionRepository(db *database.DB) *SessionRepository {
-	return &SessionRepository{db: db}
+func NewSessionRepository(db *database.DB, keyring *secret.Keyring) *SessionRepository {
+	return &SessionRepository{db: db, keyring: keyring}
 }
 
 // Save stores or updates a session
 func (r *SessionRepository) Save(key string, value []byte) error {
+	return r.SaveContext(context.Background(), key, value)
+}
+
+// SaveContext stores or updates a session, aborting when ctx is done
+func (r *SessionRepository) SaveContext(ctx context.Context, key string, value []byte) error {
 	query := `
 		INSERT INTO session_storage (key, value)
 		VALUES ($1, $2)
 		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value
 	`
-	_, err := r.db.Exec(query, key, value)
+	sealed, err := r.keyring.Seal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt session: %w", err)
+	}
+
+	_, err = r.db.ExecContext(ctx, query, key, sealed)
 	if err != nil {
 		return fmt.Errorf("failed to save session: %w", err)
 	}
@@ -33,10 +48,15 @@ func (r *SessionRepository) Save(key string, value []byte) error {
 
 // Load retrieves a session by key
 func (r *SessionRepository) Load(key string) ([]byte, error) {
+	return r.LoadContext(context.Background(), key)
+}
+
+// LoadContext retrieves a session by key, aborting when ctx is done
+func (r *SessionRepository) LoadContext(ctx context.Context, key string) ([]byte, error) {
 	var value []byte
 	query := `SELECT value FROM session_storage WHERE key = $1`
 	
-	err := r.db.QueryRow(query, key).Scan(&value)
+	err := r.db.QueryRowContext(ctx, query, key).Scan(&value)
 	if err == sql.ErrNoRows {
 		return nil, nil // No session found
 	}
@@ -44,15 +64,95 @@ func (r *SessionRepository) Load(key string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to load session: %w", err)
 	}
 	
-	return value, nil
+	return r.open(key, value)
 }
 
 // Delete removes a session
 func (r *SessionRepository) Delete(key string) error {
+	return r.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext removes a session, aborting when ctx is done
+func (r *SessionRepository) DeleteContext(ctx context.Context, key string) error {
 	query := `DELETE FROM session_storage WHERE key = $1`
-	_, err := r.db.Exec(query, key)
+	_, err := r.db.ExecContext(ctx, query, key)
 	if err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
 	}
 	return nil
 }
+
+// RotateKey re-encrypts every stored session with the keyring's current key.
+// Rows still in plaintext from before encryption was introduced are sealed
+// as well. It returns the number of rows rewritten.
+func (r *SessionRepository) RotateKey(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin key rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT key, value FROM session_storage FOR UPDATE`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	stored := make(map[string][]byte)
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan session: %w", err)
+		}
+		stored[key] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	rotated := 0
+	for key, value := range stored {
+		if secret.IsSealed(value) {
+			plaintext, version, err := r.keyring.Open(value)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decrypt session %s: %w", key, err)
+			}
+			if version == r.keyring.CurrentVersion() {
+				continue
+			}
+			value = plaintext
+		}
+
+		sealed, err := r.keyring.Seal(value)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt session %s: %w", key, err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE session_storage SET value = $2 WHERE key = $1`, key, sealed); err != nil {
+			return 0, fmt.Errorf("failed to update session %s: %w", key, err)
+		}
+		rotated++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit key rotation: %w", err)
+	}
+
+	return rotated, nil
+}
+
+// open decrypts a stored value; legacy plaintext rows are passed through
+// until rotate-session-key seals them
+func (r *SessionRepository) open(key string, value []byte) ([]byte, error) {
+	if !secret.IsSealed(value) {
+		log.Printf("Warning: session %s is stored unencrypted, run rotate-session-key", key)
+		return value, nil
+	}
+
+	plaintext, _, err := r.keyring.Open(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt session: %w", err)
+	}
+	return plaintext, nil
+}
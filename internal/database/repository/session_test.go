@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"telemonitor/internal/secret"
+)
+
+func TestRotateKey(t *testing.T) {
+	db := openTestDB(t, -1009000000005)
+
+	keys := map[int][]byte{1: bytes.Repeat([]byte{1}, secret.KeySize), 2: bytes.Repeat([]byte{2}, secret.KeySize)}
+	oldKeyring, err := secret.NewKeyring(1, map[int][]byte{1: keys[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := secret.NewKeyring(2, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string][]byte{
+		"test-rotate-old":     []byte(`{"old":true}`),
+		"test-rotate-plain":   []byte(`{"plain":true}`),
+		"test-rotate-current": []byte(`{"current":true}`),
+	}
+	t.Cleanup(func() {
+		for key := range values {
+			db.Exec(`DELETE FROM session_storage WHERE key = $1`, key)
+		}
+	})
+
+	if err := NewSessionRepository(db, oldKeyring).Save("test-rotate-old", values["test-rotate-old"]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO session_storage (key, value) VALUES ($1, $2)`, "test-rotate-plain", values["test-rotate-plain"]); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewSessionRepository(db, keyring)
+	if err := repo.Save("test-rotate-current", values["test-rotate-current"]); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := repo.RotateKey(context.Background())
+	if err != nil {
+		t.Fatalf("RotateKey: %v", err)
+	}
+	if rotated < 2 {
+		t.Errorf("rotated %d sessions, want at least 2", rotated)
+	}
+
+	for key, want := range values {
+		var stored []byte
+		if err := db.QueryRow(`SELECT value FROM session_storage WHERE key = $1`, key).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		plaintext, version, err := keyring.Open(stored)
+		if err != nil {
+			t.Errorf("%s: %v", key, err)
+			continue
+		}
+		if version != 2 || !bytes.Equal(plaintext, want) {
+			t.Errorf("%s = %q sealed with version %d, want %q with version 2", key, plaintext, version, want)
+		}
+	}
+
+	// Nothing is left to rotate
+	if rotated, err := repo.RotateKey(context.Background()); err != nil || rotated != 0 {
+		t.Errorf("second RotateKey = %d, %v, want 0", rotated, err)
+	}
+}
@@ -4,9 +4,15 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/lib/pq"
+
 	"telemonitor/internal/database"
 )
 
+// TriggersChannel is the NOTIFY channel a statement writing triggers
+// announces itself on; see migration 015
+const TriggersChannel = "triggers_changed"
+
 // TriggerRepository handles triggers operations
 type TriggerRepository struct {
 	db *database.DB
@@ -20,12 +26,18 @@ func NewTriggerRepository(db *database.DB) *TriggerRepository {
 // Create inserts a new trigger
 func (r *TriggerRepository) Create(trigger *database.Trigger) error {
 	query := `
-		INSERT INTO triggers (phrase, is_regex, alert_level)
-		VALUES ($1, $2, $3)
+		INSERT INTO triggers (phrase, match_mode, alert_level, event, within_minutes, normalize)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id
 	`
 	
-	err := r.db.QueryRow(query, trigger.Phrase, trigger.IsRegex, trigger.AlertLevel).Scan(&trigger.ID)
+	if trigger.Event == "" {
+		trigger.Event = database.TriggerOnMessage
+	}
+	if trigger.MatchMode == "" {
+		trigger.MatchMode = database.MatchExact
+	}
+	err := r.db.QueryRow(query, trigger.Phrase, trigger.MatchMode, trigger.AlertLevel, trigger.Event, trigger.WithinMinutes, trigger.Normalize).Scan(&trigger.ID)
 	if err != nil {
 		return fmt.Errorf("failed to create trigger: %w", err)
 	}
@@ -35,10 +47,10 @@ func (r *TriggerRepository) Create(trigger *database.Trigger) error {
 
 // GetByID retrieves a trigger by ID
 func (r *TriggerRepository) GetByID(id int) (*database.Trigger, error) {
-	query := `SELECT id, phrase, is_regex, alert_level FROM triggers WHERE id = $1`
+	query := `SELECT ` + triggerColumns + ` FROM triggers WHERE id = $1`
 	
 	trigger := &database.Trigger{}
-	err := r.db.QueryRow(query, id).Scan(&trigger.ID, &trigger.Phrase, &trigger.IsRegex, &trigger.AlertLevel)
+	err := scanTrigger(r.db.QueryRow(query, id), trigger)
 	
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -46,13 +58,16 @@ func (r *TriggerRepository) GetByID(id int) (*database.Trigger, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get trigger: %w", err)
 	}
+	if err := r.loadScopes(map[int]*database.Trigger{id: trigger}); err != nil {
+		return nil, err
+	}
 	
 	return trigger, nil
 }
 
 // GetAll retrieves all triggers
 func (r *TriggerRepository) GetAll() ([]*database.Trigger, error) {
-	query := `SELECT id, phrase, is_regex, alert_level FROM triggers ORDER BY id`
+	query := `SELECT ` + triggerColumns + ` FROM triggers ORDER BY id`
 	
 	rows, err := r.db.Query(query)
 	if err != nil {
@@ -61,26 +76,133 @@ func (r *TriggerRepository) GetAll() ([]*database.Trigger, error) {
 	defer rows.Close()
 	
 	var triggers []*database.Trigger
+	byID := make(map[int]*database.Trigger)
 	for rows.Next() {
 		trigger := &database.Trigger{}
-		if err := rows.Scan(&trigger.ID, &trigger.Phrase, &trigger.IsRegex, &trigger.AlertLevel); err != nil {
+		if err := scanTrigger(rows, trigger); err != nil {
 			return nil, fmt.Errorf("failed to scan trigger: %w", err)
 		}
 		triggers = append(triggers, trigger)
+		byID[trigger.ID] = trigger
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get all triggers: %w", err)
+	}
+	if err := r.loadScopes(byID); err != nil {
+		return nil, err
 	}
 	
 	return triggers, nil
 }
 
+// Trigger scope kinds, the rows of trigger_scopes
+const (
+	scopeChat        = "chat"
+	scopeExcludeChat = "exclude_chat"
+	scopeSender      = "sender"
+	scopeForwards    = "forwards"
+)
+
+// loadScopes fills in the scopes of triggers, keyed by ID
+func (r *TriggerRepository) loadScopes(triggers map[int]*database.Trigger) error {
+	ids := make([]int64, 0, len(triggers))
+	for id := range triggers {
+		ids = append(ids, int64(id))
+	}
+	query := `
+		SELECT trigger_id, kind, value FROM trigger_scopes
+		WHERE trigger_id = ANY($1)
+		ORDER BY trigger_id, kind, value
+	`
+
+	rows, err := r.db.Query(query, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get trigger scopes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id    int
+			kind  string
+			value sql.NullInt64
+		)
+		if err := rows.Scan(&id, &kind, &value); err != nil {
+			return fmt.Errorf("failed to scan trigger scope: %w", err)
+		}
+		scope := &triggers[id].Scope
+		switch kind {
+		case scopeChat:
+			scope.ChatIDs = append(scope.ChatIDs, value.Int64)
+		case scopeExcludeChat:
+			scope.ExcludedChatIDs = append(scope.ExcludedChatIDs, value.Int64)
+		case scopeSender:
+			scope.SenderIDs = append(scope.SenderIDs, value.Int64)
+		case scopeForwards:
+			scope.ForwardsOnly = true
+		}
+	}
+	return rows.Err()
+}
+
+// SetScope replaces the scope of a trigger
+func (r *TriggerRepository) SetScope(triggerID int, scope database.TriggerScope) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM trigger_scopes WHERE trigger_id = $1`, triggerID); err != nil {
+		return fmt.Errorf("failed to clear trigger scope: %w", err)
+	}
+
+	query := `
+		INSERT INTO trigger_scopes (trigger_id, kind, value)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`
+	insert := func(kind string, value sql.NullInt64) error {
+		if _, err := tx.Exec(query, triggerID, kind, value); err != nil {
+			return fmt.Errorf("failed to set trigger scope: %w", err)
+		}
+		return nil
+	}
+	for _, set := range []struct {
+		kind string
+		ids  []int64
+	}{
+		{scopeChat, scope.ChatIDs},
+		{scopeExcludeChat, scope.ExcludedChatIDs},
+		{scopeSender, scope.SenderIDs},
+	} {
+		for _, id := range set.ids {
+			if err := insert(set.kind, sql.NullInt64{Int64: id, Valid: true}); err != nil {
+				return err
+			}
+		}
+	}
+	if scope.ForwardsOnly {
+		if err := insert(scopeForwards, sql.NullInt64{}); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit trigger scope: %w", err)
+	}
+	return nil
+}
+
 // Update updates a trigger
 func (r *TriggerRepository) Update(trigger *database.Trigger) error {
 	query := `
 		UPDATE triggers
-		SET phrase = $2, is_regex = $3, alert_level = $4
+		SET phrase = $2, match_mode = $3, alert_level = $4, event = $5, within_minutes = $6, normalize = $7
 		WHERE id = $1
 	`
 	
-	_, err := r.db.Exec(query, trigger.ID, trigger.Phrase, trigger.IsRegex, trigger.AlertLevel)
+	_, err := r.db.Exec(query, trigger.ID, trigger.Phrase, trigger.MatchMode, trigger.AlertLevel, trigger.Event, trigger.WithinMinutes, trigger.Normalize)
 	if err != nil {
 		return fmt.Errorf("failed to update trigger: %w", err)
 	}
@@ -99,3 +221,10 @@ func (r *TriggerRepository) Delete(id int) error {
 	
 	return nil
 }
+
+// triggerColumns lists the triggers columns in scanTrigger order
+const triggerColumns = `id, phrase, match_mode, alert_level, event, within_minutes, normalize`
+
+func scanTrigger(row rowScanner, t *database.Trigger) error {
+	return row.Scan(&t.ID, &t.Phrase, &t.MatchMode, &t.AlertLevel, &t.Event, &t.WithinMinutes, &t.Normalize)
+}
@@ -0,0 +1,282 @@
+package ingestion
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+	"telemonitor/internal/userbot"
+)
+
+// backfillClientWait bounds how long a job waits for the account that reads
+// its chat to come online
+const backfillClientWait = 2 * time.Minute
+
+// ErrBackfillRunning is returned when a chat already has a backfill in
+// progress in this process
+var ErrBackfillRunning = errors.New("backfill already running for this chat")
+
+// BackfillReporter is told about the progress of backfill jobs
+type BackfillReporter interface {
+	ReportBackfill(job *database.BackfillJob)
+}
+
+// Backfill imports the history of a chat into raw_messages, newest first,
+// until it reaches the requested start date. The position is saved after
+// every page so an interrupted job continues where it stopped. Messages are
+// written directly rather than through the pipeline, a page at a time with
+// CreateBatch: they are too old to raise alerts and must not move the chat's
+// pts.
+type Backfill struct {
+	jobs     *repository.BackfillRepository
+	chats    *repository.MonitoredChatRepository
+	messages *repository.RawMessageRepository
+	pool     *userbot.Pool
+	peers    *peerCache
+	reporter BackfillReporter
+	senders  *Senders
+
+	mu      sync.Mutex
+	running map[int64]bool
+}
+
+// NewBackfill creates a Backfill
+func NewBackfill(jobs *repository.BackfillRepository, chats *repository.MonitoredChatRepository, messages *repository.RawMessageRepository, pool *userbot.Pool) *Backfill {
+	return &Backfill{
+		jobs:     jobs,
+		chats:    chats,
+		messages: messages,
+		pool:     pool,
+		peers:    newPeerCache(),
+		running:  make(map[int64]bool),
+	}
+}
+
+// SetReporter sets where progress is reported. It must be called before
+// Start or Resume.
+func (b *Backfill) SetReporter(reporter BackfillReporter) {
+	b.reporter = reporter
+}
+
+// SetSenders makes imported messages update the senders directory. It must
+// be called before Start or Resume.
+func (b *Backfill) SetSenders(s *Senders) {
+	b.senders = s
+}
+
+// Start begins importing the last days of a chat in the background
+func (b *Backfill) Start(ctx context.Context, chatID int64, days int) (*database.BackfillJob, error) {
+	if !b.claim(chatID) {
+		return nil, ErrBackfillRunning
+	}
+	job, err := b.Create(chatID, days)
+	if err != nil {
+		b.release(chatID)
+		return nil, err
+	}
+
+	go func() {
+		defer b.release(chatID)
+		b.run(ctx, job)
+	}()
+	return job, nil
+}
+
+// Create records a job for the last days of a chat without running it. An
+// unfinished job for the same chat is reused.
+func (b *Backfill) Create(chatID int64, days int) (*database.BackfillJob, error) {
+	chat, err := b.chats.GetByChatID(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if chat == nil {
+		return nil, fmt.Errorf("chat %d is not monitored", chatID)
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -days)
+	return b.jobs.Start(chatID, since)
+}
+
+// Resume restarts the jobs that were running when the process stopped
+func (b *Backfill) Resume(ctx context.Context) error {
+	jobs, err := b.jobs.GetRunning()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if !b.claim(job.ChatID) {
+			continue
+		}
+		log.Printf("Resuming backfill of chat %d from message %d", job.ChatID, job.OffsetID)
+		go func(job *database.BackfillJob) {
+			defer b.release(job.ChatID)
+			b.run(ctx, job)
+		}(job)
+	}
+	return nil
+}
+
+// Run imports a job in the foreground and returns its final state
+func (b *Backfill) Run(ctx context.Context, job *database.BackfillJob) error {
+	if !b.claim(job.ChatID) {
+		return ErrBackfillRunning
+	}
+	defer b.release(job.ChatID)
+
+	b.run(ctx, job)
+	if job.Status == database.BackfillFailed {
+		return errors.New(job.LastError.String)
+	}
+	return ctx.Err()
+}
+
+func (b *Backfill) claim(chatID int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running[chatID] {
+		return false
+	}
+	b.running[chatID] = true
+	return true
+}
+
+func (b *Backfill) release(chatID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.running, chatID)
+}
+
+// run pages through the job and records how it ended. A job interrupted by
+// ctx stays running so Resume picks it up.
+func (b *Backfill) run(ctx context.Context, job *database.BackfillJob) {
+	b.report(job)
+
+	err := b.fetch(ctx, job)
+	if ctx.Err() != nil {
+		return
+	}
+
+	job.Status = database.BackfillDone
+	job.LastError = sql.NullString{}
+	if err != nil {
+		log.Printf("Backfill of chat %d failed: %v", job.ChatID, err)
+		job.Status = database.BackfillFailed
+		job.LastError = sql.NullString{String: err.Error(), Valid: true}
+	}
+	if err := b.jobs.Finish(job.ID, job.Status, job.LastError); err != nil {
+		log.Printf("Failed to finish backfill job %d: %v", job.ID, err)
+	}
+	b.report(job)
+}
+
+// fetch pages backwards from job.OffsetID until job.Since
+func (b *Backfill) fetch(ctx context.Context, job *database.BackfillJob) error {
+	client, err := b.client(ctx, job.ChatID)
+	if err != nil {
+		return err
+	}
+	peer, err := b.peers.inputPeer(ctx, client, job.ChatID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		res, err := client.API().MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+			Peer:     peer,
+			OffsetID: job.OffsetID,
+			Limit:    historyPageSize,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get history: %w", err)
+		}
+		page, ok := res.AsModified()
+		if !ok || len(page.GetMessages()) == 0 {
+			return nil
+		}
+
+		e := Entities(page.GetUsers(), page.GetChats())
+		reachedSince := false
+		var batch []*database.RawMessage
+		senders := make(pageSenders)
+		for _, m := range page.GetMessages() {
+			if job.OffsetID == 0 || m.GetID() < job.OffsetID {
+				job.OffsetID = m.GetID()
+			}
+
+			msg := Convert(e, m)
+			if msg == nil {
+				continue
+			}
+			if msg.CreatedAt.Before(job.Since) {
+				reachedSince = true
+				continue
+			}
+
+			job.Fetched++
+			if sender := Sender(e, m); sender != nil {
+				senders.add(sender, msg.CreatedAt)
+			}
+			batch = append(batch, msg)
+		}
+		if b.senders != nil {
+			senders.observe(b.senders)
+		}
+
+		created, err := b.messages.CreateBatch(batch)
+		if err != nil {
+			return err
+		}
+		job.Stored += len(created)
+
+		if err := b.jobs.SaveProgress(job); err != nil {
+			return err
+		}
+		b.report(job)
+
+		if reachedSince || job.OffsetID <= 1 {
+			return nil
+		}
+	}
+}
+
+// client waits for the account assigned to the chat to be logged in
+func (b *Backfill) client(ctx context.Context, chatID int64) (*userbot.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, backfillClientWait)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		chat, err := b.chats.GetByChatID(chatID)
+		if err != nil {
+			return nil, err
+		}
+		if chat == nil {
+			return nil, fmt.Errorf("chat %d is no longer monitored", chatID)
+		}
+		if client := b.pool.ClientForChat(chat); client != nil && client.Authorized() {
+			return client, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no logged in account for chat %d", chatID)
+		}
+	}
+}
+
+func (b *Backfill) report(job *database.BackfillJob) {
+	if b.reporter != nil {
+		b.reporter.ReportBackfill(job)
+	}
+}
@@ -0,0 +1,328 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/gotd/td/constant"
+	"github.com/gotd/td/tg"
+
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+	"telemonitor/internal/userbot"
+)
+
+const (
+	// differenceLimit is the page size of updates.getChannelDifference
+	differenceLimit = 100
+	// historyPageSize is the page size of messages.getHistory
+	historyPageSize = 100
+	// topicsPageSize is the page size of channels.getForumTopics
+	topicsPageSize = 100
+)
+
+// Catchup replays what active channels missed while no account was reading
+// them. A channel resumes from its stored pts via
+// updates.getChannelDifference; when Telegram answers that the gap is too
+// long, the history after LastProcessedMsgID is fetched instead. Messages,
+// edits and deletions go through the pipeline in order, followed by a pts
+// commit, so the stored pts never gets ahead of raw_messages. Edits and
+// deletions are lost in the history fallback.
+type Catchup struct {
+	pipeline *Pipeline
+	chats    *repository.MonitoredChatRepository
+	pool     *userbot.Pool
+
+	peers *peerCache
+
+	mu      sync.Mutex
+	running map[int64]bool
+}
+
+// NewCatchup creates a Catchup feeding pipeline
+func NewCatchup(pipeline *Pipeline, chats *repository.MonitoredChatRepository, pool *userbot.Pool) *Catchup {
+	return &Catchup{
+		pipeline: pipeline,
+		chats:    chats,
+		pool:     pool,
+		peers:    newPeerCache(),
+		running:  make(map[int64]bool),
+	}
+}
+
+// Chats catches up the channels among chats using client. Its signature
+// matches userbot.ReadyHandler. Chats already being caught up are skipped.
+func (c *Catchup) Chats(ctx context.Context, client *userbot.Client, chats []*database.MonitoredChat) {
+	for _, chat := range chats {
+		if !constant.TDLibPeerID(chat.ChatID).IsChannel() || !c.claim(chat.ChatID) {
+			continue
+		}
+
+		err := c.chat(ctx, client, chat)
+		c.release(chat.ChatID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Catch-up of chat %d via %s failed: %v", chat.ChatID, client.Name(), err)
+		}
+	}
+}
+
+// Gap catches up a single chat after the pipeline saw its pts jump
+func (c *Catchup) Gap(ctx context.Context, chatID int64) {
+	chat, err := c.chats.GetByChatID(chatID)
+	if err != nil || chat == nil {
+		return
+	}
+	client := c.pool.ClientForChat(chat)
+	if client == nil || !client.Authorized() {
+		return
+	}
+	c.Chats(ctx, client, []*database.MonitoredChat{chat})
+}
+
+func (c *Catchup) claim(chatID int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running[chatID] {
+		return false
+	}
+	c.running[chatID] = true
+	return true
+}
+
+func (c *Catchup) release(chatID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.running, chatID)
+}
+
+// chat catches up one channel
+func (c *Catchup) chat(ctx context.Context, client *userbot.Client, chat *database.MonitoredChat) error {
+	peer, err := c.peers.channel(ctx, client, chat.ChatID)
+	if err != nil {
+		return err
+	}
+	api := client.API()
+
+	if peer.Forum {
+		if err := c.topics(ctx, api, chat.ChatID, peer.Input); err != nil {
+			log.Printf("Failed to load topics of chat %d: %v", chat.ChatID, err)
+		}
+	}
+
+	if chat.LastPts == 0 {
+		// Never synced, there is no pts to diff against
+		if chat.LastProcessedMsgID > 0 {
+			if err := c.history(ctx, api, chat, peer.Input); err != nil {
+				return err
+			}
+		}
+		// The cached pts is from when the dialogs were loaded, so ask
+		// Telegram where the channel is now
+		pts, err := c.currentPts(ctx, api, peer)
+		if err != nil {
+			return err
+		}
+		c.peers.setPts(client, chat.ChatID, pts)
+		return c.pipeline.Commit(ctx, chat.ChatID, pts)
+	}
+
+	pts := chat.LastPts
+	for {
+		diff, err := api.UpdatesGetChannelDifference(ctx, &tg.UpdatesGetChannelDifferenceRequest{
+			Channel: peer.Input,
+			Filter:  &tg.ChannelMessagesFilterEmpty{},
+			Pts:     pts,
+			Limit:   differenceLimit,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get channel difference: %w", err)
+		}
+
+		switch d := diff.(type) {
+		case *tg.UpdatesChannelDifferenceEmpty:
+			return c.pipeline.Commit(ctx, chat.ChatID, d.Pts)
+
+		case *tg.UpdatesChannelDifference:
+			if err := c.submit(ctx, d.NewMessages, d.Users, d.Chats); err != nil {
+				return err
+			}
+			if err := c.others(ctx, d.OtherUpdates, d.Users, d.Chats); err != nil {
+				return err
+			}
+			if err := c.pipeline.Commit(ctx, chat.ChatID, d.Pts); err != nil {
+				return err
+			}
+			if d.Final {
+				return nil
+			}
+			pts = d.Pts
+
+		case *tg.UpdatesChannelDifferenceTooLong:
+			log.Printf("Chat %d is too far behind (pts %d), fetching history after message %d",
+				chat.ChatID, pts, chat.LastProcessedMsgID)
+			if chat.LastProcessedMsgID > 0 {
+				err = c.history(ctx, api, chat, peer.Input)
+			} else {
+				err = c.submit(ctx, d.Messages, d.Users, d.Chats)
+			}
+			if err != nil {
+				return err
+			}
+			dialog, ok := d.Dialog.(*tg.Dialog)
+			if !ok {
+				return nil
+			}
+			newPts, _ := dialog.GetPts()
+			return c.pipeline.Commit(ctx, chat.ChatID, newPts)
+
+		default:
+			return fmt.Errorf("unexpected channel difference %T", diff)
+		}
+	}
+}
+
+// currentPts follows the channel difference from the cached pts to the
+// channel's current one, without submitting what it skips over
+func (c *Catchup) currentPts(ctx context.Context, api *tg.Client, peer userbot.ChannelPeer) (int, error) {
+	pts := max(peer.Pts, 1)
+	for {
+		diff, err := api.UpdatesGetChannelDifference(ctx, &tg.UpdatesGetChannelDifferenceRequest{
+			Channel: peer.Input,
+			Filter:  &tg.ChannelMessagesFilterEmpty{},
+			Pts:     pts,
+			Limit:   differenceLimit,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to get channel difference: %w", err)
+		}
+
+		switch d := diff.(type) {
+		case *tg.UpdatesChannelDifferenceEmpty:
+			return d.Pts, nil
+		case *tg.UpdatesChannelDifference:
+			if d.Final {
+				return d.Pts, nil
+			}
+			pts = d.Pts
+		case *tg.UpdatesChannelDifferenceTooLong:
+			dialog, ok := d.Dialog.(*tg.Dialog)
+			if !ok {
+				return pts, nil
+			}
+			newPts, _ := dialog.GetPts()
+			return newPts, nil
+		default:
+			return 0, fmt.Errorf("unexpected channel difference %T", diff)
+		}
+	}
+}
+
+// topics submits the titles of every topic in a forum, so topics created
+// before the chat was monitored are named too
+func (c *Catchup) topics(ctx context.Context, api *tg.Client, chatID int64, channel *tg.InputChannel) error {
+	req := &tg.ChannelsGetForumTopicsRequest{Channel: channel, Limit: topicsPageSize}
+	for {
+		res, err := api.ChannelsGetForumTopics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to get forum topics: %w", err)
+		}
+
+		var last *tg.ForumTopic
+		for _, t := range res.Topics {
+			topic, ok := t.(*tg.ForumTopic)
+			if !ok {
+				continue
+			}
+			last = topic
+			err := c.pipeline.SubmitTopic(ctx, &database.ForumTopic{ChatID: chatID, TopicID: topic.ID, Title: topic.Title})
+			if err != nil {
+				return err
+			}
+		}
+		if len(res.Topics) < topicsPageSize || last == nil {
+			return nil
+		}
+		req.OffsetDate, req.OffsetID, req.OffsetTopic = last.Date, last.TopMessage, last.ID
+	}
+}
+
+// history submits the messages after chat.LastProcessedMsgID, oldest first
+func (c *Catchup) history(ctx context.Context, api *tg.Client, chat *database.MonitoredChat, channel *tg.InputChannel) error {
+	peer := &tg.InputPeerChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash}
+	after := chat.LastProcessedMsgID
+
+	for {
+		// offset_id just above the last seen message with a negative
+		// add_offset pages forward in time
+		res, err := api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+			Peer:      peer,
+			OffsetID:  after + 1,
+			AddOffset: -historyPageSize,
+			Limit:     historyPageSize,
+			MinID:     after,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get history: %w", err)
+		}
+		page, ok := res.AsModified()
+		if !ok {
+			return nil
+		}
+
+		var messages []tg.MessageClass
+		for _, m := range page.GetMessages() {
+			if m.GetID() > after {
+				messages = append(messages, m)
+			}
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		if err := c.submit(ctx, messages, page.GetUsers(), page.GetChats()); err != nil {
+			return err
+		}
+		for _, m := range messages {
+			if m.GetID() > after {
+				after = m.GetID()
+			}
+		}
+	}
+}
+
+// submit queues messages in ascending ID order
+func (c *Catchup) submit(ctx context.Context, messages []tg.MessageClass, users []tg.UserClass, chats []tg.ChatClass) error {
+	e := Entities(users, chats)
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].GetID() < messages[j].GetID()
+	})
+	for _, m := range messages {
+		if err := c.pipeline.Submit(ctx, e, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// others queues the edits and deletions of a channel difference
+func (c *Catchup) others(ctx context.Context, updates []tg.UpdateClass, users []tg.UserClass, chats []tg.ChatClass) error {
+	e := Entities(users, chats)
+	for _, u := range updates {
+		var err error
+		switch u := u.(type) {
+		case *tg.UpdateEditChannelMessage:
+			err = c.pipeline.SubmitEdit(ctx, e, u.Message)
+		case *tg.UpdateDeleteChannelMessages:
+			err = c.pipeline.SubmitDelete(ctx, ChatID(&tg.PeerChannel{ChannelID: u.ChannelID}), u.Messages)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package ingestion
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/constant"
+	"github.com/gotd/td/tg"
+
+	"telemonitor/internal/database"
+)
+
+// ChatID returns the Bot API style ID used in monitored_chats: users keep
+// their ID, basic groups are negated and channels get the -100 prefix.
+func ChatID(peer tg.PeerClass) int64 {
+	var id constant.TDLibPeerID
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		id.User(p.UserID)
+	case *tg.PeerChat:
+		id.Chat(p.ChatID)
+	case *tg.PeerChannel:
+		id.Channel(p.ChannelID)
+	default:
+		return 0
+	}
+	return int64(id)
+}
+
+// Convert turns a gotd message into a raw_messages row. It returns nil for
+// service messages and empty placeholders.
+func Convert(e tg.Entities, m tg.MessageClass) *database.RawMessage {
+	msg, ok := m.(*tg.Message)
+	if !ok {
+		return nil
+	}
+
+	raw := &database.RawMessage{
+		ChatID:        ChatID(msg.PeerID),
+		TelegramMsgID: msg.ID,
+		CreatedAt:     time.Unix(int64(msg.Date), 0).UTC(),
+	}
+	if msg.Message != "" {
+		raw.MessageText = sql.NullString{String: msg.Message, Valid: true}
+	}
+	if media, ok := msg.GetMedia(); ok {
+		raw.Media = convertMedia(media)
+	}
+	if date, ok := msg.GetEditDate(); ok {
+		raw.EditedAt = sql.NullTime{Time: time.Unix(int64(date), 0).UTC(), Valid: true}
+	}
+
+	if from, ok := msg.GetFromID(); ok {
+		raw.SenderID = sql.NullInt64{Int64: ChatID(from), Valid: true}
+		raw.SenderName = nullString(peerName(e, from))
+	} else if msg.Post {
+		// Channel posts have no sender, the channel itself is the author
+		name := peerName(e, msg.PeerID)
+		if author, ok := msg.GetPostAuthor(); ok {
+			name = author
+		}
+		raw.SenderName = nullString(name)
+	}
+
+	if header, ok := msg.ReplyTo.(*tg.MessageReplyHeader); ok {
+		setReply(raw, header)
+	}
+
+	if fwd, ok := msg.GetFwdFrom(); ok {
+		raw.IsForward = true
+		name, _ := fwd.GetFromName()
+		if from, ok := fwd.GetFromID(); ok && name == "" {
+			name = peerName(e, from)
+		}
+		raw.ForwardSourceName = nullString(name)
+		setForward(raw, fwd)
+	}
+
+	return raw
+}
+
+// setForward records where a forwarded message was originally posted. Posts
+// forwarded from a channel keep the channel and post ID; forwards from users
+// who hide their account have no source ID.
+func setForward(raw *database.RawMessage, fwd tg.MessageFwdHeader) {
+	if from, ok := fwd.GetFromID(); ok {
+		raw.ForwardFromID = sql.NullInt64{Int64: ChatID(from), Valid: true}
+	}
+	if post, ok := fwd.GetChannelPost(); ok && raw.ForwardFromID.Valid {
+		raw.ForwardMsgID = nullInt(post)
+	}
+	if fwd.Date != 0 {
+		raw.ForwardDate = sql.NullTime{Time: time.Unix(int64(fwd.Date), 0).UTC(), Valid: true}
+	}
+}
+
+// Sender returns the user who wrote a message, as described by the entities
+// sent with it. It returns nil for channel posts and senders missing from
+// the entities.
+func Sender(e tg.Entities, m tg.MessageClass) *database.Sender {
+	msg, ok := m.(*tg.Message)
+	if !ok {
+		return nil
+	}
+	from, ok := msg.GetFromID()
+	if !ok {
+		return nil
+	}
+	peer, ok := from.(*tg.PeerUser)
+	if !ok {
+		return nil
+	}
+	u, ok := e.Users[peer.UserID]
+	if !ok {
+		return nil
+	}
+
+	username := u.Username
+	if username == "" {
+		// Accounts with collectible usernames list them separately
+		for _, un := range u.Usernames {
+			if un.Active {
+				username = un.Username
+				break
+			}
+		}
+	}
+	return &database.Sender{
+		UserID:    u.ID,
+		Username:  nullString(username),
+		FirstName: nullString(u.FirstName),
+		LastName:  nullString(u.LastName),
+		IsBot:     u.Bot,
+		IsPremium: u.Premium,
+	}
+}
+
+// setReply records where a message sits in a reply chain, comment thread or
+// forum topic
+func setReply(raw *database.RawMessage, header *tg.MessageReplyHeader) {
+	replyTo, hasReply := header.GetReplyToMsgID()
+	if _, otherChat := header.GetReplyToPeerID(); hasReply && !otherChat {
+		raw.ReplyToMsgID = nullInt(replyTo)
+	}
+
+	top, hasTop := header.GetReplyToTopID()
+	if hasTop {
+		raw.ReplyToTopID = nullInt(top)
+	}
+
+	if header.ForumTopic {
+		// A message posted directly in a topic replies to the topic's first
+		// message; a reply inside a topic has the topic as its top message
+		if hasTop {
+			raw.TopicID = nullInt(top)
+		} else if hasReply {
+			raw.TopicID = nullInt(replyTo)
+		}
+	}
+}
+
+// Topic returns the forum topic a service message creates or renames
+func Topic(m tg.MessageClass) (*database.ForumTopic, bool) {
+	msg, ok := m.(*tg.MessageService)
+	if !ok {
+		return nil, false
+	}
+
+	topic := &database.ForumTopic{ChatID: ChatID(msg.PeerID)}
+	switch action := msg.Action.(type) {
+	case *tg.MessageActionTopicCreate:
+		topic.TopicID = msg.ID
+		topic.Title = action.Title
+	case *tg.MessageActionTopicEdit:
+		header, ok := msg.ReplyTo.(*tg.MessageReplyHeader)
+		if !ok || action.Title == "" {
+			return nil, false
+		}
+		topic.TopicID = header.ReplyToMsgID
+		if top, ok := header.GetReplyToTopID(); ok {
+			topic.TopicID = top
+		}
+		topic.Title = action.Title
+	default:
+		return nil, false
+	}
+	return topic, topic.TopicID != 0
+}
+
+// Entities indexes the users and chats returned alongside messages by
+// history and difference requests
+func Entities(users []tg.UserClass, chats []tg.ChatClass) tg.Entities {
+	return tg.Entities{
+		Users:    tg.UserClassArray(users).UserToMap(),
+		Chats:    tg.ChatClassArray(chats).ChatToMap(),
+		Channels: tg.ChatClassArray(chats).ChannelToMap(),
+	}
+}
+
+// peerName resolves a display name from the entities sent with the update
+func peerName(e tg.Entities, peer tg.PeerClass) string {
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		if u, ok := e.Users[p.UserID]; ok {
+			if name := strings.TrimSpace(u.FirstName + " " + u.LastName); name != "" {
+				return name
+			}
+			return u.Username
+		}
+	case *tg.PeerChat:
+		if c, ok := e.Chats[p.ChatID]; ok {
+			return c.Title
+		}
+	case *tg.PeerChannel:
+		if c, ok := e.Channels[p.ChannelID]; ok {
+			return c.Title
+		}
+	}
+	return ""
+}
+
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: true}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,61 @@
+package ingestion
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/gotd/td/tg"
+
+	"telemonitor/internal/database"
+)
+
+func TestSetReply(t *testing.T) {
+	// header builds a reply header; zero values are left unset
+	header := func(replyTo, top int, otherChat, forum bool) *tg.MessageReplyHeader {
+		h := &tg.MessageReplyHeader{}
+		if replyTo != 0 {
+			h.SetReplyToMsgID(replyTo)
+		}
+		if top != 0 {
+			h.SetReplyToTopID(top)
+		}
+		if otherChat {
+			h.SetReplyToPeerID(&tg.PeerChannel{ChannelID: 42})
+		}
+		h.SetForumTopic(forum)
+		return h
+	}
+
+	tests := []struct {
+		name                string
+		header              *tg.MessageReplyHeader
+		replyTo, top, topic int
+	}{
+		{"empty", header(0, 0, false, false), 0, 0, 0},
+		{"reply", header(10, 0, false, false), 10, 0, 0},
+		{"reply to another chat", header(10, 0, true, false), 0, 0, 0},
+		{"comment", header(12, 5, false, false), 12, 5, 0},
+		{"post in topic", header(7, 0, false, true), 7, 0, 7},
+		{"reply in topic", header(9, 7, false, true), 9, 7, 7},
+		{"topic without message", header(0, 0, false, true), 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := &database.RawMessage{}
+			setReply(raw, tt.header)
+			for _, f := range []struct {
+				field string
+				got   sql.NullInt64
+				want  int
+			}{
+				{"ReplyToMsgID", raw.ReplyToMsgID, tt.replyTo},
+				{"ReplyToTopID", raw.ReplyToTopID, tt.top},
+				{"TopicID", raw.TopicID, tt.topic},
+			} {
+				if want := (sql.NullInt64{Int64: int64(f.want), Valid: f.want != 0}); f.got != want {
+					t.Errorf("%s = %+v, want %+v", f.field, f.got, want)
+				}
+			}
+		})
+	}
+}
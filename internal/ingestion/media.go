@@ -0,0 +1,149 @@
+package ingestion
+
+import (
+	"strings"
+
+	"github.com/gotd/td/tg"
+
+	"telemonitor/internal/database"
+)
+
+// convertMedia extracts the metadata of a message attachment. File contents
+// are not downloaded.
+func convertMedia(media tg.MessageMediaClass) database.Media {
+	switch m := media.(type) {
+	case *tg.MessageMediaPhoto:
+		out := database.Media{Type: database.MediaPhoto}
+		if photo, ok := m.Photo.(*tg.Photo); ok {
+			out.Size = photoSize(photo.Sizes)
+		}
+		return out
+
+	case *tg.MessageMediaDocument:
+		doc, ok := m.Document.(*tg.Document)
+		if !ok {
+			return database.Media{Type: database.MediaDocument}
+		}
+		return convertDocument(doc)
+
+	case *tg.MessageMediaPoll:
+		out := database.Media{Type: database.MediaPoll, Question: m.Poll.Question}
+		for _, answer := range m.Poll.Answers {
+			out.Options = append(out.Options, answer.Text)
+		}
+		return out
+
+	case *tg.MessageMediaGeo:
+		out := database.Media{Type: database.MediaGeo}
+		setGeo(&out, m.Geo)
+		return out
+
+	case *tg.MessageMediaGeoLive:
+		out := database.Media{Type: database.MediaGeoLive, Duration: m.Period}
+		setGeo(&out, m.Geo)
+		return out
+
+	case *tg.MessageMediaVenue:
+		out := database.Media{Type: database.MediaVenue, Title: m.Title, Address: m.Address}
+		setGeo(&out, m.Geo)
+		return out
+
+	case *tg.MessageMediaContact:
+		return database.Media{
+			Type:  database.MediaContact,
+			Title: strings.TrimSpace(m.FirstName + " " + m.LastName),
+			Phone: m.PhoneNumber,
+		}
+
+	case *tg.MessageMediaWebPage:
+		out := database.Media{Type: database.MediaWebPage}
+		if page, ok := m.Webpage.(*tg.WebPage); ok {
+			out.URL = page.URL
+			out.Title = page.Title
+			if out.Title == "" {
+				out.Title = page.SiteName
+			}
+		} else if page, ok := m.Webpage.(*tg.WebPagePending); ok {
+			out.URL = page.URL
+		}
+		return out
+
+	case *tg.MessageMediaDice:
+		return database.Media{Type: database.MediaDice, Title: m.Emoticon}
+
+	case *tg.MessageMediaStory:
+		return database.Media{Type: database.MediaStory}
+
+	case nil, *tg.MessageMediaEmpty:
+		return database.Media{}
+
+	default:
+		return database.Media{Type: database.MediaOther}
+	}
+}
+
+// convertDocument classifies a document by its attributes
+func convertDocument(doc *tg.Document) database.Media {
+	out := database.Media{
+		Type:     database.MediaDocument,
+		MimeType: doc.MimeType,
+		Size:     doc.Size,
+	}
+
+	for _, attr := range doc.Attributes {
+		switch a := attr.(type) {
+		case *tg.DocumentAttributeFilename:
+			out.FileName = a.FileName
+		case *tg.DocumentAttributeAudio:
+			out.Duration = a.Duration
+			if a.Voice {
+				out.Type = database.MediaVoice
+			} else {
+				out.Type = database.MediaAudio
+				out.Title = a.Title
+				if a.Performer != "" && a.Title != "" {
+					out.Title = a.Performer + " - " + a.Title
+				} else if a.Performer != "" {
+					out.Title = a.Performer
+				}
+			}
+		case *tg.DocumentAttributeVideo:
+			out.Duration = int(a.Duration)
+			if out.Type == database.MediaDocument {
+				out.Type = database.MediaVideo
+			}
+			if a.RoundMessage {
+				out.Type = database.MediaVideoNote
+			}
+		case *tg.DocumentAttributeAnimated:
+			out.Type = database.MediaAnimation
+		case *tg.DocumentAttributeSticker:
+			out.Type = database.MediaSticker
+			out.Title = a.Alt
+		}
+	}
+	return out
+}
+
+// photoSize returns the size in bytes of the largest version of a photo
+func photoSize(sizes []tg.PhotoSizeClass) int64 {
+	var largest int
+	for _, size := range sizes {
+		switch s := size.(type) {
+		case *tg.PhotoSize:
+			largest = max(largest, s.Size)
+		case *tg.PhotoSizeProgressive:
+			for _, n := range s.Sizes {
+				largest = max(largest, n)
+			}
+		}
+	}
+	return int64(largest)
+}
+
+func setGeo(out *database.Media, geo tg.GeoPointClass) {
+	if point, ok := geo.(*tg.GeoPoint); ok {
+		out.Latitude = point.Lat
+		out.Longitude = point.Long
+	}
+}
@@ -0,0 +1,80 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gotd/td/constant"
+	"github.com/gotd/td/tg"
+
+	"telemonitor/internal/userbot"
+)
+
+// peerCache remembers the channel access hashes of each account. They never
+// change for an account, so the dialogs are only reloaded when a channel is
+// missing.
+type peerCache struct {
+	mu        sync.Mutex
+	byAccount map[string]map[int64]userbot.ChannelPeer
+}
+
+func newPeerCache() *peerCache {
+	return &peerCache{byAccount: make(map[string]map[int64]userbot.ChannelPeer)}
+}
+
+// channel finds a channel by its Bot API style chat ID
+func (c *peerCache) channel(ctx context.Context, client *userbot.Client, chatID int64) (userbot.ChannelPeer, error) {
+	channelID := constant.TDLibPeerID(chatID).ToPlain()
+
+	c.mu.Lock()
+	peer, ok := c.byAccount[client.Name()][channelID]
+	c.mu.Unlock()
+	if ok {
+		return peer, nil
+	}
+
+	channels, err := client.Channels(ctx)
+	if err != nil {
+		return userbot.ChannelPeer{}, err
+	}
+	c.mu.Lock()
+	c.byAccount[client.Name()] = channels
+	c.mu.Unlock()
+
+	peer, ok = channels[channelID]
+	if !ok {
+		return userbot.ChannelPeer{}, fmt.Errorf("account %s is not a member", client.Name())
+	}
+	return peer, nil
+}
+
+// setPts records the pts a channel was caught up to, so a later lookup does
+// not start from the one seen when the dialogs were loaded
+func (c *peerCache) setPts(client *userbot.Client, chatID int64, pts int) {
+	channelID := constant.TDLibPeerID(chatID).ToPlain()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if peer, ok := c.byAccount[client.Name()][channelID]; ok {
+		peer.Pts = pts
+		c.byAccount[client.Name()][channelID] = peer
+	}
+}
+
+// inputPeer returns the peer to read a channel or basic group with
+func (c *peerCache) inputPeer(ctx context.Context, client *userbot.Client, chatID int64) (tg.InputPeerClass, error) {
+	id := constant.TDLibPeerID(chatID)
+	switch {
+	case id.IsChannel():
+		peer, err := c.channel(ctx, client, chatID)
+		if err != nil {
+			return nil, err
+		}
+		return &tg.InputPeerChannel{ChannelID: peer.Input.ChannelID, AccessHash: peer.Input.AccessHash}, nil
+	case id.IsChat():
+		return &tg.InputPeerChat{ChatID: id.ToPlain()}, nil
+	default:
+		return nil, fmt.Errorf("chat %d is not a group or channel", chatID)
+	}
+}
@@ -0,0 +1,679 @@
+package ingestion
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/lib/pq"
+
+	"telemonitor/internal/config"
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+)
+
+const (
+	// chatRefreshInterval is how often the set of active chats is reloaded
+	chatRefreshInterval = 30 * time.Second
+	// drainTimeout bounds how long queued messages are written on shutdown
+	drainTimeout = 10 * time.Second
+	// flushEvery is the number of processed items after which a busy worker
+	// commits chat state even though its queue is not empty
+	flushEvery = 100
+
+	minRetryDelay = time.Second
+	maxRetryDelay = 30 * time.Second
+	// gapRetryInterval is how long a chat waits for a requested catch-up to
+	// commit before a new gap requests another one
+	gapRetryInterval = 5 * time.Minute
+)
+
+// Reactor checks messages against the alert triggers. React sees new
+// messages once they are stored, skipping duplicates, and edited ones after
+// the edit is recorded; ReactDeleted sees messages once they are marked
+// deleted, with replayed set for deletions recovered by catch-up.
+type Reactor interface {
+	React(ctx context.Context, msg *database.RawMessage) error
+	ReactDeleted(ctx context.Context, msg *database.RawMessage, replayed bool) error
+}
+
+// Stats is a snapshot of the pipeline counters
+type Stats struct {
+	Queued   int
+	Capacity int
+	Stored   int64
+	Skipped  int64
+	Edited   int64
+	Deleted  int64
+	Dropped  int64
+}
+
+// messageStore is the part of RawMessageRepository the workers write to
+type messageStore interface {
+	Create(msg *database.RawMessage) error
+	RecordEdit(msg *database.RawMessage, editedAt time.Time) (bool, error)
+	MarkDeleted(chatID int64, msgIDs []int, at time.Time) ([]*database.RawMessage, error)
+}
+
+// itemKind is what an item does to raw_messages
+type itemKind int
+
+const (
+	itemNew itemKind = iota
+	itemEdit
+	itemDelete
+)
+
+// item is a unit of work for a worker: a new or edited message with its
+// sender, a deletion or a forum topic title, optionally with the channel pts
+// it was delivered with, or a bare pts commit
+type item struct {
+	kind     itemKind
+	chatID   int64
+	msg      *database.RawMessage
+	sender   *database.Sender
+	topic    *database.ForumTopic
+	pts      int
+	ptsCount int
+	// deleted and at are the message IDs and time of a deletion; replayed
+	// is set when catch-up found it, so at is only when it was noticed
+	deleted  []int
+	at       time.Time
+	replayed bool
+	// commit marks a pts reached by catch-up once everything queued before
+	// it for the chat is stored
+	commit bool
+}
+
+// chatState is what a worker has stored for a chat
+type chatState struct {
+	msgID int
+	pts   int
+	dirty bool
+	// stalled is set once a write was abandoned on shutdown; the state must
+	// not move past it
+	stalled bool
+	// gapAt is when catch-up was requested for the chat; it is cleared by
+	// the commit that ends the catch-up
+	gapAt time.Time
+}
+
+// Pipeline moves new, edited and deleted messages from the userbot into
+// raw_messages. Updates are sharded by chat onto a fixed set of workers, so
+// each chat is written in the order it was received. Every worker owns a
+// bounded queue; when a queue is full the update handler blocks, which
+// stalls the userbot instead of dropping messages or buffering without
+// limit.
+//
+// The last stored message ID and channel pts are committed to
+// monitored_chats only after the rows they cover are written. A channel
+// update whose pts does not follow the committed one is still stored, but
+// leaves the pts alone and reports a gap so the chat can be caught up.
+type Pipeline struct {
+	chats    *repository.MonitoredChatRepository
+	messages messageStore
+	topics   *repository.ForumTopicRepository
+	reactor  Reactor
+	onGap    func(chatID int64)
+	// transcription receives stored voice messages, if set
+	transcription *Transcription
+	// senders records who wrote each message, if set
+	senders *Senders
+
+	queues []chan item
+	// retryDelay is the first backoff of a failed write
+	retryDelay time.Duration
+
+	mu sync.RWMutex
+	// active maps the active chats to their committed pts
+	active map[int64]int
+
+	stored  atomic.Int64
+	skipped atomic.Int64
+	edited  atomic.Int64
+	deleted atomic.Int64
+	dropped atomic.Int64
+}
+
+// NewPipeline creates a pipeline. reactor may be nil.
+func NewPipeline(cfg config.IngestionConfig, chats *repository.MonitoredChatRepository, messages *repository.RawMessageRepository, topics *repository.ForumTopicRepository, reactor Reactor) *Pipeline {
+	p := &Pipeline{
+		chats:    chats,
+		messages: messages,
+		topics:   topics,
+		reactor:  reactor,
+		queues:   make([]chan item, cfg.Workers),
+		active:   make(map[int64]int),
+
+		retryDelay: minRetryDelay,
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan item, cfg.QueueSize/cfg.Workers)
+	}
+	return p
+}
+
+// SetGapHandler sets the function called when a channel update skips pts.
+// It must be called before Run.
+func (p *Pipeline) SetGapHandler(onGap func(chatID int64)) {
+	p.onGap = onGap
+}
+
+// SetTranscription makes the pipeline queue new voice messages and video
+// notes for transcription. It must be called before Run.
+func (p *Pipeline) SetTranscription(t *Transcription) {
+	p.transcription = t
+}
+
+// SetSenders makes the pipeline keep the senders directory up to date. It
+// must be called before Run.
+func (p *Pipeline) SetSenders(s *Senders) {
+	p.senders = s
+}
+
+// Run starts the workers and blocks until ctx is cancelled. Messages still
+// queued at shutdown are written before it returns. Call Refresh first so
+// messages arriving before the first periodic refresh are not ignored.
+func (p *Pipeline) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, queue := range p.queues {
+		wg.Add(1)
+		go func(queue chan item) {
+			defer wg.Done()
+			p.work(ctx, queue)
+		}(queue)
+	}
+
+	ticker := time.NewTicker(chatRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case <-ticker.C:
+			if err := p.Refresh(); err != nil {
+				log.Printf("Failed to refresh monitored chats: %v", err)
+			}
+		}
+	}
+}
+
+// Refresh reloads the set of active monitored chats. Call it after chats are
+// added or removed to apply the change immediately.
+func (p *Pipeline) Refresh() error {
+	chats, err := p.chats.GetActive()
+	if err != nil {
+		return err
+	}
+
+	active := make(map[int64]int, len(chats))
+	for _, chat := range chats {
+		active[chat.ChatID] = chat.LastPts
+	}
+
+	p.mu.Lock()
+	p.active = active
+	p.mu.Unlock()
+	return nil
+}
+
+// OnNewMessage handles messages from private chats and basic groups
+func (p *Pipeline) OnNewMessage(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error {
+	return p.Submit(ctx, e, u.Message)
+}
+
+// OnNewChannelMessage handles messages from channels and supergroups
+func (p *Pipeline) OnNewChannelMessage(ctx context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error {
+	if empty, ok := u.Message.(*tg.MessageEmpty); ok {
+		// Nothing to store, but the update still moves the channel's pts
+		peer, ok := empty.GetPeerID()
+		if !ok || !p.isActive(ChatID(peer)) {
+			return nil
+		}
+		return p.enqueue(ctx, item{chatID: ChatID(peer), pts: u.Pts, ptsCount: u.PtsCount})
+	}
+	m, ok := u.Message.AsNotEmpty()
+	if !ok {
+		return nil
+	}
+	chatID := ChatID(m.GetPeerID())
+	if !p.isActive(chatID) {
+		return nil
+	}
+
+	topic, _ := Topic(u.Message)
+	return p.enqueue(ctx, item{
+		chatID:   chatID,
+		msg:      Convert(e, u.Message),
+		sender:   Sender(e, u.Message),
+		topic:    topic,
+		pts:      u.Pts,
+		ptsCount: u.PtsCount,
+	})
+}
+
+// OnEditMessage handles edits in private chats and basic groups
+func (p *Pipeline) OnEditMessage(ctx context.Context, e tg.Entities, u *tg.UpdateEditMessage) error {
+	return p.SubmitEdit(ctx, e, u.Message)
+}
+
+// OnEditChannelMessage handles edits in channels and supergroups
+func (p *Pipeline) OnEditChannelMessage(ctx context.Context, e tg.Entities, u *tg.UpdateEditChannelMessage) error {
+	m, ok := u.Message.AsNotEmpty()
+	if !ok {
+		return nil
+	}
+	chatID := ChatID(m.GetPeerID())
+	if !p.isActive(chatID) {
+		return nil
+	}
+
+	return p.enqueue(ctx, item{
+		kind:     itemEdit,
+		chatID:   chatID,
+		msg:      Convert(e, u.Message),
+		sender:   Sender(e, u.Message),
+		pts:      u.Pts,
+		ptsCount: u.PtsCount,
+	})
+}
+
+// OnDeleteChannelMessages handles deletions in channels and supergroups
+func (p *Pipeline) OnDeleteChannelMessages(ctx context.Context, e tg.Entities, u *tg.UpdateDeleteChannelMessages) error {
+	chatID := ChatID(&tg.PeerChannel{ChannelID: u.ChannelID})
+	if !p.isActive(chatID) {
+		return nil
+	}
+
+	return p.enqueue(ctx, item{
+		kind:     itemDelete,
+		chatID:   chatID,
+		deleted:  u.Messages,
+		at:       time.Now().UTC(),
+		pts:      u.Pts,
+		ptsCount: u.PtsCount,
+	})
+}
+
+// Submit queues a message from a monitored chat, blocking while the queue of
+// its worker is full. Messages from other chats are ignored.
+func (p *Pipeline) Submit(ctx context.Context, e tg.Entities, m tg.MessageClass) error {
+	if topic, ok := Topic(m); ok {
+		return p.SubmitTopic(ctx, topic)
+	}
+	msg := Convert(e, m)
+	if msg == nil || !p.isActive(msg.ChatID) {
+		return nil
+	}
+	return p.enqueue(ctx, item{chatID: msg.ChatID, msg: msg, sender: Sender(e, m)})
+}
+
+// SubmitTopic queues the title of a forum topic in a monitored chat
+func (p *Pipeline) SubmitTopic(ctx context.Context, topic *database.ForumTopic) error {
+	if !p.isActive(topic.ChatID) {
+		return nil
+	}
+	return p.enqueue(ctx, item{chatID: topic.ChatID, topic: topic})
+}
+
+// SubmitEdit queues the new version of a message like Submit
+func (p *Pipeline) SubmitEdit(ctx context.Context, e tg.Entities, m tg.MessageClass) error {
+	msg := Convert(e, m)
+	if msg == nil || !p.isActive(msg.ChatID) {
+		return nil
+	}
+	return p.enqueue(ctx, item{kind: itemEdit, chatID: msg.ChatID, msg: msg, sender: Sender(e, m)})
+}
+
+// SubmitDelete queues a deletion replayed by catch-up from a monitored chat.
+// The deletion time recorded is the time of the call, as Telegram does not
+// send one, so it may be long after the message was actually deleted.
+func (p *Pipeline) SubmitDelete(ctx context.Context, chatID int64, msgIDs []int) error {
+	if !p.isActive(chatID) {
+		return nil
+	}
+	return p.enqueue(ctx, item{kind: itemDelete, chatID: chatID, deleted: msgIDs, at: time.Now().UTC(), replayed: true})
+}
+
+// Commit records pts as the chat's position once every message submitted
+// for it before the call has been stored
+func (p *Pipeline) Commit(ctx context.Context, chatID int64, pts int) error {
+	return p.enqueue(ctx, item{chatID: chatID, pts: pts, commit: true})
+}
+
+// Stats returns the current queue depth and counters
+func (p *Pipeline) Stats() Stats {
+	s := Stats{
+		Stored:  p.stored.Load(),
+		Skipped: p.skipped.Load(),
+		Edited:  p.edited.Load(),
+		Deleted: p.deleted.Load(),
+		Dropped: p.dropped.Load(),
+	}
+	for _, queue := range p.queues {
+		s.Queued += len(queue)
+		s.Capacity += cap(queue)
+	}
+	return s
+}
+
+func (p *Pipeline) isActive(chatID int64) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.active[chatID]
+	return ok
+}
+
+// committedPts returns the pts stored for a chat when it was last refreshed
+func (p *Pipeline) committedPts(chatID int64) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.active[chatID]
+}
+
+func (p *Pipeline) enqueue(ctx context.Context, it item) error {
+	select {
+	case p.queue(it.chatID) <- it:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pipeline) queue(chatID int64) chan item {
+	if chatID < 0 {
+		chatID = -chatID
+	}
+	return p.queues[chatID%int64(len(p.queues))]
+}
+
+// work processes one queue until ctx is cancelled, then drains it
+func (p *Pipeline) work(ctx context.Context, queue chan item) {
+	state := make(map[int64]*chatState)
+	processed := 0
+
+	for {
+		select {
+		case it := <-queue:
+			p.handle(ctx, state, it)
+			processed++
+			if len(queue) == 0 || processed >= flushEvery {
+				p.flush(state)
+				processed = 0
+			}
+		case <-ctx.Done():
+			drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			defer cancel()
+			for {
+				select {
+				case it := <-queue:
+					p.handle(drainCtx, state, it)
+				default:
+					p.flush(state)
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle applies one item and advances the chat state it covers
+func (p *Pipeline) handle(ctx context.Context, state map[int64]*chatState, it item) {
+	st, ok := state[it.chatID]
+	if !ok {
+		st = &chatState{pts: p.committedPts(it.chatID)}
+		state[it.chatID] = st
+	}
+
+	if !p.apply(ctx, it) {
+		st.stalled = true
+	}
+	if st.stalled {
+		return
+	}
+	if it.kind == itemNew && it.msg != nil && it.msg.TelegramMsgID > st.msgID {
+		st.msgID = it.msg.TelegramMsgID
+		st.dirty = true
+	}
+
+	switch {
+	case it.pts == 0:
+	case it.commit:
+		st.gapAt = time.Time{}
+		if it.pts > st.pts {
+			st.pts = it.pts
+			st.dirty = true
+		}
+	case st.pts == 0:
+		// Never synced; catch-up commits the first pts once the chat's
+		// account is ready
+	case it.pts <= st.pts:
+		// Already covered by catch-up
+	case it.pts-it.ptsCount == st.pts:
+		st.pts = it.pts
+		st.dirty = true
+	default:
+		if p.gap(st, it.chatID) {
+			log.Printf("pts gap in chat %d: have %d, got %d-%d", it.chatID, st.pts, it.pts-it.ptsCount, it.pts)
+		}
+	}
+}
+
+// gap hands a chat to catch-up unless that was already requested and may
+// still be running. It reports whether catch-up was requested.
+func (p *Pipeline) gap(st *chatState, chatID int64) bool {
+	if !st.gapAt.IsZero() && time.Since(st.gapAt) < gapRetryInterval {
+		return false
+	}
+	st.gapAt = time.Now()
+	if p.onGap != nil {
+		go p.onGap(chatID)
+	}
+	return true
+}
+
+// flush commits the state of every chat that changed since the last flush
+func (p *Pipeline) flush(state map[int64]*chatState) {
+	for chatID, st := range state {
+		if !st.dirty {
+			continue
+		}
+		if err := p.chats.AdvanceState(chatID, st.msgID, st.pts); err != nil {
+			log.Printf("Failed to commit state of chat %d: %v", chatID, err)
+			continue
+		}
+		st.dirty = false
+	}
+}
+
+// apply writes an item to raw_messages. It returns false if ctx was
+// cancelled before the write succeeded.
+func (p *Pipeline) apply(ctx context.Context, it item) bool {
+	if it.topic != nil {
+		what := fmt.Sprintf("save topic %d of chat %d", it.topic.TopicID, it.chatID)
+		if p.retry(ctx, what, func() error { return p.topics.Save(it.topic) }) == stalled {
+			return false
+		}
+	}
+
+	if it.sender != nil && it.msg != nil && p.senders != nil {
+		// The directory is best effort; a failure must not hold up messages
+		if err := p.senders.Observe(it.sender, it.msg.CreatedAt); err != nil {
+			log.Printf("Failed to record sender %d: %v", it.sender.UserID, err)
+		}
+	}
+
+	switch {
+	case it.kind == itemDelete:
+		return p.delete(ctx, it.chatID, it.deleted, it.at, it.replayed)
+	case it.msg == nil:
+		return true
+	case it.kind == itemEdit:
+		return p.edit(ctx, it.msg)
+	default:
+		return p.store(ctx, it.msg)
+	}
+}
+
+// store writes the message and runs the reactor on it if it is new, so a
+// message delivered twice alerts once
+func (p *Pipeline) store(ctx context.Context, msg *database.RawMessage) bool {
+	what := fmt.Sprintf("store message %d in chat %d", msg.TelegramMsgID, msg.ChatID)
+	switch p.retry(ctx, what, func() error { return p.messages.Create(msg) }) {
+	case stalled:
+		return false
+	case dropped:
+		return true
+	}
+
+	if msg.ID == 0 {
+		// Already stored, e.g. delivered to two accounts
+		p.skipped.Add(1)
+		return true
+	}
+	p.stored.Add(1)
+	if p.reactor != nil {
+		if err := p.reactor.React(ctx, msg); err != nil {
+			log.Printf("Reactor failed on message %d in chat %d: %v", msg.TelegramMsgID, msg.ChatID, err)
+		}
+	}
+	if p.transcription != nil {
+		p.transcription.Enqueue(msg)
+	}
+	return true
+}
+
+// edit records the new text of a message and runs the reactor on it if it
+// changed
+func (p *Pipeline) edit(ctx context.Context, msg *database.RawMessage) bool {
+	editedAt := msg.EditedAt.Time
+	if !msg.EditedAt.Valid {
+		editedAt = time.Now().UTC()
+	}
+
+	var changed bool
+	what := fmt.Sprintf("record edit of message %d in chat %d", msg.TelegramMsgID, msg.ChatID)
+	switch p.retry(ctx, what, func() (err error) {
+		changed, err = p.messages.RecordEdit(msg, editedAt)
+		return err
+	}) {
+	case stalled:
+		return false
+	case dropped:
+		return true
+	}
+	if !changed {
+		return true
+	}
+
+	p.edited.Add(1)
+	if p.reactor != nil {
+		if err := p.reactor.React(ctx, msg); err != nil {
+			log.Printf("Reactor failed on edit of message %d in chat %d: %v", msg.TelegramMsgID, msg.ChatID, err)
+		}
+	}
+	return true
+}
+
+// delete marks messages deleted and runs the deletion triggers on the ones
+// that were stored
+func (p *Pipeline) delete(ctx context.Context, chatID int64, msgIDs []int, at time.Time, replayed bool) bool {
+	var deleted []*database.RawMessage
+	what := fmt.Sprintf("mark %d message(s) deleted in chat %d", len(msgIDs), chatID)
+	switch p.retry(ctx, what, func() (err error) {
+		deleted, err = p.messages.MarkDeleted(chatID, msgIDs, at)
+		return err
+	}) {
+	case stalled:
+		return false
+	case dropped:
+		return true
+	}
+
+	p.deleted.Add(int64(len(deleted)))
+	if p.reactor != nil {
+		for _, msg := range deleted {
+			if err := p.reactor.ReactDeleted(ctx, msg, replayed); err != nil {
+				log.Printf("Reactor failed on deletion of message %d in chat %d: %v", msg.TelegramMsgID, chatID, err)
+			}
+		}
+	}
+	return true
+}
+
+// outcome is how retry left a write
+type outcome int
+
+const (
+	// written means the write succeeded
+	written outcome = iota
+	// dropped means the database rejected the item and it is skipped
+	dropped
+	// stalled means the write was abandoned on shutdown, so the chat state
+	// must not move past it
+	stalled
+)
+
+// retry calls write until it succeeds, backing off between attempts up to
+// maxRetryDelay. While it waits the worker takes nothing from its queue, so
+// a database that is down or too slow pauses intake instead of losing
+// messages. Data errors cannot go away by trying again, so such an item is
+// logged and dropped. An item still failing on shutdown stalls its chat,
+// leaving it to catch-up after a restart.
+func (p *Pipeline) retry(ctx context.Context, what string, write func() error) outcome {
+	delay := p.retryDelay
+	for {
+		err := write()
+		if err == nil {
+			return written
+		}
+		if !retryable(err) {
+			log.Printf("Failed to %s, dropping it: %v", what, err)
+			p.dropped.Add(1)
+			return dropped
+		}
+		log.Printf("Failed to %s: %v; retrying in %s", what, err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			log.Printf("Gave up trying to %s on shutdown", what)
+			return stalled
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// retryable reports whether a later attempt may succeed where err failed.
+// Only data exceptions (class 22) and integrity constraint violations
+// (class 23) are about the row itself; every other database error, e.g. a
+// lost connection, a serialization failure, a statement timeout (57014) or
+// insufficient resources (class 53), depends on the state of the database.
+// Errors that never reached it, like a value that cannot be encoded, are
+// data errors too, except for network failures.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := pqErr.Code.Class()
+		return class != "22" && class != "23"
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &netErr)
+}
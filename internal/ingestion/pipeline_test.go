@@ -0,0 +1,229 @@
+package ingestion
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/lib/pq"
+
+	"telemonitor/internal/config"
+	"telemonitor/internal/database"
+)
+
+// messagesStandIn is a message store whose writes fail with err, all of
+// them or only the first failures
+type messagesStandIn struct {
+	err      error
+	failures int
+	calls    int
+}
+
+// result counts a write and returns its error
+func (m *messagesStandIn) result() error {
+	m.calls++
+	if m.failures > 0 && m.calls > m.failures {
+		return nil
+	}
+	return m.err
+}
+
+func (m *messagesStandIn) Create(msg *database.RawMessage) error {
+	return m.result()
+}
+
+func (m *messagesStandIn) RecordEdit(msg *database.RawMessage, editedAt time.Time) (bool, error) {
+	err := m.result()
+	return err == nil, err
+}
+
+func (m *messagesStandIn) MarkDeleted(chatID int64, msgIDs []int, at time.Time) ([]*database.RawMessage, error) {
+	if err := m.result(); err != nil {
+		return nil, err
+	}
+	var deleted []*database.RawMessage
+	for _, id := range msgIDs {
+		deleted = append(deleted, &database.RawMessage{ChatID: chatID, TelegramMsgID: id, DeletedAt: sql.NullTime{Time: at, Valid: true}})
+	}
+	return deleted, nil
+}
+
+func newFailingPipeline(err error) (*Pipeline, *messagesStandIn, *reactorStandIn) {
+	messages := &messagesStandIn{err: err}
+	reactor := &reactorStandIn{}
+	p := NewPipeline(config.IngestionConfig{Workers: 1, QueueSize: 1}, nil, nil, nil, reactor)
+	p.messages = messages
+	p.retryDelay = time.Millisecond
+	return p, messages, reactor
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{&pq.Error{Code: "57014"}, true},
+		{&pq.Error{Code: "53100"}, true},
+		{&pq.Error{Code: "53300"}, true},
+		{fmt.Errorf("failed to create message: %w", &pq.Error{Code: "08003"}), true},
+		{&pq.Error{Code: "23503"}, false},
+		{&pq.Error{Code: "22P02"}, false},
+		{errors.New("invalid media"), false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestPipelineDropsPermanentFailure(t *testing.T) {
+	// foreign_key_violation: the chat row is gone, trying again cannot help
+	p, messages, reactor := newFailingPipeline(fmt.Errorf("failed to create message: %w", &pq.Error{Code: "23503"}))
+	state := make(map[int64]*chatState)
+	msg := &database.RawMessage{ChatID: -100, TelegramMsgID: 7}
+
+	p.handle(context.Background(), state, item{chatID: -100, msg: msg})
+	if messages.calls != 1 {
+		t.Errorf("write tried %d times, want 1", messages.calls)
+	}
+	if st := state[-100]; st.stalled || st.msgID != 7 {
+		t.Errorf("state = %+v, want the chat to move past the dropped message", *st)
+	}
+	if len(reactor.reacted) != 0 {
+		t.Errorf("reactor ran on a dropped message")
+	}
+	if s := p.Stats(); s.Dropped != 1 || s.Stored != 0 {
+		t.Errorf("stats = %+v, want 1 dropped", s)
+	}
+}
+
+func TestPipelineRetriesUntilWritten(t *testing.T) {
+	// query_canceled by statement_timeout: the database is slow, not the row
+	p, messages, _ := newFailingPipeline(fmt.Errorf("failed to mark messages deleted: %w", &pq.Error{Code: "57014"}))
+	messages.failures = 5
+	state := make(map[int64]*chatState)
+	state[-100] = &chatState{pts: 4}
+
+	p.handle(context.Background(), state, item{kind: itemDelete, chatID: -100, deleted: []int{1}, pts: 5, ptsCount: 1})
+	if messages.calls != 6 {
+		t.Errorf("write tried %d times, want 6", messages.calls)
+	}
+	if st := state[-100]; st.stalled || st.pts != 5 || !st.dirty {
+		t.Errorf("state = %+v, want the chat moved past the written item", *st)
+	}
+	if s := p.Stats(); s.Dropped != 0 {
+		t.Errorf("dropped %d items that were written in the end", s.Dropped)
+	}
+}
+
+func TestPipelineStallsOnShutdown(t *testing.T) {
+	p, messages, _ := newFailingPipeline(fmt.Errorf("failed to record edit: %w", &pq.Error{Code: "08006"}))
+	p.retryDelay = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	state := make(map[int64]*chatState)
+
+	p.handle(ctx, state, item{kind: itemEdit, chatID: -100, msg: &database.RawMessage{ChatID: -100, TelegramMsgID: 7}})
+	if messages.calls != 1 {
+		t.Errorf("write tried %d times after shutdown, want 1", messages.calls)
+	}
+	if !state[-100].stalled {
+		t.Errorf("chat not stalled by a write abandoned on shutdown")
+	}
+
+	// Items drained after it must not move the state past it
+	messages.err = nil
+	p.handle(context.Background(), state, item{chatID: -100, msg: &database.RawMessage{ChatID: -100, TelegramMsgID: 8}})
+	if st := state[-100]; !st.stalled || st.msgID != 0 {
+		t.Errorf("state = %+v, want the chat kept before the abandoned write", *st)
+	}
+}
+
+func TestEmptyChannelMessageMovesPts(t *testing.T) {
+	p := NewPipeline(config.IngestionConfig{Workers: 1, QueueSize: 1}, nil, nil, nil, nil)
+	peer := &tg.PeerChannel{ChannelID: 1234}
+	chatID := ChatID(peer)
+	p.active[chatID] = 4
+
+	empty := &tg.MessageEmpty{ID: 9}
+	empty.SetPeerID(peer)
+	ctx := context.Background()
+	if err := p.OnNewChannelMessage(ctx, tg.Entities{}, &tg.UpdateNewChannelMessage{Message: empty, Pts: 5, PtsCount: 1}); err != nil {
+		t.Fatalf("OnNewChannelMessage: %v", err)
+	}
+
+	state := make(map[int64]*chatState)
+	p.handle(ctx, state, <-p.queues[0])
+	if st := state[chatID]; st.pts != 5 || !st.dirty || st.msgID != 0 {
+		t.Errorf("state = %+v, want pts moved to 5 without a message", *st)
+	}
+}
+
+func TestPipelineRequestsCatchupOncePerGap(t *testing.T) {
+	p := NewPipeline(config.IngestionConfig{Workers: 1, QueueSize: 1}, nil, nil, nil, nil)
+	gaps := make(chan int64, 8)
+	p.SetGapHandler(func(chatID int64) { gaps <- chatID })
+	state := map[int64]*chatState{-100: {pts: 4}, -200: {}}
+	ctx := context.Background()
+
+	// Two updates after the same gap request one catch-up
+	p.handle(ctx, state, item{chatID: -100, pts: 7, ptsCount: 1})
+	p.handle(ctx, state, item{chatID: -100, pts: 8, ptsCount: 1})
+	// A chat that never synced has no pts to be behind of
+	p.handle(ctx, state, item{chatID: -200, pts: 3, ptsCount: 1})
+	if st := state[-200]; st.pts != 0 || !st.gapAt.IsZero() {
+		t.Errorf("state = %+v, want a never synced chat left to its first catch-up", *st)
+	}
+
+	// The catch-up commit lets the next gap request another one
+	p.handle(ctx, state, item{chatID: -100, pts: 8, commit: true})
+	p.handle(ctx, state, item{chatID: -100, pts: 11, ptsCount: 1})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case chatID := <-gaps:
+			if chatID != -100 {
+				t.Errorf("caught up chat %d, want -100", chatID)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("got %d catch-up request(s), want 2", i)
+		}
+	}
+	select {
+	case chatID := <-gaps:
+		t.Errorf("extra catch-up request for chat %d", chatID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReplayedDeletionReachesReactor(t *testing.T) {
+	p, _, reactor := newFailingPipeline(nil)
+	peer := &tg.PeerChannel{ChannelID: 1234}
+	chatID := ChatID(peer)
+	p.active[chatID] = 0
+	ctx := context.Background()
+	state := make(map[int64]*chatState)
+
+	if err := p.OnDeleteChannelMessages(ctx, tg.Entities{}, &tg.UpdateDeleteChannelMessages{ChannelID: 1234, Messages: []int{3}}); err != nil {
+		t.Fatalf("OnDeleteChannelMessages: %v", err)
+	}
+	p.handle(ctx, state, <-p.queues[0])
+	// Catch-up only notices the deletion, the time it happened is unknown
+	if err := p.SubmitDelete(ctx, chatID, []int{4}); err != nil {
+		t.Fatalf("SubmitDelete: %v", err)
+	}
+	p.handle(ctx, state, <-p.queues[0])
+
+	if fmt.Sprint(reactor.deleted) != "[false true]" {
+		t.Errorf("deletions replayed = %v, want [false true]", reactor.deleted)
+	}
+}
@@ -0,0 +1,129 @@
+package ingestion
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+)
+
+const (
+	// senderTouchInterval is how far last_seen may lag behind for a sender
+	// whose name did not change
+	senderTouchInterval = time.Hour
+	// maxCachedSenders bounds the senders remembered between writes
+	maxCachedSenders = 10000
+)
+
+// cachedSender is what was last written for a sender
+type cachedSender struct {
+	sender    *database.Sender
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// Senders keeps the senders directory up to date from the entities that
+// arrive with messages. A sender is written when first seen, when its name
+// or flags change, and at most once per senderTouchInterval otherwise.
+type Senders struct {
+	repo *repository.SenderRepository
+
+	mu    sync.Mutex
+	cache map[int64]cachedSender
+}
+
+// NewSenders creates a Senders
+func NewSenders(repo *repository.SenderRepository) *Senders {
+	return &Senders{
+		repo:  repo,
+		cache: make(map[int64]cachedSender),
+	}
+}
+
+// Observe records that sender wrote a message at seenAt
+func (s *Senders) Observe(sender *database.Sender, seenAt time.Time) error {
+	if !s.changed(sender, seenAt) {
+		return nil
+	}
+
+	renamed, err := s.repo.Save(sender, seenAt)
+	if err != nil {
+		return err
+	}
+	if renamed {
+		log.Printf("Sender %d is now %q (@%s)", sender.UserID, sender.DisplayName(), sender.Username.String)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cache[sender.UserID]
+	if !ok {
+		if len(s.cache) >= maxCachedSenders {
+			clear(s.cache)
+		}
+		c = cachedSender{firstSeen: seenAt, lastSeen: seenAt}
+	}
+	c.sender = sender
+	c.firstSeen = minTime(c.firstSeen, seenAt)
+	c.lastSeen = maxTime(c.lastSeen, seenAt)
+	s.cache[sender.UserID] = c
+	return nil
+}
+
+// changed reports whether a snapshot adds anything to what was last written
+func (s *Senders) changed(sender *database.Sender, seenAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cache[sender.UserID]
+	if !ok {
+		return true
+	}
+	return !c.sender.SameName(sender) ||
+		c.sender.IsBot != sender.IsBot ||
+		c.sender.IsPremium != sender.IsPremium ||
+		seenAt.Before(c.firstSeen) ||
+		seenAt.Sub(c.lastSeen) >= senderTouchInterval
+}
+
+// pageSenders collects the senders of one history page with the earliest
+// time each was seen, so a backfill writes a sender once per page instead of
+// once per message
+type pageSenders map[int64]pageSender
+
+type pageSender struct {
+	sender *database.Sender
+	seenAt time.Time
+}
+
+// add records that sender wrote a message at seenAt
+func (p pageSenders) add(sender *database.Sender, seenAt time.Time) {
+	if s, ok := p[sender.UserID]; ok && !seenAt.Before(s.seenAt) {
+		return
+	}
+	p[sender.UserID] = pageSender{sender: sender, seenAt: seenAt}
+}
+
+// observe hands every collected sender to s
+func (p pageSenders) observe(s *Senders) {
+	for _, ps := range p {
+		if err := s.Observe(ps.sender, ps.seenAt); err != nil {
+			log.Printf("Failed to record sender %d: %v", ps.sender.UserID, err)
+		}
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
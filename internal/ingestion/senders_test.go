@@ -0,0 +1,75 @@
+package ingestion
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"telemonitor/internal/database"
+)
+
+func TestSendersChanged(t *testing.T) {
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	alice := func(modify func(s *database.Sender)) *database.Sender {
+		s := &database.Sender{
+			UserID:    42,
+			Username:  sql.NullString{String: "alice", Valid: true},
+			FirstName: sql.NullString{String: "Alice", Valid: true},
+		}
+		if modify != nil {
+			modify(s)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name   string
+		sender *database.Sender
+		seenAt time.Time
+		want   bool
+	}{
+		{"unchanged", alice(nil), seen.Add(time.Minute), false},
+		{"seen earlier in the same interval", alice(nil), seen.Add(-time.Minute), false},
+		{"unknown sender", &database.Sender{UserID: 43}, seen, true},
+		{"new username", alice(func(s *database.Sender) { s.Username.String = "alice2" }), seen, true},
+		{"username removed", alice(func(s *database.Sender) { s.Username = sql.NullString{} }), seen, true},
+		{"new first name", alice(func(s *database.Sender) { s.FirstName.String = "Alicia" }), seen, true},
+		{"last name added", alice(func(s *database.Sender) { s.LastName = sql.NullString{String: "Smith", Valid: true} }), seen, true},
+		{"became premium", alice(func(s *database.Sender) { s.IsPremium = true }), seen, true},
+		{"became a bot", alice(func(s *database.Sender) { s.IsBot = true }), seen, true},
+		{"seen before first seen", alice(nil), seen.Add(-2 * time.Hour), true},
+		{"touch interval passed", alice(nil), seen.Add(senderTouchInterval), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSenders(nil)
+			s.cache[42] = cachedSender{sender: alice(nil), firstSeen: seen.Add(-time.Hour), lastSeen: seen}
+			if got := s.changed(tt.sender, tt.seenAt); got != tt.want {
+				t.Errorf("changed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageSendersKeepsEarliest(t *testing.T) {
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	alice := &database.Sender{UserID: 42}
+	bob := &database.Sender{UserID: 43}
+
+	// History pages arrive newest first
+	p := make(pageSenders)
+	p.add(alice, seen)
+	p.add(bob, seen.Add(-time.Minute))
+	p.add(alice, seen.Add(-2*time.Minute))
+	p.add(alice, seen.Add(-time.Minute))
+
+	if len(p) != 2 {
+		t.Fatalf("collected %d senders, want 2", len(p))
+	}
+	if got := p[42].seenAt; !got.Equal(seen.Add(-2 * time.Minute)) {
+		t.Errorf("alice seen at %v, want %v", got, seen.Add(-2*time.Minute))
+	}
+	if got := p[43].seenAt; !got.Equal(seen.Add(-time.Minute)) {
+		t.Errorf("bob seen at %v, want %v", got, seen.Add(-time.Minute))
+	}
+}
@@ -0,0 +1,223 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+
+	"telemonitor/internal/config"
+	"telemonitor/internal/database"
+	"telemonitor/internal/userbot"
+)
+
+const (
+	// transcriptionTimeout bounds the wait for updateTranscribedAudio
+	transcriptionTimeout = 2 * time.Minute
+	// maxEarlyResults bounds the results kept for transcriptions nobody
+	// waits for yet
+	maxEarlyResults = 100
+	// premiumRequiredBackoff is how long an account without Premium or a
+	// trial is not asked again
+	premiumRequiredBackoff = 24 * time.Hour
+)
+
+// ErrTranscriberUnavailable is returned when a backend cannot transcribe a
+// message now; the message stays queued
+var ErrTranscriberUnavailable = errors.New("transcriber is not available now")
+
+// RejectedError is returned when a message can never be transcribed, so it
+// is not retried
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return "transcription rejected: " + e.Reason
+}
+
+// TranscribeRequest is a voice message or video note to transcribe, with the
+// account that can read it
+type TranscribeRequest struct {
+	Client  *userbot.Client
+	Peer    tg.InputPeerClass
+	Message *database.RawMessage
+}
+
+// Transcriber turns the audio of a message into text
+type Transcriber interface {
+	Transcribe(ctx context.Context, req TranscribeRequest) (string, error)
+}
+
+// Transcribers tries each transcriber in turn until one is available
+type Transcribers []Transcriber
+
+// Transcribe implements Transcriber
+func (ts Transcribers) Transcribe(ctx context.Context, req TranscribeRequest) (string, error) {
+	for _, t := range ts {
+		text, err := t.Transcribe(ctx, req)
+		if !errors.Is(err, ErrTranscriberUnavailable) {
+			return text, err
+		}
+	}
+	return "", ErrTranscriberUnavailable
+}
+
+// NewTranscriber returns the backend selected by cfg. telegram must be
+// registered with the pool as its transcription handler.
+func NewTranscriber(cfg config.TranscriptionConfig, telegram *TelegramTranscriber) Transcriber {
+	switch cfg.Backend {
+	case config.TranscriptionWhisper:
+		return NewWhisperTranscriber(cfg.Whisper)
+	case config.TranscriptionAuto:
+		return Transcribers{telegram, NewWhisperTranscriber(cfg.Whisper)}
+	default:
+		return telegram
+	}
+}
+
+// TelegramTranscriber uses messages.transcribeAudio. Premium accounts can
+// always transcribe; others are used while their free trial lasts.
+type TelegramTranscriber struct {
+	mu sync.Mutex
+	// waiters and early pair pending transcription IDs with their results;
+	// early keeps results that arrived before the waiter was registered
+	waiters map[int64]chan string
+	early   map[int64]string
+	// unavailable maps accounts out of trial transcriptions to when they may
+	// try again
+	unavailable map[string]time.Time
+}
+
+// NewTelegramTranscriber creates a TelegramTranscriber
+func NewTelegramTranscriber() *TelegramTranscriber {
+	return &TelegramTranscriber{
+		waiters:     make(map[int64]chan string),
+		early:       make(map[int64]string),
+		unavailable: make(map[string]time.Time),
+	}
+}
+
+// Transcribe implements Transcriber
+func (t *TelegramTranscriber) Transcribe(ctx context.Context, req TranscribeRequest) (string, error) {
+	if !t.available(req.Client) {
+		return "", ErrTranscriberUnavailable
+	}
+
+	res, err := req.Client.API().MessagesTranscribeAudio(ctx, &tg.MessagesTranscribeAudioRequest{
+		Peer:  req.Peer,
+		MsgID: req.Message.TelegramMsgID,
+	})
+	if tgerr.Is(err, "PREMIUM_ACCOUNT_REQUIRED") {
+		t.setUnavailable(req.Client.Name(), time.Now().Add(premiumRequiredBackoff))
+		return "", ErrTranscriberUnavailable
+	}
+	if rpcErr, ok := tgerr.As(err); ok && !tgerr.IsCode(err, 420, 500) {
+		// Rejected for this message, e.g. MSG_VOICE_MISSING or
+		// TRANSCRIPTION_FAILED; retrying will not help
+		return "", &RejectedError{Reason: rpcErr.Type}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+	t.recordTrial(req.Client, res)
+
+	if !res.Pending {
+		return res.Text, nil
+	}
+	return t.wait(ctx, res.TranscriptionID)
+}
+
+// OnTranscribedAudio implements userbot.TranscriptionHandler
+func (t *TelegramTranscriber) OnTranscribedAudio(ctx context.Context, e tg.Entities, u *tg.UpdateTranscribedAudio) error {
+	if u.Pending {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if waiter, ok := t.waiters[u.TranscriptionID]; ok {
+		waiter <- u.Text
+		delete(t.waiters, u.TranscriptionID)
+	} else {
+		if len(t.early) >= maxEarlyResults {
+			// Results of transcriptions requested elsewhere, e.g. by the
+			// account owner in the app
+			clear(t.early)
+		}
+		t.early[u.TranscriptionID] = u.Text
+	}
+	return nil
+}
+
+// wait blocks until the transcription with id is delivered by an update
+func (t *TelegramTranscriber) wait(ctx context.Context, id int64) (string, error) {
+	t.mu.Lock()
+	if text, ok := t.early[id]; ok {
+		delete(t.early, id)
+		t.mu.Unlock()
+		return text, nil
+	}
+	waiter := make(chan string, 1)
+	t.waiters[id] = waiter
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.waiters, id)
+		t.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(transcriptionTimeout)
+	defer timer.Stop()
+	select {
+	case text := <-waiter:
+		return text, nil
+	case <-timer.C:
+		return "", fmt.Errorf("transcription %d did not finish in %s", id, transcriptionTimeout)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// available reports whether an account may transcribe: Premium accounts
+// always can, others until their trial runs out
+func (t *TelegramTranscriber) available(client *userbot.Client) bool {
+	if self := client.Self(); self != nil && self.Premium {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.unavailable[client.Name()]
+	if ok && time.Now().After(until) {
+		delete(t.unavailable, client.Name())
+		return true
+	}
+	return !ok
+}
+
+// recordTrial remembers when an account without Premium used up its trial
+func (t *TelegramTranscriber) recordTrial(client *userbot.Client, res *tg.MessagesTranscribedAudio) {
+	remains, ok := res.GetTrialRemainsNum()
+	if !ok || remains > 0 {
+		return
+	}
+	until := time.Now().Add(premiumRequiredBackoff)
+	if date, ok := res.GetTrialRemainsUntilDate(); ok {
+		until = time.Unix(int64(date), 0)
+	}
+	log.Printf("Account %s used up its free transcriptions until %s", client.Name(), until.Format(time.RFC3339))
+	t.setUnavailable(client.Name(), until)
+}
+
+func (t *TelegramTranscriber) setUnavailable(account string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.unavailable[account] = until
+}
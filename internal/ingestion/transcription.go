@@ -0,0 +1,187 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"telemonitor/internal/config"
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+	"telemonitor/internal/ratelimit"
+	"telemonitor/internal/userbot"
+)
+
+const (
+	// transcriptionQueueSize bounds the messages waiting in memory; the rest
+	// are picked up from the database by the next scan
+	transcriptionQueueSize = 100
+	// transcriptionScanInterval is how often the database is searched for
+	// messages that were not queued, e.g. before a restart
+	transcriptionScanInterval = 5 * time.Minute
+	// transcriptionMaxAge is how old a message may be to still be transcribed
+	transcriptionMaxAge = 24 * time.Hour
+)
+
+// transcriptStore is the part of RawMessageRepository the transcription
+// worker reads and writes
+type transcriptStore interface {
+	GetUntranscribed(since time.Time, limit int) ([]*database.RawMessage, error)
+	SaveTranscript(id int, transcript string) (*database.RawMessage, error)
+	FailTranscription(id int, reason string) error
+}
+
+// Transcription turns voice messages and video notes into text with the
+// configured Transcriber. Calls are paced by transcriptions_per_minute, and
+// the transcript is appended to the stored message before the reactor sees
+// it again.
+type Transcription struct {
+	transcriber Transcriber
+	messages    transcriptStore
+	chats       *repository.MonitoredChatRepository
+	pool        *userbot.Pool
+	reactor     Reactor
+	budget      *ratelimit.Budget
+	peers       *peerCache
+
+	queue chan *database.RawMessage
+
+	mu sync.Mutex
+	// queued holds the raw_messages IDs in queue
+	queued map[int]bool
+}
+
+// NewTranscription creates a transcription worker. reactor may be nil.
+func NewTranscription(cfg config.RateLimitingConfig, transcriber Transcriber, messages *repository.RawMessageRepository, chats *repository.MonitoredChatRepository, pool *userbot.Pool, reactor Reactor) *Transcription {
+	return &Transcription{
+		transcriber: transcriber,
+		messages:    messages,
+		chats:       chats,
+		pool:        pool,
+		reactor:     reactor,
+		budget:      ratelimit.NewBudget(cfg.TranscriptionsPerMinute),
+		peers:       newPeerCache(),
+		queue:       make(chan *database.RawMessage, transcriptionQueueSize),
+		queued:      make(map[int]bool),
+	}
+}
+
+// Wants reports whether a message should be transcribed
+func (t *Transcription) Wants(msg *database.RawMessage) bool {
+	return !msg.IsTranscribed && (msg.Media.Type == database.MediaVoice || msg.Media.Type == database.MediaVideoNote)
+}
+
+// Enqueue queues a stored message without blocking. When the queue is full
+// the message is left to the next database scan.
+func (t *Transcription) Enqueue(msg *database.RawMessage) {
+	if msg.ID == 0 || !t.Wants(msg) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.queued[msg.ID] {
+		return
+	}
+	select {
+	case t.queue <- msg:
+		t.queued[msg.ID] = true
+	default:
+	}
+}
+
+// Run transcribes queued messages one at a time until ctx is cancelled
+func (t *Transcription) Run(ctx context.Context) {
+	t.scan()
+	ticker := time.NewTicker(transcriptionScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.scan()
+		case msg := <-t.queue:
+			err := t.transcribe(ctx, msg)
+			t.mu.Lock()
+			delete(t.queued, msg.ID)
+			t.mu.Unlock()
+			if err != nil && !errors.Is(err, ErrTranscriberUnavailable) && ctx.Err() == nil {
+				log.Printf("Failed to transcribe message %d in chat %d: %v", msg.TelegramMsgID, msg.ChatID, err)
+			}
+		}
+	}
+}
+
+// scan queues the untranscribed messages found in the database
+func (t *Transcription) scan() {
+	messages, err := t.messages.GetUntranscribed(time.Now().Add(-transcriptionMaxAge), transcriptionQueueSize)
+	if err != nil {
+		log.Printf("Failed to load untranscribed messages: %v", err)
+		return
+	}
+	for _, msg := range messages {
+		t.Enqueue(msg)
+	}
+}
+
+// transcribe asks the configured backend for a transcript and stores it
+func (t *Transcription) transcribe(ctx context.Context, msg *database.RawMessage) error {
+	chat, err := t.chats.GetByChatID(msg.ChatID)
+	if err != nil {
+		return err
+	}
+	if chat == nil {
+		return ErrTranscriberUnavailable
+	}
+	client := t.pool.ClientForChat(chat)
+	if client == nil || !client.Authorized() {
+		return ErrTranscriberUnavailable
+	}
+
+	peer, err := t.peers.inputPeer(ctx, client, msg.ChatID)
+	if err != nil {
+		return err
+	}
+	if err := t.budget.Wait(ctx); err != nil {
+		return err
+	}
+
+	text, err := t.transcriber.Transcribe(ctx, TranscribeRequest{Client: client, Peer: peer, Message: msg})
+	return t.finish(ctx, msg, text, err)
+}
+
+// finish stores the outcome of a transcription: the transcript, or why the
+// message can never be transcribed. Other errors are returned and the
+// message is tried again by a later scan.
+func (t *Transcription) finish(ctx context.Context, msg *database.RawMessage, text string, err error) error {
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		return t.messages.FailTranscription(msg.ID, rejected.Reason)
+	}
+	if err != nil {
+		return err
+	}
+	return t.save(ctx, msg, text)
+}
+
+// save stores a transcript and runs the reactor on the new text
+func (t *Transcription) save(ctx context.Context, msg *database.RawMessage, text string) error {
+	if text == "" {
+		return t.messages.FailTranscription(msg.ID, "empty transcript")
+	}
+
+	updated, err := t.messages.SaveTranscript(msg.ID, text)
+	if err != nil || updated == nil {
+		return err
+	}
+	if t.reactor != nil {
+		if err := t.reactor.React(ctx, updated); err != nil {
+			log.Printf("Reactor failed on transcript of message %d in chat %d: %v", msg.TelegramMsgID, msg.ChatID, err)
+		}
+	}
+	return nil
+}
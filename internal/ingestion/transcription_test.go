@@ -0,0 +1,163 @@
+package ingestion
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"telemonitor/internal/config"
+	"telemonitor/internal/database"
+)
+
+// reactorStandIn records the messages it is shown
+type reactorStandIn struct {
+	reacted []*database.RawMessage
+	// deleted is whether each deletion seen was replayed
+	deleted []bool
+}
+
+func (r *reactorStandIn) React(ctx context.Context, msg *database.RawMessage) error {
+	r.reacted = append(r.reacted, msg)
+	return nil
+}
+
+func (r *reactorStandIn) ReactDeleted(ctx context.Context, msg *database.RawMessage, replayed bool) error {
+	r.deleted = append(r.deleted, replayed)
+	return nil
+}
+
+func TestTranscriptionWants(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  database.RawMessage
+		want bool
+	}{
+		{"voice", database.RawMessage{Media: database.Media{Type: database.MediaVoice}}, true},
+		{"video note", database.RawMessage{Media: database.Media{Type: database.MediaVideoNote}}, true},
+		{"transcribed", database.RawMessage{Media: database.Media{Type: database.MediaVoice}, IsTranscribed: true}, false},
+		{"audio", database.RawMessage{Media: database.Media{Type: database.MediaAudio}}, false},
+		{"video", database.RawMessage{Media: database.Media{Type: database.MediaVideo}}, false},
+		{"text", database.RawMessage{}, false},
+	}
+	tr := NewTranscription(config.RateLimitingConfig{}, nil, nil, nil, nil, nil)
+	for _, tt := range tests {
+		if got := tr.Wants(&tt.msg); got != tt.want {
+			t.Errorf("Wants(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTranscriptionEnqueue(t *testing.T) {
+	voice := func(id int) *database.RawMessage {
+		return &database.RawMessage{ID: id, Media: database.Media{Type: database.MediaVoice}}
+	}
+	tr := NewTranscription(config.RateLimitingConfig{}, nil, nil, nil, nil, nil)
+
+	tr.Enqueue(voice(0))
+	tr.Enqueue(&database.RawMessage{ID: 1})
+	tr.Enqueue(voice(2))
+	tr.Enqueue(voice(2))
+	if n := len(tr.queue); n != 1 {
+		t.Fatalf("queued %d messages, want 1", n)
+	}
+	if msg := <-tr.queue; msg.ID != 2 {
+		t.Fatalf("queued message %d, want 2", msg.ID)
+	}
+
+	// Still marked as queued until the worker is done with it
+	tr.Enqueue(voice(2))
+	if n := len(tr.queue); n != 0 {
+		t.Errorf("message queued again while being transcribed")
+	}
+	delete(tr.queued, 2)
+	tr.Enqueue(voice(2))
+	if n := len(tr.queue); n != 1 {
+		t.Errorf("message not queued again once done")
+	}
+	<-tr.queue
+	delete(tr.queued, 2)
+
+	// A full queue leaves the rest to the next scan
+	for id := 1; id <= transcriptionQueueSize+10; id++ {
+		tr.Enqueue(voice(id))
+	}
+	if n := len(tr.queue); n != transcriptionQueueSize {
+		t.Errorf("queued %d messages, want %d", n, transcriptionQueueSize)
+	}
+	if n := len(tr.queued); n != transcriptionQueueSize {
+		t.Errorf("%d messages marked as queued, want %d", n, transcriptionQueueSize)
+	}
+}
+
+func TestTranscriptionFinishError(t *testing.T) {
+	reactor := &reactorStandIn{}
+	tr := NewTranscription(config.RateLimitingConfig{}, nil, nil, nil, nil, reactor)
+	msg := &database.RawMessage{ID: 1, Media: database.Media{Type: database.MediaVoice}}
+
+	for _, want := range []error{ErrTranscriberUnavailable, errors.New("connection reset")} {
+		if err := tr.finish(context.Background(), msg, "", want); !errors.Is(err, want) {
+			t.Errorf("finish(%v) = %v, want it returned", want, err)
+		}
+	}
+	if len(reactor.reacted) != 0 {
+		t.Errorf("reactor ran after a failed transcription")
+	}
+}
+
+// transcriptsStandIn records what the transcription worker stores
+type transcriptsStandIn struct {
+	saved  map[int]string
+	failed map[int]string
+}
+
+func (s *transcriptsStandIn) GetUntranscribed(since time.Time, limit int) ([]*database.RawMessage, error) {
+	return nil, nil
+}
+
+func (s *transcriptsStandIn) SaveTranscript(id int, transcript string) (*database.RawMessage, error) {
+	s.saved[id] = transcript
+	return &database.RawMessage{ID: id, MessageText: sql.NullString{String: transcript, Valid: true}, IsTranscribed: true}, nil
+}
+
+func (s *transcriptsStandIn) FailTranscription(id int, reason string) error {
+	s.failed[id] = reason
+	return nil
+}
+
+func TestTranscriptionFinish(t *testing.T) {
+	transcripts := &transcriptsStandIn{saved: make(map[int]string), failed: make(map[int]string)}
+	reactor := &reactorStandIn{}
+	tr := NewTranscription(config.RateLimitingConfig{}, nil, nil, nil, nil, reactor)
+	tr.messages = transcripts
+
+	voice := func(id int) *database.RawMessage {
+		return &database.RawMessage{ID: id, Media: database.Media{Type: database.MediaVoice, Duration: 3}}
+	}
+	ctx := context.Background()
+	if err := tr.finish(ctx, voice(1), "", &RejectedError{Reason: "MSG_VOICE_MISSING"}); err != nil {
+		t.Fatalf("finish rejected: %v", err)
+	}
+	if err := tr.finish(ctx, voice(2), "", nil); err != nil {
+		t.Fatalf("finish empty: %v", err)
+	}
+	if err := tr.finish(ctx, voice(3), "send the seed phrase", nil); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	// Neither the rejected nor the empty message is tried again
+	if transcripts.failed[1] != "MSG_VOICE_MISSING" || transcripts.failed[2] != "empty transcript" {
+		t.Errorf("failed = %v, want messages 1 and 2 given up on", transcripts.failed)
+	}
+	if len(transcripts.saved) != 1 || transcripts.saved[3] != "send the seed phrase" {
+		t.Errorf("saved = %v, want the transcript of message 3", transcripts.saved)
+	}
+
+	if len(reactor.reacted) != 1 {
+		t.Fatalf("reactor ran %d times, want 1", len(reactor.reacted))
+	}
+	if got := reactor.reacted[0]; !got.IsTranscribed || got.MessageText.String != "send the seed phrase" {
+		t.Errorf("reactor saw %q, transcribed %v", got.MessageText.String, got.IsTranscribed)
+	}
+}
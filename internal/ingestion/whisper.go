@@ -0,0 +1,154 @@
+package ingestion
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+
+	"telemonitor/internal/config"
+)
+
+// maxWhisperStderr is how much of the binary's stderr goes into an error
+const maxWhisperStderr = 500
+
+// WhisperTranscriber downloads the audio of a message and runs a locally
+// installed whisper.cpp compatible binary on it. It works for every account,
+// Premium or not.
+type WhisperTranscriber struct {
+	cfg        config.WhisperConfig
+	downloader *downloader.Downloader
+}
+
+// NewWhisperTranscriber creates a WhisperTranscriber
+func NewWhisperTranscriber(cfg config.WhisperConfig) *WhisperTranscriber {
+	return &WhisperTranscriber{
+		cfg:        cfg,
+		downloader: downloader.NewDownloader(),
+	}
+}
+
+// Transcribe implements Transcriber
+func (w *WhisperTranscriber) Transcribe(ctx context.Context, req TranscribeRequest) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout())
+	defer cancel()
+
+	doc, err := fetchDocument(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "telemonitor-whisper-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audio")
+	if _, err := w.downloader.Download(req.Client.API(), doc.AsInputDocumentFileLocation()).ToPath(ctx, path); err != nil {
+		return "", fmt.Errorf("failed to download audio: %w", err)
+	}
+	return w.transcribeFile(ctx, path)
+}
+
+// transcribeFile runs the binary on an audio file, converting it with ffmpeg
+// first if configured, and returns the transcript
+func (w *WhisperTranscriber) transcribeFile(ctx context.Context, path string) (string, error) {
+	input := path
+	if w.cfg.FFmpeg != "" {
+		input = path + ".wav"
+		if err := run(ctx, w.cfg.FFmpeg, "-nostdin", "-loglevel", "error", "-y",
+			"-i", path, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", input); err != nil {
+			return "", fmt.Errorf("failed to convert audio: %w", err)
+		}
+	}
+
+	base := path + ".transcript"
+	args := []string{"-m", w.cfg.Model, "-f", input, "-nt", "-np", "-otxt", "-of", base}
+	if w.cfg.Language != "" {
+		args = append(args, "-l", w.cfg.Language)
+	}
+	args = append(args, w.cfg.Args...)
+	if err := run(ctx, w.cfg.Binary, args...); err != nil {
+		return "", fmt.Errorf("failed to run whisper: %w", err)
+	}
+
+	out, err := os.ReadFile(base + ".txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to read transcript: %w", err)
+	}
+	return strings.Join(strings.Fields(string(out)), " "), nil
+}
+
+func (w *WhisperTranscriber) timeout() time.Duration {
+	return time.Duration(w.cfg.Timeout) * time.Second
+}
+
+// run executes a command and includes its stderr in the error
+func run(ctx context.Context, name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxWhisperStderr {
+			msg = msg[len(msg)-maxWhisperStderr:]
+		}
+		if msg == "" {
+			return err
+		}
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return nil
+}
+
+// fetchDocument loads the message again for a fresh file reference to its
+// voice message or video note
+func fetchDocument(ctx context.Context, req TranscribeRequest) (*tg.Document, error) {
+	ids := []tg.InputMessageClass{&tg.InputMessageID{ID: req.Message.TelegramMsgID}}
+
+	var (
+		res tg.MessagesMessagesClass
+		err error
+	)
+	if channel, ok := req.Peer.(*tg.InputPeerChannel); ok {
+		res, err = req.Client.API().ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash},
+			ID:      ids,
+		})
+	} else {
+		res, err = req.Client.API().MessagesGetMessages(ctx, ids)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	page, ok := res.AsModified()
+	if !ok {
+		return nil, &RejectedError{Reason: "message not found"}
+	}
+	for _, m := range page.GetMessages() {
+		msg, ok := m.(*tg.Message)
+		if !ok || msg.ID != req.Message.TelegramMsgID {
+			continue
+		}
+		media, ok := msg.Media.(*tg.MessageMediaDocument)
+		if !ok {
+			break
+		}
+		if doc, ok := media.Document.(*tg.Document); ok {
+			return doc, nil
+		}
+	}
+	return nil, &RejectedError{Reason: "audio no longer available"}
+}
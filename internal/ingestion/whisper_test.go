@@ -0,0 +1,128 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"telemonitor/internal/config"
+)
+
+// fakeWhisper parses the whisper.cpp flags the transcriber passes and runs
+// body with $input and $out set
+const fakeWhisper = `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-f) input="$2"; shift ;;
+		-of) out="$2"; shift ;;
+	esac
+	shift
+done
+`
+
+// writeScript creates an executable shell script in dir
+func writeScript(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newFakeWhisper returns a transcriber running a fake binary and an audio
+// file to feed it
+func newFakeWhisper(t *testing.T, body string) (*WhisperTranscriber, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake binaries are shell scripts")
+	}
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "audio")
+	if err := os.WriteFile(audio, []byte("OggS"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWhisperTranscriber(config.WhisperConfig{
+		Binary:   writeScript(t, dir, "whisper", fakeWhisper+body),
+		Model:    "ggml-base.bin",
+		Language: "auto",
+		Timeout:  10,
+	})
+	return w, audio
+}
+
+func TestWhisperTranscribeFile(t *testing.T) {
+	w, audio := newFakeWhisper(t, `
+[ "$input" = "${out%.transcript}" ] || { echo "unexpected input $input" >&2; exit 2; }
+printf ' Hello,\n world.  \n' > "$out.txt"
+`)
+
+	text, err := w.transcribeFile(context.Background(), audio)
+	if err != nil {
+		t.Fatalf("transcribeFile: %v", err)
+	}
+	if text != "Hello, world." {
+		t.Errorf("text = %q, want %q", text, "Hello, world.")
+	}
+}
+
+func TestWhisperConvertsWithFFmpeg(t *testing.T) {
+	w, audio := newFakeWhisper(t, `
+case "$input" in
+	*.wav) ;;
+	*) echo "expected wav input, got $input" >&2; exit 2 ;;
+esac
+[ "$(cat "$input")" = "converted" ] || { echo "input was not converted" >&2; exit 2; }
+echo "from wav" > "$out.txt"
+`)
+	// The fake ffmpeg writes its last argument, the output file
+	w.cfg.FFmpeg = writeScript(t, filepath.Dir(audio), "ffmpeg", `#!/bin/sh
+for last; do :; done
+echo converted > "$last"
+`)
+
+	text, err := w.transcribeFile(context.Background(), audio)
+	if err != nil {
+		t.Fatalf("transcribeFile: %v", err)
+	}
+	if text != "from wav" {
+		t.Errorf("text = %q, want %q", text, "from wav")
+	}
+}
+
+func TestWhisperReportsStderr(t *testing.T) {
+	w, audio := newFakeWhisper(t, `
+echo "failed to load model" >&2
+exit 1
+`)
+
+	_, err := w.transcribeFile(context.Background(), audio)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "failed to load model") {
+		t.Errorf("error %q does not include stderr", err)
+	}
+}
+
+func TestWhisperTimeout(t *testing.T) {
+	w, audio := newFakeWhisper(t, `
+exec sleep 10
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := w.transcribeFile(ctx, audio)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("binary was not killed, took %s", elapsed)
+	}
+}
@@ -0,0 +1,235 @@
+package intelligence
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+)
+
+const (
+	// maxReplyDepth caps the indentation of nested replies
+	maxReplyDepth = 3
+	// maxPromptText is how much of a single message goes into a prompt
+	maxPromptText = 2000
+	// maxParticipants is how many of the most active senders are listed
+	maxParticipants = 10
+	// maxSearchResults is how many matching messages a search prompt holds
+	maxSearchResults = 200
+)
+
+// reportInstructions is the system prompt of the daily report
+const reportInstructions = `You are an analyst summarising a Telegram chat for its monitoring team.
+Messages are grouped into conversations: forum topics, comment threads and reply chains.
+Replies are indented under the message they answer. Senders are named with their @username
+where known; attribute statements to people by it. Summarise each conversation that matters,
+note who drove it and how it ended, and list notable links, files and polls. Ignore small talk.`
+
+// BuildPrompt renders the messages of a chat as the user prompt of a report,
+// one section per thread. topics maps forum topic IDs to their titles and
+// senders maps sender IDs to the senders directory; messages from unknown
+// senders use the name stored with them.
+func BuildPrompt(chat *database.MonitoredChat, messages []*database.RawMessage, topics map[int]string, senders map[int64]*database.Sender) string {
+	var sb strings.Builder
+
+	name := fmt.Sprintf("%d", chat.ChatID)
+	if chat.Title.Valid {
+		name = chat.Title.String
+	}
+	if chat.Username.Valid {
+		name += " (@" + chat.Username.String + ")"
+	}
+	fmt.Fprintf(&sb, "Chat: %s\n", name)
+	if len(messages) > 0 {
+		fmt.Fprintf(&sb, "Period: %s - %s\n",
+			messages[0].CreatedAt.Format("2006-01-02 15:04"),
+			messages[len(messages)-1].CreatedAt.Format("2006-01-02 15:04"))
+	}
+	fmt.Fprintf(&sb, "Messages: %d\n", len(messages))
+	if participants := participants(messages, senders); participants != "" {
+		fmt.Fprintf(&sb, "Most active: %s\n", participants)
+	}
+
+	for _, t := range GroupThreads(messages, topics) {
+		sb.WriteString("\n")
+		writeThread(&sb, t, senders)
+	}
+	return sb.String()
+}
+
+// participants lists the most active senders with their message counts
+func participants(messages []*database.RawMessage, senders map[int64]*database.Sender) string {
+	counts := make(map[string]int)
+	var names []string
+	for _, msg := range messages {
+		name := senderName(msg, senders)
+		if name == "unknown" {
+			continue
+		}
+		if counts[name] == 0 {
+			names = append(names, name)
+		}
+		counts[name]++
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		return counts[names[i]] > counts[names[j]]
+	})
+	if len(names) > maxParticipants {
+		names = names[:maxParticipants]
+	}
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s (%d)", name, counts[name])
+	}
+	return strings.Join(names, ", ")
+}
+
+// senderName names the author of a message
+func senderName(msg *database.RawMessage, senders map[int64]*database.Sender) string {
+	if msg.SenderID.Valid {
+		if s, ok := senders[msg.SenderID.Int64]; ok {
+			return s.String()
+		}
+	}
+	if msg.SenderName.Valid {
+		return msg.SenderName.String
+	}
+	return "unknown"
+}
+
+func writeThread(sb *strings.Builder, t *Thread, senders map[int64]*database.Sender) {
+	switch {
+	case t.Topic != "":
+		fmt.Fprintf(sb, "== Topic %q (%d messages) ==\n", t.Topic, len(t.Messages))
+	case t.RootMsgID == 0:
+		fmt.Fprintf(sb, "== Other messages (%d) ==\n", len(t.Messages))
+	default:
+		fmt.Fprintf(sb, "== Conversation from %s (%d messages) ==\n",
+			t.Messages[0].CreatedAt.Format("15:04"), len(t.Messages))
+	}
+
+	depth := make(map[int]int, len(t.Messages))
+	for _, msg := range t.Messages {
+		d := 0
+		if msg.ReplyToMsgID.Valid {
+			if parent, ok := depth[int(msg.ReplyToMsgID.Int64)]; ok {
+				d = min(parent+1, maxReplyDepth)
+			}
+		}
+		depth[msg.TelegramMsgID] = d
+		writeMessage(sb, msg, d, senders)
+	}
+}
+
+func writeMessage(sb *strings.Builder, msg *database.RawMessage, depth int, senders map[int64]*database.Sender) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	if depth > 0 {
+		sb.WriteString("↳ ")
+	}
+
+	fmt.Fprintf(sb, "[%s] #%d %s", msg.CreatedAt.Format("15:04"), msg.TelegramMsgID, senderName(msg, senders))
+	if msg.IsForward {
+		sb.WriteString(" (forwarded")
+		if msg.ForwardSourceName.Valid {
+			sb.WriteString(" from " + msg.ForwardSourceName.String)
+		}
+		sb.WriteString(")")
+	}
+	sb.WriteString(":")
+
+	if msg.Media.Valid() {
+		fmt.Fprintf(sb, " [%s]", msg.Media)
+	}
+	text := strings.Join(strings.Fields(msg.MessageText.String), " ")
+	if len([]rune(text)) > maxPromptText {
+		text = string([]rune(text)[:maxPromptText]) + "…"
+	}
+	if text != "" {
+		sb.WriteString(" " + text)
+	}
+	if msg.DeletedAt.Valid {
+		sb.WriteString(" [deleted]")
+	}
+	sb.WriteString("\n")
+}
+
+// PromptBuilder loads the messages of a chat for a report
+type PromptBuilder struct {
+	messages *repository.RawMessageRepository
+	topics   *repository.ForumTopicRepository
+	senders  *repository.SenderRepository
+}
+
+// NewPromptBuilder creates a PromptBuilder
+func NewPromptBuilder(messages *repository.RawMessageRepository, topics *repository.ForumTopicRepository, senders *repository.SenderRepository) *PromptBuilder {
+	return &PromptBuilder{messages: messages, topics: topics, senders: senders}
+}
+
+// Instructions returns the system prompt of a report
+func (b *PromptBuilder) Instructions() string {
+	return reportInstructions
+}
+
+// Build renders the messages of chat between start and end. It returns an
+// empty prompt when there are none.
+func (b *PromptBuilder) Build(chat *database.MonitoredChat, start, end time.Time) (string, error) {
+	messages, err := b.messages.GetByChatIDAndTimeRange(chat.ChatID, start, end)
+	if err != nil {
+		return "", err
+	}
+	return b.render(chat, messages)
+}
+
+// BuildThread renders one conversation of chat in full, however long ago it
+// started, e.g. to explain a message a report or an alert points at
+func (b *PromptBuilder) BuildThread(chat *database.MonitoredChat, rootMsgID int) (string, error) {
+	messages, err := b.messages.GetThread(chat.ChatID, rootMsgID)
+	if err != nil {
+		return "", err
+	}
+	return b.render(chat, messages)
+}
+
+// BuildSearch renders the messages of chat between start and end whose text
+// or media metadata matches query, such as a file name or a poll question.
+// Only the newest maxSearchResults matches are kept.
+func (b *PromptBuilder) BuildSearch(chat *database.MonitoredChat, query string, start, end time.Time) (string, error) {
+	messages, err := b.messages.Search(chat.ChatID, query, start, end, maxSearchResults)
+	if err != nil {
+		return "", err
+	}
+	// Search returns the newest first; threads are read oldest first
+	slices.Reverse(messages)
+	return b.render(chat, messages)
+}
+
+// render looks up the topic titles of chat and the senders of messages and
+// builds the prompt, or returns an empty one when there are no messages
+func (b *PromptBuilder) render(chat *database.MonitoredChat, messages []*database.RawMessage) (string, error) {
+	if len(messages) == 0 {
+		return "", nil
+	}
+
+	topics, err := b.topics.GetTitles(chat.ChatID)
+	if err != nil {
+		return "", err
+	}
+
+	seen := make(map[int64]bool)
+	var ids []int64
+	for _, msg := range messages {
+		if msg.SenderID.Valid && !seen[msg.SenderID.Int64] {
+			seen[msg.SenderID.Int64] = true
+			ids = append(ids, msg.SenderID.Int64)
+		}
+	}
+	senders, err := b.senders.GetByIDs(ids)
+	if err != nil {
+		return "", err
+	}
+	return BuildPrompt(chat, messages, topics, senders), nil
+}
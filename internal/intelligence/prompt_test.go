@@ -0,0 +1,76 @@
+package intelligence
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"telemonitor/internal/database"
+)
+
+func TestBuildPrompt(t *testing.T) {
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	// say fills in the sender, text and time of a message built by message
+	say := func(msg *database.RawMessage, minute int, sender, text string) *database.RawMessage {
+		msg.ChatID = -1001
+		msg.CreatedAt = at.Add(time.Duration(minute) * time.Minute)
+		msg.SenderName = sql.NullString{String: sender, Valid: sender != ""}
+		msg.MessageText = sql.NullString{String: text, Valid: text != ""}
+		return msg
+	}
+
+	// Alice is in the senders directory under a newer name
+	alice := func(msg *database.RawMessage) *database.RawMessage {
+		msg.SenderID = sql.NullInt64{Int64: 100, Valid: true}
+		return msg
+	}
+	senders := map[int64]*database.Sender{
+		100: {
+			UserID:    100,
+			Username:  sql.NullString{String: "alice", Valid: true},
+			FirstName: sql.NullString{String: "Alice", Valid: true},
+			LastName:  sql.NullString{String: "Smith", Valid: true},
+		},
+	}
+
+	poll := say(message(5, 0, 0, 0), 4, "Bob", "")
+	poll.Media = database.Media{Type: database.MediaPoll, Question: "Lunch?", Options: []string{"yes", "no"}}
+	deleted := say(message(6, 0, 0, 7), 5, "", "gone")
+	deleted.DeletedAt = sql.NullTime{Time: at, Valid: true}
+
+	chat := &database.MonitoredChat{
+		ChatID:   -1001,
+		Title:    sql.NullString{String: "Team", Valid: true},
+		Username: sql.NullString{String: "team", Valid: true},
+	}
+	messages := []*database.RawMessage{
+		alice(say(message(1, 0, 0, 0), 0, "Alice", "Deploy at noon?")),
+		say(message(2, 1, 0, 0), 1, "Bob", "Fine  by\nme"),
+		alice(say(message(3, 2, 0, 0), 2, "Al", "Done")),
+		say(message(4, 0, 0, 7), 3, "Carol", "Welcome"),
+		poll,
+		deleted,
+	}
+	topics := map[int]string{7: "News"}
+
+	want := `Chat: Team (@team)
+Period: 2024-05-01 09:00 - 2024-05-01 09:05
+Messages: 6
+Most active: Alice Smith (@alice) (2), Bob (2), Carol (1)
+
+== Conversation from 09:00 (3 messages) ==
+[09:00] #1 Alice Smith (@alice): Deploy at noon?
+  ↳ [09:01] #2 Bob: Fine by me
+    ↳ [09:02] #3 Alice Smith (@alice): Done
+
+== Topic "News" (2 messages) ==
+[09:03] #4 Carol: Welcome
+[09:05] #6 unknown: gone [deleted]
+
+== Other messages (1) ==
+[09:04] #5 Bob: [` + poll.Media.String() + `]
+`
+	if got := BuildPrompt(chat, messages, topics, senders); got != want {
+		t.Errorf("BuildPrompt =\n%s\nwant\n%s", got, want)
+	}
+}
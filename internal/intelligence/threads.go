@@ -0,0 +1,83 @@
+package intelligence
+
+import "telemonitor/internal/database"
+
+// Thread is a conversation within a chat: a forum topic, a comment thread or
+// a reply chain. Messages that are not part of any conversation are
+// collected in a thread with RootMsgID 0.
+type Thread struct {
+	RootMsgID int
+	// Topic is the forum topic title, if the thread is a topic
+	Topic    string
+	Messages []*database.RawMessage
+}
+
+// threadKey identifies a thread; topics and reply chains are numbered
+// separately because a topic ID is also a message ID
+type threadKey struct {
+	topic int
+	root  int
+}
+
+// GroupThreads splits chronologically ordered messages into threads ordered
+// by their first message. A reply whose parent is outside messages is
+// grouped with the other replies to the same parent.
+func GroupThreads(messages []*database.RawMessage, topics map[int]string) []*Thread {
+	byID := make(map[int]*database.RawMessage, len(messages))
+	replied := make(map[int]bool)
+	for _, msg := range messages {
+		byID[msg.TelegramMsgID] = msg
+		if msg.ReplyToMsgID.Valid {
+			replied[int(msg.ReplyToMsgID.Int64)] = true
+		}
+	}
+
+	threads := make(map[threadKey]*Thread)
+	var order []*Thread
+	for _, msg := range messages {
+		key := threadOf(msg, byID, replied)
+		t, ok := threads[key]
+		if !ok {
+			t = &Thread{RootMsgID: key.root}
+			if key.topic != 0 {
+				t.RootMsgID = key.topic
+				t.Topic = topics[key.topic]
+			}
+			threads[key] = t
+			order = append(order, t)
+		}
+		t.Messages = append(t.Messages, msg)
+	}
+	return order
+}
+
+// threadOf finds the thread a message belongs to
+func threadOf(msg *database.RawMessage, byID map[int]*database.RawMessage, replied map[int]bool) threadKey {
+	if msg.TopicID.Valid {
+		return threadKey{topic: int(msg.TopicID.Int64)}
+	}
+	if msg.ReplyToTopID.Valid {
+		return threadKey{root: int(msg.ReplyToTopID.Int64)}
+	}
+
+	// Walk up the reply chain; seen guards against malformed cycles
+	root := msg
+	seen := map[int]bool{msg.TelegramMsgID: true}
+	for root.ReplyToMsgID.Valid {
+		parentID := int(root.ReplyToMsgID.Int64)
+		parent, ok := byID[parentID]
+		if !ok || seen[parentID] {
+			return threadKey{root: parentID}
+		}
+		if parent.ReplyToTopID.Valid {
+			return threadKey{root: int(parent.ReplyToTopID.Int64)}
+		}
+		seen[parentID] = true
+		root = parent
+	}
+
+	if root == msg && !replied[msg.TelegramMsgID] {
+		return threadKey{}
+	}
+	return threadKey{root: root.TelegramMsgID}
+}
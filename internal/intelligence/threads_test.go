@@ -0,0 +1,94 @@
+package intelligence
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"telemonitor/internal/database"
+)
+
+// message builds a message; zero reply, top and topic IDs are left unset
+func message(id, replyTo, top, topic int) *database.RawMessage {
+	null := func(n int) sql.NullInt64 {
+		return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+	}
+	return &database.RawMessage{
+		TelegramMsgID: id,
+		ReplyToMsgID:  null(replyTo),
+		ReplyToTopID:  null(top),
+		TopicID:       null(topic),
+	}
+}
+
+// describeThreads renders threads as "root topic: ids | ..."
+func describeThreads(threads []*Thread) string {
+	parts := make([]string, len(threads))
+	for i, t := range threads {
+		ids := make([]string, len(t.Messages))
+		for j, msg := range t.Messages {
+			ids[j] = fmt.Sprint(msg.TelegramMsgID)
+		}
+		parts[i] = fmt.Sprintf("%d%s: %s", t.RootMsgID, strings.TrimRight(" "+t.Topic, " "), strings.Join(ids, ","))
+	}
+	return strings.Join(parts, " | ")
+}
+
+func TestGroupThreads(t *testing.T) {
+	topics := map[int]string{7: "News"}
+	tests := []struct {
+		name     string
+		messages []*database.RawMessage
+		want     string
+	}{
+		{
+			name:     "no messages",
+			messages: nil,
+			want:     "",
+		},
+		{
+			name:     "no replies",
+			messages: []*database.RawMessage{message(1, 0, 0, 0), message(2, 0, 0, 0)},
+			want:     "0: 1,2",
+		},
+		{
+			name: "reply chain",
+			messages: []*database.RawMessage{
+				message(1, 0, 0, 0), message(2, 1, 0, 0), message(3, 0, 0, 0), message(4, 2, 0, 0),
+			},
+			want: "1: 1,2,4 | 0: 3",
+		},
+		{
+			name:     "parent not loaded",
+			messages: []*database.RawMessage{message(5, 100, 0, 0), message(6, 0, 0, 0), message(8, 100, 0, 0)},
+			want:     "100: 5,8 | 0: 6",
+		},
+		{
+			name: "comment thread",
+			messages: []*database.RawMessage{
+				message(51, 50, 50, 0), message(52, 51, 50, 0), message(53, 52, 0, 0),
+			},
+			want: "50: 51,52,53",
+		},
+		{
+			name: "forum topic",
+			messages: []*database.RawMessage{
+				message(8, 7, 0, 7), message(9, 8, 7, 7), message(10, 0, 0, 11), message(12, 7, 0, 0),
+			},
+			want: "7 News: 8,9 | 11: 10 | 7: 12",
+		},
+		{
+			name:     "reply cycle",
+			messages: []*database.RawMessage{message(1, 2, 0, 0), message(2, 1, 0, 0)},
+			want:     "1: 1 | 2: 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeThreads(GroupThreads(tt.messages, topics)); got != tt.want {
+				t.Errorf("GroupThreads = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
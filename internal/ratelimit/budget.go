@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Budget allows at most a fixed number of operations in any sliding window,
+// one minute long for settings such as transcriptions_per_minute
+type Budget struct {
+	limit  int
+	window time.Duration
+
+	mu     sync.Mutex
+	recent []time.Time
+}
+
+// NewBudget creates a Budget. A non-positive perMinute means no limit.
+func NewBudget(perMinute int) *Budget {
+	return &Budget{limit: perMinute, window: time.Minute}
+}
+
+// Wait blocks until an operation fits in the budget and records it
+func (b *Budget) Wait(ctx context.Context) error {
+	for {
+		wait := b.reserve()
+		if wait == 0 {
+			return nil
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve records an operation and returns 0, or returns how long to wait
+// before the oldest operation leaves the window
+func (b *Budget) reserve() time.Duration {
+	if b.limit <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-b.window)
+	for len(b.recent) > 0 && !b.recent[0].After(cutoff) {
+		b.recent = b.recent[1:]
+	}
+	if len(b.recent) < b.limit {
+		b.recent = append(b.recent, now)
+		return 0
+	}
+	return b.recent[0].Sub(cutoff)
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testWindow is the budget window used by the tests in place of a minute
+const testWindow = 100 * time.Millisecond
+
+func TestBudgetReserve(t *testing.T) {
+	b := &Budget{limit: 2, window: testWindow}
+
+	for i := 0; i < 2; i++ {
+		if wait := b.reserve(); wait != 0 {
+			t.Fatalf("reserve %d = %s, want 0", i+1, wait)
+		}
+	}
+	wait := b.reserve()
+	if wait <= 0 || wait > testWindow {
+		t.Fatalf("reserve over the limit = %s, want up to %s", wait, testWindow)
+	}
+	if len(b.recent) != 2 {
+		t.Errorf("recorded %d operations, want 2", len(b.recent))
+	}
+
+	time.Sleep(wait)
+	if wait := b.reserve(); wait != 0 {
+		t.Errorf("reserve after the window = %s, want 0", wait)
+	}
+}
+
+func TestBudgetUnlimited(t *testing.T) {
+	for _, perMinute := range []int{0, -1} {
+		b := NewBudget(perMinute)
+		for i := 0; i < 100; i++ {
+			if wait := b.reserve(); wait != 0 {
+				t.Fatalf("NewBudget(%d) reserve %d = %s, want 0", perMinute, i+1, wait)
+			}
+		}
+	}
+}
+
+func TestBudgetWait(t *testing.T) {
+	b := &Budget{limit: 3, window: testWindow}
+
+	start := time.Now()
+	for i := 0; i < 7; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The 4th to 6th operations wait for the first window, the 7th for the
+	// second
+	if elapsed := time.Since(start); elapsed < 2*testWindow {
+		t.Errorf("7 operations took %s, want at least %s", elapsed, 2*testWindow)
+	}
+}
+
+func TestBudgetWaitCancelled(t *testing.T) {
+	b := &Budget{limit: 1, window: time.Minute}
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testWindow)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait over the limit = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
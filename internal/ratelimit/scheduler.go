@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+
+	"telemonitor/internal/config"
+)
+
+// maxFloodRetries bounds how often one call is retried after FLOOD_WAIT
+const maxFloodRetries = 3
+
+// lightPrefixes are methods exempt from the human-like delay: session
+// bookkeeping, login and file chunk transfers
+var lightPrefixes = []string{
+	"auth.",
+	"account.",
+	"help.",
+	"langpack.",
+	"upload.",
+	"updates.getState",
+	"updates.getDifference",
+}
+
+// State describes the current backoff of a scheduler
+type State struct {
+	Throttled bool
+	Method    string
+	Remaining time.Duration
+}
+
+// String renders the state for /status, e.g. "throttled for 340s (messages.getHistory)"
+func (s State) String() string {
+	if !s.Throttled {
+		return "not throttled"
+	}
+	return fmt.Sprintf("throttled for %ds (%s)", int(s.Remaining.Round(time.Second).Seconds()), s.Method)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Scheduler is a gotd middleware that paces MTProto calls: it spaces heavy
+// requests by a random human-like delay, limits every method with a token
+// bucket and honours FLOOD_WAIT_X by sleeping exactly X seconds before
+// retrying
+type Scheduler struct {
+	minDelay     time.Duration
+	maxDelay     time.Duration
+	rate         float64 // tokens per second for each method
+	burst        float64
+	maxFloodWait time.Duration
+
+	mu         sync.Mutex
+	nextSlot   time.Time
+	buckets    map[string]*bucket
+	floodUntil map[string]time.Time
+}
+
+var _ telegram.Middleware = (*Scheduler)(nil)
+
+// New creates a Scheduler from the rate limiting settings
+func New(cfg config.RateLimitingConfig) *Scheduler {
+	return &Scheduler{
+		minDelay:     time.Duration(cfg.MinDelay) * time.Millisecond,
+		maxDelay:     time.Duration(cfg.MaxDelay) * time.Millisecond,
+		rate:         float64(cfg.MethodsPerMinute) / 60,
+		burst:        float64(cfg.MethodBurst),
+		maxFloodWait: time.Duration(cfg.MaxFloodWait) * time.Second,
+		buckets:      make(map[string]*bucket),
+		floodUntil:   make(map[string]time.Time),
+	}
+}
+
+// Handle implements telegram.Middleware
+func (s *Scheduler) Handle(next tg.Invoker) telegram.InvokeFunc {
+	return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		method := methodName(input)
+
+		for attempt := 0; ; attempt++ {
+			if err := sleep(ctx, s.reserve(method)); err != nil {
+				return err
+			}
+
+			err := next.Invoke(ctx, input, output)
+			wait, ok := tgerr.AsFloodWait(err)
+			if !ok {
+				return err
+			}
+
+			s.recordFloodWait(method, wait)
+			if wait > s.maxFloodWait || attempt >= maxFloodRetries {
+				log.Printf("FLOOD_WAIT on %s for %s, giving up", method, wait)
+				return err
+			}
+			log.Printf("FLOOD_WAIT on %s, sleeping %s", method, wait)
+		}
+	}
+}
+
+// State returns the longest FLOOD_WAIT backoff still in effect
+func (s *Scheduler) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var state State
+	for method, until := range s.floodUntil {
+		remaining := until.Sub(now)
+		if remaining <= 0 {
+			delete(s.floodUntil, method)
+			continue
+		}
+		if remaining > state.Remaining {
+			state = State{Throttled: true, Method: method, Remaining: remaining}
+		}
+	}
+	return state
+}
+
+// reserve books the next slot for method and returns how long to wait for it
+func (s *Scheduler) reserve(method string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	start := now
+
+	if until, ok := s.floodUntil[method]; ok && until.After(start) {
+		start = until
+	}
+
+	if s.rate > 0 {
+		b, ok := s.buckets[method]
+		if !ok {
+			b = &bucket{tokens: s.burst, last: now}
+			s.buckets[method] = b
+		}
+		b.tokens = min(s.burst, b.tokens+now.Sub(b.last).Seconds()*s.rate)
+		b.last = now
+		b.tokens--
+		if b.tokens < 0 {
+			// Borrowed a future token; wait until it is refilled
+			refill := now.Add(time.Duration(-b.tokens / s.rate * float64(time.Second)))
+			if refill.After(start) {
+				start = refill
+			}
+		}
+	}
+
+	if !isLight(method) {
+		if s.nextSlot.After(start) {
+			start = s.nextSlot
+		}
+		s.nextSlot = start.Add(s.jitter())
+	}
+
+	return start.Sub(now)
+}
+
+func (s *Scheduler) recordFloodWait(method string, wait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.floodUntil[method] = time.Now().Add(wait)
+}
+
+func (s *Scheduler) jitter() time.Duration {
+	if s.maxDelay <= s.minDelay {
+		return s.minDelay
+	}
+	return s.minDelay + time.Duration(rand.Int63n(int64(s.maxDelay-s.minDelay)))
+}
+
+func isLight(method string) bool {
+	for _, prefix := range lightPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func methodName(input bin.Encoder) string {
+	if named, ok := input.(interface{ TypeName() string }); ok {
+		return named.TypeName()
+	}
+	return fmt.Sprintf("%T", input)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
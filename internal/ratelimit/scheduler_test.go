@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+
+	"telemonitor/internal/config"
+)
+
+// invokerStandIn answers calls with the queued errors, then with nil
+type invokerStandIn struct {
+	errs  []error
+	calls int
+}
+
+func (i *invokerStandIn) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	i.calls++
+	if len(i.errs) == 0 {
+		return nil
+	}
+	err := i.errs[0]
+	i.errs = i.errs[1:]
+	return err
+}
+
+// newTestScheduler creates a Scheduler without delays or method buckets,
+// so only FLOOD_WAIT paces calls
+func newTestScheduler(maxFloodWait int) *Scheduler {
+	return New(config.RateLimitingConfig{MaxFloodWait: maxFloodWait})
+}
+
+func TestFloodWaitRetry(t *testing.T) {
+	s := newTestScheduler(5)
+	next := &invokerStandIn{errs: []error{tgerr.New(420, "FLOOD_WAIT_1")}}
+
+	start := time.Now()
+	if err := s.Handle(next)(context.Background(), &tg.MessagesGetHistoryRequest{}, nil); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least 1s", elapsed)
+	}
+	if next.calls != 2 {
+		t.Errorf("calls = %d, want 2", next.calls)
+	}
+}
+
+func TestFloodWaitTooLong(t *testing.T) {
+	s := newTestScheduler(5)
+	next := &invokerStandIn{errs: []error{tgerr.New(420, "FLOOD_WAIT_30")}}
+
+	err := s.Handle(next)(context.Background(), &tg.MessagesGetHistoryRequest{}, nil)
+	if _, ok := tgerr.AsFloodWait(err); !ok {
+		t.Fatalf("Handle error = %v, want FLOOD_WAIT", err)
+	}
+	if next.calls != 1 {
+		t.Errorf("calls = %d, want 1", next.calls)
+	}
+
+	state := s.State()
+	if !state.Throttled || state.Method != "messages.getHistory" {
+		t.Errorf("State = %+v, want throttled on messages.getHistory", state)
+	}
+	if state.Remaining <= 29*time.Second || state.Remaining > 30*time.Second {
+		t.Errorf("State remaining = %s, want about 30s", state.Remaining)
+	}
+}
+
+func TestFloodWaitRetryLimit(t *testing.T) {
+	s := newTestScheduler(5)
+	flood := tgerr.New(420, "FLOOD_WAIT_0")
+	next := &invokerStandIn{errs: []error{flood, flood, flood, flood, flood}}
+
+	err := s.Handle(next)(context.Background(), &tg.MessagesGetHistoryRequest{}, nil)
+	if _, ok := tgerr.AsFloodWait(err); !ok {
+		t.Fatalf("Handle error = %v, want FLOOD_WAIT", err)
+	}
+	if next.calls != maxFloodRetries+1 {
+		t.Errorf("calls = %d, want %d", next.calls, maxFloodRetries+1)
+	}
+}
+
+func TestFloodWaitPacesMethod(t *testing.T) {
+	s := newTestScheduler(5)
+	s.recordFloodWait("messages.getHistory", 200*time.Millisecond)
+
+	tests := []struct {
+		method   string
+		min, max time.Duration
+	}{
+		{"messages.getHistory", 150 * time.Millisecond, 200 * time.Millisecond},
+		// Light methods skip the queue of heavy calls, which now starts after
+		// the backoff
+		{"upload.getFile", 0, 0},
+	}
+	for _, tt := range tests {
+		if wait := s.reserve(tt.method); wait < tt.min || wait > tt.max {
+			t.Errorf("reserve(%s) = %s, want between %s and %s", tt.method, wait, tt.min, tt.max)
+		}
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if wait := s.reserve("messages.getHistory"); wait != 0 {
+		t.Errorf("reserve after the backoff = %s, want 0", wait)
+	}
+	if state := s.State(); state.Throttled {
+		t.Errorf("State after the backoff = %+v, want not throttled", state)
+	}
+}
+
+func TestStateString(t *testing.T) {
+	tests := []struct {
+		state State
+		want  string
+	}{
+		{State{}, "not throttled"},
+		{State{Throttled: true, Method: "messages.getHistory", Remaining: 339600 * time.Millisecond}, "throttled for 340s (messages.getHistory)"},
+	}
+	for _, tt := range tests {
+		if got := tt.state.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+}
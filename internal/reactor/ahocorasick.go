@@ -0,0 +1,159 @@
+package reactor
+
+import "sort"
+
+// automaton is an Aho-Corasick automaton: it finds every occurrence of a set
+// of byte patterns in a single pass over the text, however many patterns
+// there are. Patterns are matched as bytes, which is exact for UTF-8 text as
+// no character's encoding occurs inside another's.
+type automaton struct {
+	nodes []acNode
+	// root holds the transitions of the root for every byte, so the common
+	// case of a byte starting no pattern needs no search
+	root [256]int32
+	// patterns is the number of patterns
+	patterns int
+}
+
+// acNode is a trie node; the node reached by a text prefix is the longest
+// pattern prefix that is a suffix of it
+type acNode struct {
+	// edges are the trie children sorted by byte
+	edges []acEdge
+	// fail is the node of the longest proper suffix that is in the trie
+	fail int32
+	// pattern is the index of the pattern ending here, or -1
+	pattern int32
+	// dict is the nearest node on the fail chain where a pattern ends, or 0
+	dict int32
+}
+
+type acEdge struct {
+	b    byte
+	node int32
+}
+
+// newAutomaton builds an automaton over patterns; pattern i is reported as
+// i. Empty and repeated patterns are never reported, so callers must
+// deduplicate first.
+func newAutomaton(patterns []string) *automaton {
+	a := &automaton{nodes: []acNode{{pattern: -1}}, patterns: len(patterns)}
+
+	for i, p := range patterns {
+		if p == "" {
+			continue
+		}
+		n := int32(0)
+		for j := 0; j < len(p); j++ {
+			next, ok := a.child(n, p[j])
+			if !ok {
+				next = int32(len(a.nodes))
+				a.nodes = append(a.nodes, acNode{pattern: -1})
+				a.addEdge(n, p[j], next)
+			}
+			n = next
+		}
+		if a.nodes[n].pattern < 0 {
+			a.nodes[n].pattern = int32(i)
+		}
+	}
+
+	for _, e := range a.nodes[0].edges {
+		a.root[e.b] = e.node
+	}
+
+	// Breadth first, so the fail link of every shorter prefix is known
+	queue := make([]int32, 0, len(a.nodes))
+	for _, e := range a.nodes[0].edges {
+		queue = append(queue, e.node)
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, e := range a.nodes[n].edges {
+			fail := a.nodes[n].fail
+			for {
+				if next, ok := a.step(fail, e.b); ok && next != e.node {
+					a.nodes[e.node].fail = next
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = a.nodes[fail].fail
+			}
+			f := a.nodes[e.node].fail
+			if a.nodes[f].pattern >= 0 {
+				a.nodes[e.node].dict = f
+			} else {
+				a.nodes[e.node].dict = a.nodes[f].dict
+			}
+			queue = append(queue, e.node)
+		}
+	}
+	return a
+}
+
+// child returns the trie child of n for b
+func (a *automaton) child(n int32, b byte) (int32, bool) {
+	edges := a.nodes[n].edges
+	i := sort.Search(len(edges), func(i int) bool { return edges[i].b >= b })
+	if i < len(edges) && edges[i].b == b {
+		return edges[i].node, true
+	}
+	return 0, false
+}
+
+func (a *automaton) addEdge(n int32, b byte, child int32) {
+	edges := a.nodes[n].edges
+	i := sort.Search(len(edges), func(i int) bool { return edges[i].b >= b })
+	edges = append(edges, acEdge{})
+	copy(edges[i+1:], edges[i:])
+	edges[i] = acEdge{b: b, node: child}
+	a.nodes[n].edges = edges
+}
+
+// step follows the trie edge for b, using the dense table at the root
+func (a *automaton) step(n int32, b byte) (int32, bool) {
+	if n == 0 {
+		next := a.root[b]
+		return next, next != 0
+	}
+	return a.child(n, b)
+}
+
+// match calls found once for every pattern occurring in text
+func (a *automaton) match(text string, found func(pattern int)) {
+	if a.patterns == 0 {
+		return
+	}
+	seen := make([]uint64, (a.patterns+63)/64)
+
+	n := int32(0)
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		for {
+			if next, ok := a.step(n, b); ok {
+				n = next
+				break
+			}
+			if n == 0 {
+				break
+			}
+			n = a.nodes[n].fail
+		}
+
+		out := n
+		if a.nodes[out].pattern < 0 {
+			out = a.nodes[out].dict
+		}
+		for out != 0 {
+			p := a.nodes[out].pattern
+			if seen[p/64]&(1<<(p%64)) == 0 {
+				seen[p/64] |= 1 << (p % 64)
+				found(int(p))
+			}
+			out = a.nodes[out].dict
+		}
+	}
+}
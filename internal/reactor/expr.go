@@ -0,0 +1,460 @@
+package reactor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Trigger expressions combine words and phrases with boolean operators:
+//
+//	(airdrop OR claim) AND NOT scam NEAR/5 wallet
+//
+// Operators are upper case; from the loosest to the tightest they are OR,
+// AND, NOT and NEAR/n, and parentheses group. Words written one after
+// another form a phrase, as does text in double quotes, and a phrase
+// matches where its words occur in a row as whole words. A ~ before a word
+// or quoted phrase matches its words in any inflection. NEAR/n joins two
+// words or phrases and matches where they are at most n words apart, in
+// either order.
+
+const (
+	// maxExprDepth bounds the nesting of parentheses and NOTs
+	maxExprDepth = 50
+	// maxNear bounds the distance of NEAR/n
+	maxNear = 1000
+)
+
+// exprNode is a node of a parsed expression
+type exprNode interface {
+	// eval reports whether the node matches the text of ti
+	eval(ti *tokenIndex) bool
+	// String renders the node in a form parseExpr reads back to the same
+	// tree, with only the parentheses that takes
+	String() string
+	// prec is the precedence of the node's operator, higher binding tighter
+	prec() int
+}
+
+// Operator precedences
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precNear
+	precTerm
+)
+
+// operand renders x as an operand of an operator of precedence prec,
+// in parentheses if it binds looser, or as tight if right is set, as the
+// binary operators group to the left
+func operand(x exprNode, prec int, right bool) string {
+	if x.prec() < prec || right && x.prec() == prec {
+		return "(" + x.String() + ")"
+	}
+	return x.String()
+}
+
+// termNode is a word or phrase
+type termNode struct {
+	// text is the phrase as written
+	text string
+	// tokens are the words of text, folded, or their stems if stem is set
+	tokens []string
+	stem   bool
+}
+
+func (n *termNode) eval(ti *tokenIndex) bool {
+	return len(ti.occurrences(n.tokens, n.stem)) > 0
+}
+
+func (n *termNode) prec() int { return precTerm }
+
+func (n *termNode) String() string {
+	if n.stem {
+		return `~"` + n.text + `"`
+	}
+	return `"` + n.text + `"`
+}
+
+type notNode struct {
+	x exprNode
+}
+
+func (n *notNode) eval(ti *tokenIndex) bool { return !n.x.eval(ti) }
+func (n *notNode) prec() int                { return precNot }
+func (n *notNode) String() string           { return "NOT " + operand(n.x, precNot, false) }
+
+type andNode struct {
+	x, y exprNode
+}
+
+func (n *andNode) eval(ti *tokenIndex) bool { return n.x.eval(ti) && n.y.eval(ti) }
+func (n *andNode) prec() int                { return precAnd }
+func (n *andNode) String() string {
+	return operand(n.x, precAnd, false) + " AND " + operand(n.y, precAnd, true)
+}
+
+type orNode struct {
+	x, y exprNode
+}
+
+func (n *orNode) eval(ti *tokenIndex) bool { return n.x.eval(ti) || n.y.eval(ti) }
+func (n *orNode) prec() int                { return precOr }
+func (n *orNode) String() string {
+	return operand(n.x, precOr, false) + " OR " + operand(n.y, precOr, true)
+}
+
+// nearNode matches two terms at most distance words apart: the number of
+// words from the last word of the first one to the first word of the other
+type nearNode struct {
+	x, y     *termNode
+	distance int
+}
+
+func (n *nearNode) eval(ti *tokenIndex) bool {
+	xs := ti.occurrences(n.x.tokens, n.x.stem)
+	if len(xs) == 0 {
+		return false
+	}
+	ys := ti.occurrences(n.y.tokens, n.y.stem)
+	for _, i := range xs {
+		for _, j := range ys {
+			d := j - (i + len(n.x.tokens) - 1)
+			if j < i {
+				d = i - (j + len(n.y.tokens) - 1)
+			}
+			if d <= n.distance {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (n *nearNode) prec() int { return precNear }
+
+func (n *nearNode) String() string {
+	return fmt.Sprintf("%s NEAR/%d %s", n.x, n.distance, n.y)
+}
+
+// ExprError is a syntax error in a trigger expression
+type ExprError struct {
+	// Pos is the position of the offending character, counting from 1
+	Pos int
+	Msg string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("%s at character %d", e.Msg, e.Pos)
+}
+
+type exprTokenKind int
+
+const (
+	exprEOF exprTokenKind = iota
+	exprLParen
+	exprRParen
+	exprAnd
+	exprOr
+	exprNot
+	exprNear
+	exprWord
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	// pos is the position of the token, counting characters from 1
+	pos int
+	// text is the word or quoted phrase without the quotes or ~
+	text   string
+	quoted bool
+	stem   bool
+	// distance is the n of NEAR/n
+	distance int
+}
+
+// describe names a token for an error message
+func (t exprToken) describe() string {
+	switch t.kind {
+	case exprEOF:
+		return "end of expression"
+	case exprLParen:
+		return `"("`
+	case exprRParen:
+		return `")"`
+	case exprAnd:
+		return "AND"
+	case exprOr:
+		return "OR"
+	case exprNot:
+		return "NOT"
+	case exprNear:
+		return fmt.Sprintf("NEAR/%d", t.distance)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lexExpr splits an expression into tokens
+func lexExpr(src string) ([]exprToken, error) {
+	runes := []rune(src)
+	var tokens []exprToken
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, exprToken{kind: exprLParen, pos: i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, exprToken{kind: exprRParen, pos: i + 1})
+			i++
+		default:
+			tok := exprToken{kind: exprWord, pos: i + 1}
+			if r == '~' {
+				tok.stem = true
+				i++
+			}
+			if i < len(runes) && runes[i] == '"' {
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end == len(runes) {
+					return nil, &ExprError{Pos: i + 1, Msg: "unterminated quote"}
+				}
+				tok.text, tok.quoted = string(runes[i+1:end]), true
+				i = end + 1
+			} else {
+				start := i
+				for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' {
+					i++
+				}
+				tok.text = string(runes[start:i])
+			}
+
+			if tok.stem && !tok.quoted && tok.text == "" {
+				return nil, &ExprError{Pos: tok.pos, Msg: "~ must be followed by a word or phrase"}
+			}
+			if !tok.quoted && !tok.stem {
+				if err := keyword(&tok); err != nil {
+					return nil, err
+				}
+			}
+			tokens = append(tokens, tok)
+		}
+	}
+	return append(tokens, exprToken{kind: exprEOF, pos: len(runes) + 1}), nil
+}
+
+// keyword turns a bare word token that is an operator into one
+func keyword(tok *exprToken) error {
+	switch tok.text {
+	case "AND":
+		tok.kind = exprAnd
+	case "OR":
+		tok.kind = exprOr
+	case "NOT":
+		tok.kind = exprNot
+	default:
+		value, ok := strings.CutPrefix(tok.text, "NEAR/")
+		if !ok {
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxNear {
+			return &ExprError{Pos: tok.pos, Msg: fmt.Sprintf("NEAR needs a distance from 1 to %d, as in NEAR/5", maxNear)}
+		}
+		tok.kind, tok.distance = exprNear, n
+	}
+	return nil
+}
+
+// exprParser is a recursive descent parser over the tokens of an
+// expression
+type exprParser struct {
+	tokens []exprToken
+	next   int
+	depth  int
+	// fold lowercases or normalizes the text of a term
+	fold func(string) string
+}
+
+// parseExpr parses an expression, folding the text of its terms with fold
+// as the text it is matched against is folded
+func parseExpr(src string, fold func(string) string) (exprNode, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, fold: fold}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != exprEOF {
+		return nil, p.unexpected(tok)
+	}
+	return n, nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) take() exprToken {
+	tok := p.tokens[p.next]
+	if tok.kind != exprEOF {
+		p.next++
+	}
+	return tok
+}
+
+func (p *exprParser) unexpected(tok exprToken) error {
+	return &ExprError{Pos: tok.pos, Msg: "unexpected " + tok.describe()}
+}
+
+// or parses and (OR and)*
+func (p *exprParser) or() (exprNode, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == exprOr {
+		p.take()
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = &orNode{x: x, y: y}
+	}
+	return x, nil
+}
+
+// and parses not (AND not)*
+func (p *exprParser) and() (exprNode, error) {
+	x, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == exprAnd {
+		p.take()
+		y, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		x = &andNode{x: x, y: y}
+	}
+	return x, nil
+}
+
+// not parses NOT not | near
+func (p *exprParser) not() (exprNode, error) {
+	if p.peek().kind != exprNot {
+		return p.near()
+	}
+	tok := p.take()
+	if err := p.enter(tok); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	x, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	return &notNode{x: x}, nil
+}
+
+// near parses primary (NEAR/n term)?, where NEAR joins two terms
+func (p *exprParser) near() (exprNode, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != exprNear {
+		return x, nil
+	}
+	op := p.take()
+	xt, ok := x.(*termNode)
+	if !ok {
+		return nil, &ExprError{Pos: op.pos, Msg: "NEAR must follow a word or phrase"}
+	}
+	y, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	yt, ok := y.(*termNode)
+	if !ok {
+		return nil, &ExprError{Pos: op.pos, Msg: "NEAR must be followed by a word or phrase"}
+	}
+	if tok := p.peek(); tok.kind == exprNear {
+		return nil, &ExprError{Pos: tok.pos, Msg: "NEAR cannot be chained, join the pairs with AND"}
+	}
+	return &nearNode{x: xt, y: yt, distance: op.distance}, nil
+}
+
+// primary parses ( or ) | term
+func (p *exprParser) primary() (exprNode, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case exprLParen:
+		p.take()
+		if err := p.enter(tok); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if end := p.take(); end.kind != exprRParen {
+			if end.kind == exprEOF {
+				return nil, &ExprError{Pos: tok.pos, Msg: `unclosed "("`}
+			}
+			return nil, p.unexpected(end)
+		}
+		return x, nil
+	case exprWord:
+		return p.term()
+	}
+	return nil, p.unexpected(tok)
+}
+
+// term parses a quoted phrase, or bare words up to the next operator,
+// parenthesis or quoted phrase
+func (p *exprParser) term() (exprNode, error) {
+	first := p.take()
+	words := []string{first.text}
+	if !first.quoted {
+		for next := p.peek(); next.kind == exprWord && !next.quoted && !next.stem; next = p.peek() {
+			words = append(words, p.take().text)
+		}
+	}
+
+	text := strings.Join(words, " ")
+	n := &termNode{text: text, stem: first.stem, tokens: splitWords(p.fold(text))}
+	if len(n.tokens) == 0 {
+		return nil, &ExprError{Pos: first.pos, Msg: fmt.Sprintf("%q has no words to look for", text)}
+	}
+	if n.stem {
+		for i, w := range n.tokens {
+			n.tokens[i] = stem(w)
+		}
+	}
+	return n, nil
+}
+
+// enter descends into a parenthesis or NOT, refusing to nest too deeply
+func (p *exprParser) enter(tok exprToken) error {
+	p.depth++
+	if p.depth > maxExprDepth {
+		return &ExprError{Pos: tok.pos, Msg: "expression nests too deeply"}
+	}
+	return nil
+}
+
+func (p *exprParser) leave() {
+	p.depth--
+}
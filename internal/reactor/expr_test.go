@@ -0,0 +1,147 @@
+package reactor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"airdrop", `"airdrop"`},
+		{"free airdrop", `"free airdrop"`},
+		{`"free  airdrop"`, `"free  airdrop"`},
+		{"~Взлом", `~"Взлом"`},
+		{"a OR b AND c", `"a" OR "b" AND "c"`},
+		{"(a OR b) AND c", `("a" OR "b") AND "c"`},
+		{"a AND (b AND c)", `"a" AND ("b" AND "c")`},
+		{"NOT a AND b", `NOT "a" AND "b"`},
+		{"NOT (a AND b)", `NOT ("a" AND "b")`},
+		{"NOT NOT a", `NOT NOT "a"`},
+		{"(airdrop OR claim) AND NOT scam NEAR/5 wallet", `("airdrop" OR "claim") AND NOT "scam" NEAR/5 "wallet"`},
+		{`"seed phrase" NEAR/3 ~send`, `"seed phrase" NEAR/3 ~"send"`},
+		{"and or not", `"and or not"`},
+		{`"AND"`, `"AND"`},
+	}
+	for _, tt := range tests {
+		n, err := parseExpr(tt.src, strings.ToLower)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %v", tt.src, err)
+			continue
+		}
+		if got := n.String(); got != tt.want {
+			t.Errorf("parseExpr(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{"", 1, "unexpected end of expression"},
+		{"a AND", 6, "unexpected end of expression"},
+		{"AND a", 1, "unexpected AND"},
+		{"(a OR b", 1, `unclosed "("`},
+		{"a OR b)", 7, `unexpected ")"`},
+		{`"a" b`, 5, `unexpected "b"`},
+		{`a "b`, 3, "unterminated quote"},
+		{"~ a", 1, "~ must be followed by a word or phrase"},
+		{"a NEAR/0 b", 3, "NEAR needs a distance from 1 to 1000, as in NEAR/5"},
+		{"a NEAR/x b", 3, "NEAR needs a distance from 1 to 1000, as in NEAR/5"},
+		{"(a OR b) NEAR/2 c", 10, "NEAR must follow a word or phrase"},
+		{"a NEAR/2 NOT b", 10, "unexpected NOT"},
+		{"a NEAR/2 (b OR c)", 3, "NEAR must be followed by a word or phrase"},
+		{"a NEAR/2 b NEAR/2 c", 12, "NEAR cannot be chained, join the pairs with AND"},
+		{"взлом AND !!!", 11, `"!!!" has no words to look for`},
+		{strings.Repeat("(", maxExprDepth+1) + "a" + strings.Repeat(")", maxExprDepth+1), maxExprDepth + 1, "expression nests too deeply"},
+	}
+	for _, tt := range tests {
+		_, err := parseExpr(tt.src, strings.ToLower)
+		var exprErr *ExprError
+		if !errors.As(err, &exprErr) {
+			t.Errorf("parseExpr(%q) error = %v, want ExprError", tt.src, err)
+			continue
+		}
+		if exprErr.Pos != tt.pos || exprErr.Msg != tt.msg {
+			t.Errorf("parseExpr(%q) error = %q at %d, want %q at %d", tt.src, exprErr.Msg, exprErr.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestEvalExpr(t *testing.T) {
+	const expr = "(airdrop OR claim) AND NOT scam NEAR/5 wallet"
+	tests := []struct {
+		expr string
+		text string
+		want bool
+	}{
+		{expr, "Free airdrop today", true},
+		{expr, "Claim your tokens", true},
+		{expr, "Airdrops everywhere", false},
+		{expr, "Airdrop: this scam drains your wallet", false},
+		{expr, "Airdrop scam, connect wallet", false},
+		{expr, "Airdrop wallet scam", false},
+		{expr, "Airdrop scam! Never, ever, ever share the wallet", true},
+		{"free airdrop", "a free airdrop", true},
+		{"free airdrop", "free the airdrop", false},
+		{"~взлом AND ~кошелек", "Взломали кошелек", true},
+		{"~взлом AND ~кошелек", "Взлом сайта", false},
+		{`"seed phrase" NEAR/2 send`, "send your seed phrase", true},
+		{`"seed phrase" NEAR/2 send`, "seed phrase, then send", true},
+		{`"seed phrase" NEAR/2 send`, "seed phrase, and then send", false},
+		{"NOT a OR b", "b", true},
+		{"NOT a OR b", "a", false},
+	}
+	for _, tt := range tests {
+		n, err := parseExpr(tt.expr, Normalize)
+		if err != nil {
+			t.Fatalf("parseExpr(%q): %v", tt.expr, err)
+		}
+		if got := n.eval(newTokenIndex(Normalize(tt.text))); got != tt.want {
+			t.Errorf("%s on %q = %v, want %v", tt.expr, tt.text, got, tt.want)
+		}
+	}
+}
+
+func FuzzParseExpr(f *testing.F) {
+	for _, src := range []string{
+		"(airdrop OR claim) AND NOT scam NEAR/5 wallet",
+		`"seed phrase" NEAR/3 ~send`,
+		"NOT NOT (a AND (b OR c))",
+		"~взлом AND ~\"кошелек\"",
+		"a NEAR/2 b NEAR/2 c",
+		`"unterminated`,
+		"((((",
+	} {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		n, err := parseExpr(src, Normalize)
+		if err != nil {
+			var exprErr *ExprError
+			if !errors.As(err, &exprErr) {
+				t.Fatalf("parseExpr(%q) error %v is not an ExprError", src, err)
+			}
+			if exprErr.Pos < 1 || exprErr.Pos > len([]rune(src))+1 {
+				t.Fatalf("parseExpr(%q) error position %d out of range", src, exprErr.Pos)
+			}
+			return
+		}
+
+		// The rendering reads back to the same tree
+		again, err := parseExpr(n.String(), Normalize)
+		if err != nil {
+			t.Fatalf("parseExpr(%q) = %s, which does not parse: %v", src, n, err)
+		}
+		if again.String() != n.String() {
+			t.Fatalf("parseExpr(%q) = %s, read back as %s", src, n, again)
+		}
+		n.eval(newTokenIndex(Normalize(src)))
+	})
+}
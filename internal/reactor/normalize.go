@@ -0,0 +1,93 @@
+package reactor
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// homoglyphs maps lowercase Cyrillic letters to the Latin letters they look
+// like in either case, so a word typed with letters of both scripts folds to
+// one spelling. Folding always goes to Latin; phrases and messages are
+// folded the same way, so a Cyrillic phrase still matches Cyrillic text.
+var homoglyphs = map[rune]rune{
+	'а': 'a',
+	'в': 'b',
+	'е': 'e',
+	'ё': 'e', // ё is folded to е first, as most writers use е for both
+	'і': 'i',
+	'ј': 'j',
+	'к': 'k',
+	'м': 'm',
+	'н': 'h',
+	'о': 'o',
+	'р': 'p',
+	'с': 'c',
+	'ѕ': 's',
+	'т': 't',
+	'у': 'y',
+	'х': 'x',
+	'ԁ': 'd',
+	'һ': 'h',
+	'ԛ': 'q',
+	'ԝ': 'w',
+}
+
+// Normalize folds text to the form normalized triggers are matched in:
+// NFKC (fullwidth and styled letters become plain ones), lowercase, ё as е,
+// Cyrillic homoglyphs as Latin, without zero-width and other invisible
+// formatting characters, without emoji, with runs of the same punctuation
+// mark and of whitespace collapsed to one.
+func Normalize(text string) string {
+	text = norm.NFKC.String(text)
+
+	var sb strings.Builder
+	sb.Grow(len(text))
+	last := ' '
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Cf, r) || isEmoji(r):
+			continue
+		case unicode.IsSpace(r):
+			if last == ' ' {
+				continue
+			}
+			r = ' '
+		default:
+			r = unicode.ToLower(r)
+			if folded, ok := homoglyphs[r]; ok {
+				r = folded
+			}
+			if r == last && unicode.IsPunct(r) {
+				continue
+			}
+		}
+		sb.WriteRune(r)
+		last = r
+	}
+	return strings.TrimSuffix(sb.String(), " ")
+}
+
+// isEmoji reports whether r is a pictograph or a character that only
+// modifies one, e.g. a variation selector or skin tone
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF:
+		// Pictographs, emoticons, transport, flags and skin tones
+		return true
+	case r >= 0x2600 && r <= 0x27BF:
+		// Miscellaneous symbols and dingbats
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF:
+		// Miscellaneous symbols and arrows, e.g. ⭐
+		return true
+	case r >= 0x2300 && r <= 0x23FF:
+		// Miscellaneous technical, e.g. ⌛ and ⏰
+		return true
+	case r >= 0xFE00 && r <= 0xFE0F, r == 0x20E3:
+		// Variation selectors and the keycap mark
+		return true
+	}
+	return false
+}
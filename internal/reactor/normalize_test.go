@@ -0,0 +1,106 @@
+package reactor
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"telemonitor/internal/database"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"empty", "", ""},
+		{"plain", "free airdrop", "free airdrop"},
+		{"case", "Free AIRDROP", "free airdrop"},
+		{"fullwidth", "ＡＩＲＤＲＯＰ", "airdrop"},
+		{"mathematical bold", "𝐚𝐢𝐫𝐝𝐫𝐨𝐩", "airdrop"},
+		{"circled", "ⓐⓘⓡⓓⓡⓞⓟ", "airdrop"},
+		{"ligature", "ﬁnance", "finance"},
+		{"decomposed", "café", "café"},
+		{"yo", "Ёлка ещё", "eлka eщe"},
+		{"yo decomposed", "ё", "e"},
+		{"cyrillic homoglyphs in latin", "аirdrор", "airdrop"},
+		{"latin homoglyphs in cyrillic", "клaим", "kлaиm"},
+		{"cyrillic", "Раздача токенов", "paздaчa tokehob"},
+		{"zero-width space", "air\u200Bdrop", "airdrop"},
+		{"zero-width joiner", "air\u200Ddrop", "airdrop"},
+		{"soft hyphen", "air\u00ADdrop", "airdrop"},
+		{"byte order mark", "\uFEFFairdrop", "airdrop"},
+		{"bidi override", "\u202Eairdrop\u202C", "airdrop"},
+		{"emoji", "🚀 airdrop 🚀", "airdrop"},
+		{"emoji between letters", "air🔥drop", "airdrop"},
+		{"emoji with variation selector", "☀\uFE0F sun", "sun"},
+		{"skin tone", "👍🏽 ok", "ok"},
+		{"flag", "🇺🇸 usa", "usa"},
+		{"keycap", "1\uFE0F\u20E3 first", "1 first"},
+		{"repeated punctuation", "claim!!! now???", "claim! now?"},
+		{"repeated dots", "wait......", "wait."},
+		{"mixed punctuation kept", "what?!", "what?!"},
+		{"repeated letters kept", "sooo good", "sooo good"},
+		{"whitespace", "  free \t\n airdrop  ", "free airdrop"},
+		{"non-breaking space", "free\u00A0airdrop", "free airdrop"},
+		{"emoji between spaces", "free 🎁 airdrop", "free airdrop"},
+		{"only emoji", "🎁🎁", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.text); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeIdempotent(t *testing.T) {
+	for _, text := range []string{"Ёлка!!", "ＡＩＲ drop 🚀", "аirdrор\u200B", "𝐚 .. b", benchMessage} {
+		once := Normalize(text)
+		if twice := Normalize(once); twice != once {
+			t.Errorf("Normalize(%q) = %q, normalized again %q", text, once, twice)
+		}
+	}
+}
+
+func TestMatchNormalized(t *testing.T) {
+	triggers := []*database.Trigger{
+		{ID: 1, Phrase: "airdrop", Normalize: true},
+		{ID: 2, Phrase: "airdrop"},
+		{ID: 3, Phrase: "ёлка", Normalize: true},
+		{ID: 4, Phrase: "раздача", Normalize: true},
+		{ID: 5, Phrase: `\bairdrop\b`, MatchMode: database.MatchRegex, Normalize: true},
+		{ID: 6, Phrase: `(?i)airdrop`, MatchMode: database.MatchRegex},
+		// Normalizes to nothing, so matched raw
+		{ID: 7, Phrase: "🎁", Normalize: true},
+	}
+	tests := []struct {
+		name string
+		text string
+		want []int
+	}{
+		{"plain", "Free airdrop", []int{1, 2, 5, 6}},
+		{"fullwidth", "Free ＡＩＲＤＲＯＰ", []int{1, 5}},
+		{"homoglyphs", "Free аirdrор", []int{1, 5}},
+		{"zero-width", "Free air\u200Bdrop", []int{1, 5}},
+		{"emoji inside", "Free air🎁drop", []int{1, 5, 7}},
+		{"yo written as e", "Елка", []int{3}},
+		{"mixed script", "Рaздaчa", []int{4}},
+		{"no match", "Hello", nil},
+	}
+	m := compileAll(triggers).message
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []int
+			for _, ru := range m.match(tt.text) {
+				ids = append(ids, ru.trigger.ID)
+			}
+			sort.Ints(ids)
+			if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+				t.Errorf("match(%q) = %v, want %v", tt.text, ids, tt.want)
+			}
+		})
+	}
+}
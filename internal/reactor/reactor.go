@@ -0,0 +1,488 @@
+package reactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+)
+
+const (
+	// refreshInterval is how often triggers are reloaded from the database
+	refreshInterval = 5 * time.Minute
+	// maxAlertText is how much of the message text an alert quotes
+	maxAlertText = 1000
+
+	// minListenerRetry and maxListenerRetry bound the delay between attempts
+	// to reconnect the trigger listener
+	minListenerRetry = time.Second
+	maxListenerRetry = time.Minute
+	// listenerPingInterval is how often an idle listener connection is
+	// checked
+	listenerPingInterval = 90 * time.Second
+)
+
+// rule is a trigger prepared for matching
+type rule struct {
+	trigger *database.Trigger
+	// phrase is the pattern of a literal trigger: for an exact match the
+	// lowercased phrase, normalized if normalize is set; for a word or stem
+	// match its words or their stems joined by joinTokens. Empty for a regex,
+	// an expression or a trigger matching any message.
+	phrase string
+	re     *regexp.Regexp
+	expr   exprNode
+	// normalize matches the rule against the normalized text
+	normalize bool
+}
+
+// matcher finds the rules matching a text. Rules are matched against the raw
+// or the normalized text as their triggers ask; the text is normalized only
+// if some rule needs it.
+type matcher struct {
+	raw        textMatcher
+	normalized textMatcher
+	// always are the rules with an empty phrase
+	always []*rule
+}
+
+// textMatcher matches the rules of one form of the text. Literal phrases
+// of each match mode are matched together by one Aho-Corasick automaton:
+// exact phrases over the lowercased text, word and stem phrases over its
+// words or their stems joined by joinTokens. Regexps and expressions are
+// matched one by one, expressions over the same words and stems.
+type textMatcher struct {
+	exact   literals
+	words   literals
+	stems   literals
+	regexps []*rule
+	exprs   []*rule
+}
+
+// literals are the rules of one match mode matched by an automaton
+type literals struct {
+	automaton *automaton
+	// byPhrase holds the rules of each automaton pattern, as several
+	// triggers may share a phrase
+	byPhrase [][]*rule
+}
+
+// newMatcher compiles rules into a matcher
+func newMatcher(rules []*rule) *matcher {
+	m := &matcher{}
+	var raw, normalized []*rule
+	for _, ru := range rules {
+		switch {
+		case ru.re == nil && ru.expr == nil && ru.phrase == "":
+			m.always = append(m.always, ru)
+		case ru.normalize:
+			normalized = append(normalized, ru)
+		default:
+			raw = append(raw, ru)
+		}
+	}
+	m.raw = newTextMatcher(raw)
+	m.normalized = newTextMatcher(normalized)
+	return m
+}
+
+func newTextMatcher(rules []*rule) textMatcher {
+	var tm textMatcher
+	byMode := make(map[string][]*rule)
+	for _, ru := range rules {
+		switch {
+		case ru.re != nil:
+			tm.regexps = append(tm.regexps, ru)
+			continue
+		case ru.expr != nil:
+			tm.exprs = append(tm.exprs, ru)
+			continue
+		}
+		byMode[ru.trigger.MatchMode] = append(byMode[ru.trigger.MatchMode], ru)
+	}
+	// A trigger without a mode matches exactly, like the column default
+	tm.exact = newLiterals(append(byMode[database.MatchExact], byMode[""]...))
+	tm.words = newLiterals(byMode[database.MatchWord])
+	tm.stems = newLiterals(byMode[database.MatchStem])
+	return tm
+}
+
+func newLiterals(rules []*rule) literals {
+	var l literals
+	index := make(map[string]int)
+	var phrases []string
+	for _, ru := range rules {
+		i, ok := index[ru.phrase]
+		if !ok {
+			i = len(phrases)
+			index[ru.phrase] = i
+			phrases = append(phrases, ru.phrase)
+			l.byPhrase = append(l.byPhrase, nil)
+		}
+		l.byPhrase[i] = append(l.byPhrase[i], ru)
+	}
+	l.automaton = newAutomaton(phrases)
+	return l
+}
+
+// empty reports whether l has no rules
+func (l *literals) empty() bool {
+	return len(l.byPhrase) == 0
+}
+
+// match appends the rules whose phrase occurs in text to matched
+func (l *literals) match(matched []*rule, text string) []*rule {
+	l.automaton.match(text, func(i int) {
+		matched = append(matched, l.byPhrase[i]...)
+	})
+	return matched
+}
+
+// empty reports whether tm has no rules
+func (tm *textMatcher) empty() bool {
+	return tm.exact.empty() && tm.words.empty() && tm.stems.empty() && len(tm.regexps) == 0 && len(tm.exprs) == 0
+}
+
+// match appends the rules matching text to matched; lower is text
+// lowercased, which the literals are matched against
+func (tm *textMatcher) match(matched []*rule, text, lower string) []*rule {
+	matched = tm.exact.match(matched, lower)
+	if !tm.words.empty() || !tm.stems.empty() || len(tm.exprs) > 0 {
+		tokens := newTokenIndex(lower)
+		if !tm.words.empty() {
+			matched = tm.words.match(matched, joinTokens(tokens.words))
+		}
+		if !tm.stems.empty() {
+			matched = tm.stems.match(matched, joinTokens(tokens.Stems()))
+		}
+		for _, ru := range tm.exprs {
+			if ru.expr.eval(tokens) {
+				matched = append(matched, ru)
+			}
+		}
+	}
+	for _, ru := range tm.regexps {
+		if ru.re.MatchString(text) {
+			matched = append(matched, ru)
+		}
+	}
+	return matched
+}
+
+// match returns the rules matching text, each once
+func (m *matcher) match(text string) []*rule {
+	matched := append([]*rule(nil), m.always...)
+	if !m.raw.empty() {
+		matched = m.raw.match(matched, text, strings.ToLower(text))
+	}
+	if !m.normalized.empty() {
+		normalized := Normalize(text)
+		matched = m.normalized.match(matched, normalized, normalized)
+	}
+	return matched
+}
+
+// ruleset is an immutable compiled set of triggers, replaced as a whole
+// when the triggers change
+type ruleset struct {
+	message *matcher
+	deleted *matcher
+}
+
+// Reactor matches messages against the triggers and alerts the admin. It
+// implements ingestion.Reactor. Triggers are recompiled when a trigger write
+// is announced with NOTIFY, see Watch, and every refreshInterval in case a
+// notification is lost.
+type Reactor struct {
+	triggers *repository.TriggerRepository
+	chats    *repository.MonitoredChatRepository
+	senders  *repository.SenderRepository
+	notify   func(text string)
+
+	rules atomic.Pointer[ruleset]
+}
+
+// New creates a Reactor. Call Refresh before messages arrive.
+func New(triggers *repository.TriggerRepository, chats *repository.MonitoredChatRepository, senders *repository.SenderRepository) *Reactor {
+	r := &Reactor{
+		triggers: triggers,
+		chats:    chats,
+		senders:  senders,
+	}
+	r.rules.Store(compileAll(nil))
+	return r
+}
+
+// SetNotifier sets the function alerts are sent through. It must be called
+// before Run.
+func (r *Reactor) SetNotifier(notify func(text string)) {
+	r.notify = notify
+}
+
+// Run reloads the triggers periodically until ctx is cancelled
+func (r *Reactor) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Refresh(); err != nil {
+				log.Printf("Failed to refresh triggers: %v", err)
+			}
+		}
+	}
+}
+
+// Watch listens for the notifications sent on trigger writes and reloads
+// the triggers on each until ctx is cancelled. The connection is
+// re-established when lost, and the triggers reloaded in case a change was
+// missed meanwhile.
+func (r *Reactor) Watch(ctx context.Context, dsn string) {
+	listener := pq.NewListener(dsn, minListenerRetry, maxListenerRetry, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Trigger listener: %v", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(repository.TriggersChannel); err != nil {
+		log.Printf("Failed to listen for trigger changes, relying on periodic refresh: %v", err)
+		return
+	}
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+			// nil after a reconnect, when changes may have been missed
+			if err := r.Refresh(); err != nil {
+				log.Printf("Failed to refresh triggers: %v", err)
+			}
+		case <-ticker.C:
+			go listener.Ping()
+		}
+	}
+}
+
+// Refresh reloads the triggers. It is called on notifications and
+// periodically, and may be called after a trigger change to apply it
+// immediately.
+func (r *Reactor) Refresh() error {
+	triggers, err := r.triggers.GetAll()
+	if err != nil {
+		return err
+	}
+	r.rules.Store(compileAll(triggers))
+	return nil
+}
+
+// compileAll compiles triggers into a ruleset, skipping invalid ones
+func compileAll(triggers []*database.Trigger) *ruleset {
+	var message, deleted []*rule
+	for _, t := range triggers {
+		ru, err := compile(t)
+		if err != nil {
+			log.Printf("Skipping trigger %d: %v", t.ID, err)
+			continue
+		}
+		if t.Event == database.TriggerOnDeleted {
+			deleted = append(deleted, ru)
+		} else {
+			message = append(message, ru)
+		}
+	}
+	return &ruleset{message: newMatcher(message), deleted: newMatcher(deleted)}
+}
+
+// compile prepares a trigger for matching. A normalized literal phrase is
+// normalized like the text; one normalizing to nothing, e.g. a lone emoji,
+// is matched against the raw text instead so it does not match everything.
+func compile(t *database.Trigger) (*rule, error) {
+	switch t.MatchMode {
+	case database.MatchRegex:
+		re, err := regexp.Compile(t.Phrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		return &rule{trigger: t, re: re, normalize: t.Normalize}, nil
+	case database.MatchExpression:
+		return compileExpr(t)
+	case database.MatchExact, database.MatchWord, database.MatchStem, "":
+	default:
+		return nil, fmt.Errorf("unknown match mode %q", t.MatchMode)
+	}
+
+	ru := &rule{trigger: t, phrase: strings.ToLower(strings.TrimSpace(t.Phrase))}
+	if t.Normalize {
+		if normalized := Normalize(ru.phrase); normalized != "" {
+			ru.phrase, ru.normalize = normalized, true
+		}
+	}
+	if ru.phrase == "" {
+		return ru, nil
+	}
+
+	switch t.MatchMode {
+	case database.MatchWord, database.MatchStem:
+		tokens := newTokenIndex(ru.phrase)
+		if len(tokens.words) == 0 {
+			return nil, fmt.Errorf("%s match needs a phrase with words", t.MatchMode)
+		}
+		if t.MatchMode == database.MatchWord {
+			ru.phrase = joinTokens(tokens.words)
+		} else {
+			ru.phrase = joinTokens(tokens.Stems())
+		}
+	}
+	return ru, nil
+}
+
+// compileExpr parses the expression of a trigger, with its words folded
+// like the text it is matched against
+func compileExpr(t *database.Trigger) (*rule, error) {
+	fold := strings.ToLower
+	if t.Normalize {
+		fold = Normalize
+	}
+	expr, err := parseExpr(t.Phrase, fold)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+	if expr.eval(newTokenIndex("")) {
+		return nil, errors.New("invalid expression: it matches messages without any of its words, add one to look for")
+	}
+	return &rule{trigger: t, expr: expr, normalize: t.Normalize}, nil
+}
+
+// Check reports whether a trigger can be matched, e.g. that its regex
+// compiles
+func Check(t *database.Trigger) error {
+	_, err := compile(t)
+	return err
+}
+
+// React alerts on the message triggers matching a new or edited message
+// within their scope
+func (r *Reactor) React(ctx context.Context, msg *database.RawMessage) error {
+	if !msg.MessageText.Valid {
+		return nil
+	}
+
+	for _, ru := range r.rules.Load().message.match(msg.MessageText.String) {
+		if ru.trigger.Scope.Allows(msg) {
+			r.alert(ru.trigger, msg, false)
+		}
+	}
+	return nil
+}
+
+// ReactDeleted alerts on the deletion triggers matching a message that was
+// deleted within their time window and scope. A replayed deletion was only
+// noticed by catch-up, possibly long after it happened, so its time window
+// cannot be checked and it matches regardless.
+func (r *Reactor) ReactDeleted(ctx context.Context, msg *database.RawMessage, replayed bool) error {
+	if !msg.DeletedAt.Valid {
+		return nil
+	}
+	lifetime := msg.DeletedAt.Time.Sub(msg.CreatedAt)
+
+	for _, ru := range r.rules.Load().deleted.match(msg.MessageText.String) {
+		within := time.Duration(ru.trigger.WithinMinutes.Int64) * time.Minute
+		if (replayed || lifetime <= within) && ru.trigger.Scope.Allows(msg) {
+			r.alert(ru.trigger, msg, replayed)
+		}
+	}
+	return nil
+}
+
+// alert sends a notification about a matched trigger; replayed marks a
+// deletion whose time is not known
+func (r *Reactor) alert(t *database.Trigger, msg *database.RawMessage, replayed bool) {
+	if r.notify == nil {
+		return
+	}
+	r.notify(r.format(t, msg, replayed))
+}
+
+func (r *Reactor) format(t *database.Trigger, msg *database.RawMessage, replayed bool) string {
+	var sb strings.Builder
+
+	icon := "ℹ️"
+	switch t.AlertLevel {
+	case "warning":
+		icon = "⚠️"
+	case "critical":
+		icon = "🚨"
+	}
+	phrase := t.Phrase
+	if phrase == "" {
+		phrase = "*"
+	}
+	fmt.Fprintf(&sb, "%s Trigger #%d %q", icon, t.ID, phrase)
+	switch {
+	case t.Event == database.TriggerOnDeleted && replayed:
+		fmt.Fprintf(&sb, " (deleted within %s, found by catch-up)", formatLifetime(msg.DeletedAt.Time.Sub(msg.CreatedAt)))
+	case t.Event == database.TriggerOnDeleted:
+		fmt.Fprintf(&sb, " (deleted after %s)", formatLifetime(msg.DeletedAt.Time.Sub(msg.CreatedAt)))
+	case msg.EditedAt.Valid:
+		sb.WriteString(" (edited)")
+	}
+	sb.WriteString("\n")
+
+	chat := fmt.Sprintf("%d", msg.ChatID)
+	if c, err := r.chats.GetByChatID(msg.ChatID); err == nil && c != nil && c.Title.Valid {
+		chat = fmt.Sprintf("%s (%d)", c.Title.String, msg.ChatID)
+	}
+	fmt.Fprintf(&sb, "Chat: %s\n", chat)
+	if from := r.sender(msg); from != "" {
+		fmt.Fprintf(&sb, "From: %s\n", from)
+	}
+	fmt.Fprintf(&sb, "Message: %d at %s\n", msg.TelegramMsgID, msg.CreatedAt.Format("2006-01-02 15:04"))
+	if msg.Media.Valid() {
+		fmt.Fprintf(&sb, "Media: %s\n", msg.Media)
+	}
+
+	text := msg.MessageText.String
+	if len([]rune(text)) > maxAlertText {
+		text = string([]rune(text)[:maxAlertText]) + "…"
+	}
+	if text != "" {
+		sb.WriteString("\n" + text)
+	}
+	return sb.String()
+}
+
+// sender describes who wrote a message, preferring the senders directory to
+// the name stored with the message
+func (r *Reactor) sender(msg *database.RawMessage) string {
+	if msg.SenderID.Valid {
+		if s, err := r.senders.GetByID(msg.SenderID.Int64); err == nil && s != nil {
+			return s.String()
+		}
+	}
+	return msg.SenderName.String
+}
+
+// formatLifetime renders how long a message existed, e.g. "3m" or "2h 5m"
+func formatLifetime(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+}
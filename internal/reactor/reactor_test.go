@@ -0,0 +1,160 @@
+package reactor
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"telemonitor/internal/database"
+)
+
+// benchMessage is a typical chat message of a few hundred characters
+const benchMessage = `Привет всем! Сегодня запускаем новый airdrop для держателей токена,
+claim доступен до пятницы. Подробности в закреплённом сообщении, ссылка на форму ниже.
+Не переходите по ссылкам из личных сообщений — админы никогда не пишут первыми.
+Hi everyone, the claim window closes Friday; check the pinned post for the wallet checklist.`
+
+// randomPhrases returns n distinct phrases of one to three words of
+// Latin or Cyrillic letters, none of which occurs in benchMessage
+func randomPhrases(n int) []string {
+	rng := rand.New(rand.NewSource(1))
+	alphabets := [][]rune{
+		[]rune("abcdefghijklmnopqrstuvwxyz"),
+		[]rune("абвгдежзийклмнопрстуфхцчшщыэюя"),
+	}
+	seen := make(map[string]bool)
+	var phrases []string
+	for len(phrases) < n {
+		letters := alphabets[rng.Intn(len(alphabets))]
+		words := make([]string, 1+rng.Intn(3))
+		for i := range words {
+			w := make([]rune, 4+rng.Intn(6))
+			for j := range w {
+				w[j] = letters[rng.Intn(len(letters))]
+			}
+			words[i] = string(w)
+		}
+		p := strings.Join(words, " ")
+		if seen[p] || strings.Contains(strings.ToLower(benchMessage), p) {
+			continue
+		}
+		seen[p] = true
+		phrases = append(phrases, p)
+	}
+	return phrases
+}
+
+// benchMatcher compiles n literal triggers, a few of which match
+// benchMessage, and a handful of regex triggers
+func benchMatcher(n int) *matcher {
+	phrases := randomPhrases(n)
+	copy(phrases, []string{"airdrop", "claim", "закреплённом", "wallet"})
+
+	var rules []*rule
+	for i, p := range phrases {
+		ru, err := compile(&database.Trigger{ID: i + 1, Phrase: p})
+		if err != nil {
+			panic(err)
+		}
+		rules = append(rules, ru)
+	}
+	for i, expr := range []string{`(?i)seed\s+phrase`, `t\.me/\+\w+`, `(?i)\bclaim\b.*\bfriday\b`} {
+		ru, err := compile(&database.Trigger{ID: n + i + 1, Phrase: expr, MatchMode: database.MatchRegex})
+		if err != nil {
+			panic(err)
+		}
+		rules = append(rules, ru)
+	}
+	return newMatcher(rules)
+}
+
+func TestAutomatonMatchesLikeContains(t *testing.T) {
+	patterns := append(randomPhrases(200), "a", "ab", "bab", "abab", "при", "привет", "вет", "ё")
+	texts := append([]string{"", "ababab", "приветик", "ёлка", strings.ToLower(benchMessage)}, patterns[:50]...)
+	a := newAutomaton(patterns)
+
+	for _, text := range texts {
+		var got []int
+		a.match(text, func(i int) { got = append(got, i) })
+		sort.Ints(got)
+
+		var want []int
+		for i, p := range patterns {
+			if strings.Contains(text, p) {
+				want = append(want, i)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("match(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestMatcherSharedPhrases(t *testing.T) {
+	var rules []*rule
+	for i, phrase := range []string{"Airdrop", "airdrop ", "", "/wallet|seed/"} {
+		tr := &database.Trigger{ID: i + 1, Phrase: phrase}
+		if strings.HasPrefix(phrase, "/") {
+			tr.Phrase, tr.MatchMode = strings.Trim(phrase, "/"), database.MatchRegex
+		}
+		ru, err := compile(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, ru)
+	}
+
+	var ids []int
+	for _, ru := range newMatcher(rules).match("Free AIRDROP, bring your wallet") {
+		ids = append(ids, ru.trigger.ID)
+	}
+	sort.Ints(ids)
+	if fmt.Sprint(ids) != "[1 2 3 4]" {
+		t.Errorf("matched triggers %v, want [1 2 3 4]", ids)
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		m := benchMatcher(n)
+		b.Run(fmt.Sprintf("triggers=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(benchMessage)))
+			for i := 0; i < b.N; i++ {
+				if len(m.match(benchMessage)) == 0 {
+					b.Fatal("no trigger matched")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMatchNaive is the one-strings.Contains-per-trigger baseline the
+// automaton replaces
+func BenchmarkMatchNaive(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		phrases := randomPhrases(n)
+		b.Run(fmt.Sprintf("triggers=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(benchMessage)))
+			for i := 0; i < b.N; i++ {
+				text := strings.ToLower(benchMessage)
+				for _, p := range phrases {
+					_ = strings.Contains(text, p)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	phrases := randomPhrases(10000)
+	triggers := make([]*database.Trigger, len(phrases))
+	for i, p := range phrases {
+		triggers[i] = &database.Trigger{ID: i + 1, Phrase: p}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compileAll(triggers)
+	}
+}
@@ -0,0 +1,147 @@
+package reactor
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/snowballstem"
+	"github.com/blevesearch/snowballstem/english"
+	"github.com/blevesearch/snowballstem/russian"
+)
+
+// tokenIndex holds the words of a text and their stems for the word, stem
+// and expression rules. Stemming is the costly part, so stems are worked
+// out only when first asked for, as are the positions expressions look
+// words up by.
+type tokenIndex struct {
+	words []string
+	stems []string
+	// wordsAt and stemsAt hold the positions of each word and stem
+	wordsAt map[string][]int
+	stemsAt map[string][]int
+}
+
+// newTokenIndex splits a lowercased or normalized text into words
+func newTokenIndex(text string) *tokenIndex {
+	return &tokenIndex{words: splitWords(text)}
+}
+
+// Stems returns the stem of every word, in order
+func (ti *tokenIndex) Stems() []string {
+	if ti.stems == nil {
+		ti.stems = make([]string, len(ti.words))
+		for i, w := range ti.words {
+			ti.stems[i] = stem(w)
+		}
+	}
+	return ti.stems
+}
+
+// occurrences returns the positions at which tokens occur in a row among
+// the words, or among the stems if stems is set
+func (ti *tokenIndex) occurrences(tokens []string, stems bool) []int {
+	if len(tokens) == 0 {
+		return nil
+	}
+	seq, at := ti.words, &ti.wordsAt
+	if stems {
+		seq, at = ti.Stems(), &ti.stemsAt
+	}
+	if *at == nil {
+		*at = make(map[string][]int)
+		for i, tok := range seq {
+			(*at)[tok] = append((*at)[tok], i)
+		}
+	}
+
+	var found []int
+next:
+	for _, i := range (*at)[tokens[0]] {
+		if i+len(tokens) > len(seq) {
+			break
+		}
+		for j := 1; j < len(tokens); j++ {
+			if seq[i+j] != tokens[j] {
+				continue next
+			}
+		}
+		found = append(found, i)
+	}
+	return found
+}
+
+// joinTokens joins tokens with a space before, between and after them, so
+// that a phrase joined the same way occurs in it exactly where its tokens
+// occur in a row
+func joinTokens(tokens []string) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	return " " + strings.Join(tokens, " ") + " "
+}
+
+// splitWords splits text into runs of letters, digits and combining marks
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+	})
+}
+
+// stem reduces a lowercase word to its Snowball stem, with the Russian
+// stemmer if it has a Cyrillic letter and the English one otherwise. A
+// Russian word made only of letters with Latin twins, e.g. "сорок", reads
+// as Latin once normalized and is stemmed as English; a normalized phrase
+// goes the same way, so it still matches that exact form.
+func stem(word string) string {
+	if !hasCyrillic(word) {
+		env := snowballstem.NewEnv(word)
+		english.Stem(env)
+		return env.Current()
+	}
+	// Snowball keeps the а or я a Russian verb ending follows, as in
+	// взломали → взлома, so the stem is stemmed again to reach the one of
+	// the noun
+	env := snowballstem.NewEnv(toCyrillic(word))
+	russian.Stem(env)
+	russian.Stem(env)
+	return env.Current()
+}
+
+func hasCyrillic(word string) bool {
+	for _, r := range word {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// latinHomoglyphs maps the Latin letters that look like Russian ones back
+// to them, undoing the homoglyph folding of Normalize and mixed-script
+// spelling in a Russian word so its endings are recognised
+var latinHomoglyphs = map[rune]rune{
+	'a': 'а',
+	'b': 'в',
+	'c': 'с',
+	'e': 'е',
+	'h': 'н',
+	'k': 'к',
+	'm': 'м',
+	'o': 'о',
+	'p': 'р',
+	't': 'т',
+	'x': 'х',
+	'y': 'у',
+	'ё': 'е',
+}
+
+// toCyrillic spells a Russian word in Cyrillic letters only, as far as
+// look-alikes allow, with ё as е
+func toCyrillic(word string) string {
+	return strings.Map(func(r rune) rune {
+		if c, ok := latinHomoglyphs[r]; ok {
+			return c
+		}
+		return r
+	}, word)
+}
@@ -0,0 +1,78 @@
+package reactor
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"telemonitor/internal/database"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"взлом", "взломали", "взломом", "взлома", "взломы"}, "взлом"},
+		{[]string{"кошелька", "кошельку", "кошельком"}, "кошельк"},
+		{[]string{"ёлка", "елки"}, "елк"},
+		{[]string{"раздача", "раздачи", "раздачу", "раздачей"}, "раздач"},
+		{[]string{"hack", "hacked", "hacking", "hacks"}, "hack"},
+		{[]string{"wallet", "wallets"}, "wallet"},
+		// Latin look-alikes in a Russian word, as after normalization
+		{[]string{"bзлomaли", "взлoмом"}, "взлом"},
+	}
+	for _, tt := range tests {
+		for _, w := range tt.words {
+			if got := stem(w); got != tt.want {
+				t.Errorf("stem(%q) = %q, want %q", w, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestMatchModes(t *testing.T) {
+	triggers := []*database.Trigger{
+		{ID: 1, Phrase: "взлом", MatchMode: database.MatchExact},
+		{ID: 2, Phrase: "взлом", MatchMode: database.MatchWord},
+		{ID: 3, Phrase: "взлом", MatchMode: database.MatchStem},
+		{ID: 4, Phrase: "Взлом кошелька", MatchMode: database.MatchStem, Normalize: true},
+		{ID: 5, Phrase: "hack", MatchMode: database.MatchWord, Normalize: true},
+		{ID: 6, Phrase: "hacked wallets", MatchMode: database.MatchStem, Normalize: true},
+	}
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"Был взлом.", []int{1, 2, 3}},
+		{"Нас взломали", []int{1, 3}},
+		{"Со взломом кошельков", []int{1, 3, 4}},
+		{"ВЗЛОМ кошелька!", []int{1, 2, 3, 4}},
+		// Stems are taken of the Cyrillic spelling, raw or not
+		{"Взлoм кoшелькa", []int{3, 4}},
+		{"Невзломанный", []int{1}},
+		{"a hack", []int{5}},
+		{"shack", nil},
+		{"They hacked two wallets", nil},
+		{"Hacking wallet!", []int{6}},
+	}
+	m := compileAll(triggers).message
+	for _, tt := range tests {
+		var ids []int
+		for _, ru := range m.match(tt.text) {
+			ids = append(ids, ru.trigger.ID)
+		}
+		sort.Ints(ids)
+		if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+			t.Errorf("match(%q) = %v, want %v", tt.text, ids, tt.want)
+		}
+	}
+}
+
+func TestCheckWordPhrase(t *testing.T) {
+	for _, mode := range []string{database.MatchWord, database.MatchStem} {
+		if err := Check(&database.Trigger{Phrase: "!!!", MatchMode: mode}); err == nil {
+			t.Errorf("%s trigger without words accepted", mode)
+		}
+	}
+}
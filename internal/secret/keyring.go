@@ -0,0 +1,164 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"telemonitor/internal/config"
+)
+
+// KeySize is the required AES-256 key length in bytes
+const KeySize = 32
+
+// magic prefixes every sealed blob; it is followed by a one byte key version,
+// the GCM nonce and the ciphertext. Legacy plaintext rows never start with it
+// because gotd sessions are JSON documents.
+var magic = []byte("tmenc")
+
+// ErrUnknownKeyVersion is returned when a blob was sealed with a key that is
+// not present in the keyring
+var ErrUnknownKeyVersion = errors.New("secret: unknown key version")
+
+// Keyring seals values with the current key and opens values sealed with
+// any configured key version, which allows keys to be rotated
+type Keyring struct {
+	current byte
+	aeads   map[byte]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from raw AES-256 keys indexed by version
+func NewKeyring(current int, keys map[int][]byte) (*Keyring, error) {
+	if current < 1 || current > 255 {
+		return nil, fmt.Errorf("key version must be between 1 and 255, got %d", current)
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("no key configured for current version %d", current)
+	}
+
+	k := &Keyring{current: byte(current), aeads: make(map[byte]cipher.AEAD, len(keys))}
+	for version, key := range keys {
+		if version < 1 || version > 255 {
+			return nil, fmt.Errorf("key version must be between 1 and 255, got %d", version)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("key version %d must be %d bytes, got %d", version, KeySize, len(key))
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher for key version %d: %w", version, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCM for key version %d: %w", version, err)
+		}
+		k.aeads[byte(version)] = aead
+	}
+
+	return k, nil
+}
+
+// LoadKeyring builds a keyring from the session encryption settings
+func LoadKeyring(cfg config.SessionEncryptionConfig) (*Keyring, error) {
+	encoded := cfg.Key
+	if encoded == "" && cfg.KeyFile != "" {
+		data, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read session key file: %w", err)
+		}
+		encoded = string(data)
+	}
+	if strings.TrimSpace(encoded) == "" {
+		return nil, fmt.Errorf("session encryption key is not configured")
+	}
+
+	current, err := decodeKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("session key: %w", err)
+	}
+
+	keys := map[int][]byte{cfg.KeyVersion: current}
+	for version, encoded := range cfg.PreviousKeys {
+		if version == cfg.KeyVersion {
+			return nil, fmt.Errorf("previous_keys must not contain the current key version %d", version)
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("previous session key %d: %w", version, err)
+		}
+		keys[version] = key
+	}
+
+	return NewKeyring(cfg.KeyVersion, keys)
+}
+
+// CurrentVersion returns the key version used for new blobs
+func (k *Keyring) CurrentVersion() int {
+	return int(k.current)
+}
+
+// Seal encrypts plaintext with the current key
+func (k *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	aead := k.aeads[k.current]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := append(append([]byte{}, magic...), k.current)
+	out := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(append(out, header...), nonce...)
+	// The header is authenticated so the version byte cannot be swapped
+	return aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// Open decrypts a sealed blob and reports the key version it was sealed with
+func (k *Keyring) Open(blob []byte) ([]byte, int, error) {
+	if !IsSealed(blob) {
+		return nil, 0, fmt.Errorf("secret: value is not sealed")
+	}
+
+	headerLen := len(magic) + 1
+	version := blob[len(magic)]
+	aead, ok := k.aeads[version]
+	if !ok {
+		return nil, int(version), fmt.Errorf("%w %d", ErrUnknownKeyVersion, version)
+	}
+
+	rest := blob[headerLen:]
+	if len(rest) < aead.NonceSize() {
+		return nil, int(version), fmt.Errorf("secret: sealed value is truncated")
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, blob[:headerLen])
+	if err != nil {
+		return nil, int(version), fmt.Errorf("secret: failed to decrypt with key version %d: %w", version, err)
+	}
+
+	return plaintext, int(version), nil
+}
+
+// IsSealed reports whether blob carries the sealed-value header
+func IsSealed(blob []byte) bool {
+	return len(blob) > len(magic) && bytes.HasPrefix(blob, magic)
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("must be base64 encoded: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("must decode to %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
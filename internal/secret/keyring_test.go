@@ -0,0 +1,181 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"telemonitor/internal/config"
+)
+
+// testKey returns a key of repeated b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func newTestKeyring(t *testing.T, current int, versions ...int) *Keyring {
+	t.Helper()
+	keys := make(map[int][]byte, len(versions))
+	for _, v := range versions {
+		keys[v] = testKey(byte(v))
+	}
+	k, err := NewKeyring(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealOpen(t *testing.T) {
+	k := newTestKeyring(t, 1, 1)
+	for _, plaintext := range [][]byte{[]byte(`{"Version":1}`), {}, bytes.Repeat([]byte("x"), 4096)} {
+		sealed, err := k.Seal(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsSealed(sealed) {
+			t.Errorf("sealed value lacks the header")
+		}
+		if len(plaintext) > 0 && bytes.Contains(sealed, plaintext) {
+			t.Errorf("sealed value contains the plaintext")
+		}
+
+		opened, version, err := k.Open(sealed)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if version != 1 || !bytes.Equal(opened, plaintext) {
+			t.Errorf("Open = %q version %d, want %q version 1", opened, version, plaintext)
+		}
+	}
+
+	// A fresh nonce every time
+	a, _ := k.Seal([]byte("same"))
+	b, _ := k.Seal([]byte("same"))
+	if bytes.Equal(a, b) {
+		t.Error("sealing the same value twice gave the same blob")
+	}
+}
+
+func TestOpenPreviousVersion(t *testing.T) {
+	old := newTestKeyring(t, 1, 1)
+	sealed, err := old.Seal([]byte("session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := newTestKeyring(t, 2, 1, 2)
+	opened, version, err := rotated.Open(sealed)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if version != 1 || string(opened) != "session" {
+		t.Errorf("Open = %q version %d, want %q version 1", opened, version, "session")
+	}
+
+	resealed, err := rotated.Seal(opened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, version, _ := rotated.Open(resealed); version != 2 {
+		t.Errorf("resealed with version %d, want 2", version)
+	}
+}
+
+func TestOpenUnknownVersion(t *testing.T) {
+	sealed, err := newTestKeyring(t, 3, 3).Seal([]byte("session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, version, err := newTestKeyring(t, 1, 1, 2).Open(sealed)
+	if !errors.Is(err, ErrUnknownKeyVersion) {
+		t.Errorf("Open error = %v, want ErrUnknownKeyVersion", err)
+	}
+	if version != 3 {
+		t.Errorf("Open version = %d, want 3", version)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	k := newTestKeyring(t, 1, 1, 2)
+	sealed, err := k.Seal([]byte("session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := len(magic) + 1
+
+	tests := []struct {
+		name   string
+		tamper func(b []byte) []byte
+	}{
+		{"ciphertext", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"nonce", func(b []byte) []byte { b[headerLen] ^= 1; return b }},
+		{"version", func(b []byte) []byte { b[len(magic)] = 2; return b }},
+		{"truncated", func(b []byte) []byte { return b[:headerLen+4] }},
+		{"wrong key", func(b []byte) []byte {
+			other, _ := NewKeyring(1, map[int][]byte{1: testKey(9)})
+			sealed, _ := other.Seal([]byte("session"))
+			return sealed
+		}},
+	}
+	for _, tt := range tests {
+		blob := tt.tamper(append([]byte{}, sealed...))
+		if _, _, err := k.Open(blob); err == nil {
+			t.Errorf("%s: tampered value opened", tt.name)
+		}
+	}
+
+	if _, _, err := k.Open([]byte(`{"Version":1}`)); err == nil {
+		t.Error("plaintext value opened")
+	}
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		current int
+		keys    map[int][]byte
+	}{
+		{"no current key", 2, map[int][]byte{1: testKey(1)}},
+		{"version zero", 0, map[int][]byte{0: testKey(1)}},
+		{"version too large", 1, map[int][]byte{1: testKey(1), 256: testKey(2)}},
+		{"short key", 1, map[int][]byte{1: testKey(1)[:16]}},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyring(tt.current, tt.keys); err == nil {
+			t.Errorf("%s: keyring created", tt.name)
+		}
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	encode := func(b byte) string { return base64.StdEncoding.EncodeToString(testKey(b)) }
+
+	k, err := LoadKeyring(config.SessionEncryptionConfig{
+		Key:          encode(2) + "\n",
+		KeyVersion:   2,
+		PreviousKeys: map[int]string{1: encode(1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.CurrentVersion() != 2 {
+		t.Errorf("current version = %d, want 2", k.CurrentVersion())
+	}
+	sealed, _ := newTestKeyring(t, 1, 1).Seal([]byte("session"))
+	if _, _, err := k.Open(sealed); err != nil {
+		t.Errorf("previous key not loaded: %v", err)
+	}
+
+	for _, cfg := range []config.SessionEncryptionConfig{
+		{KeyVersion: 1},
+		{Key: "not base64!", KeyVersion: 1},
+		{Key: base64.StdEncoding.EncodeToString([]byte("short")), KeyVersion: 1},
+		{Key: encode(1), KeyVersion: 1, PreviousKeys: map[int]string{1: encode(2)}},
+	} {
+		if _, err := LoadKeyring(cfg); err == nil {
+			t.Errorf("LoadKeyring(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package userbot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/telegram/query/dialogs"
+	"github.com/gotd/td/tg"
+)
+
+// archiveFolderID is the folder Telegram keeps archived chats in
+const archiveFolderID = 1
+
+// ChannelPeer is a channel or supergroup from the account's dialogs
+type ChannelPeer struct {
+	Input *tg.InputChannel
+	// Pts is the channel's current pts as seen by the account
+	Pts int
+	// Forum is set for supergroups organised in topics
+	Forum bool
+}
+
+// JoinPublic joins a public channel or supergroup by username
+func (c *Client) JoinPublic(ctx context.Context, username string) error {
+	if !c.Authorized() {
+		return ErrNotRunning
+	}
+
+	resolved, err := c.client.API().ContactsResolveUsername(ctx, username)
+	if err != nil {
+		return fmt.Errorf("failed to resolve @%s: %w", username, err)
+	}
+
+	for _, chat := range resolved.Chats {
+		channel, ok := chat.(*tg.Channel)
+		if !ok {
+			continue
+		}
+		if !channel.Left {
+			// Already a member
+			return nil
+		}
+
+		_, err := c.client.API().ChannelsJoinChannel(ctx, channel.AsInput())
+		if err != nil {
+			return fmt.Errorf("failed to join @%s: %w", username, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("@%s is not a channel or supergroup", username)
+}
+
+// Channels lists the channels and supergroups in the account's dialogs,
+// including archived ones, keyed by channel ID
+func (c *Client) Channels(ctx context.Context) (map[int64]ChannelPeer, error) {
+	if !c.Authorized() {
+		return nil, ErrNotRunning
+	}
+
+	channels := make(map[int64]ChannelPeer)
+	collect := func(ctx context.Context, elem dialogs.Elem) error {
+		peer, ok := elem.Peer.(*tg.InputPeerChannel)
+		if !ok {
+			return nil
+		}
+		dialog, ok := elem.Dialog.(*tg.Dialog)
+		if !ok {
+			return nil
+		}
+		pts, _ := dialog.GetPts()
+		channel, _ := elem.Entities.Channel(peer.ChannelID)
+		channels[peer.ChannelID] = ChannelPeer{
+			Input: &tg.InputChannel{ChannelID: peer.ChannelID, AccessHash: peer.AccessHash},
+			Pts:   pts,
+			Forum: channel != nil && channel.Forum,
+		}
+		return nil
+	}
+
+	if err := query.GetDialogs(c.API()).BatchSize(100).ForEach(ctx, collect); err != nil {
+		return nil, fmt.Errorf("failed to list dialogs: %w", err)
+	}
+	if err := query.GetDialogs(c.API()).FolderID(archiveFolderID).BatchSize(100).ForEach(ctx, collect); err != nil {
+		return nil, fmt.Errorf("failed to list archived dialogs: %w", err)
+	}
+	return channels, nil
+}
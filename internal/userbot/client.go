@@ -0,0 +1,358 @@
+package userbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/auth/qrlogin"
+	"github.com/gotd/td/telegram/dcs"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+
+	"telemonitor/internal/config"
+	"telemonitor/internal/ratelimit"
+)
+
+// healthCheckInterval is how often an authorized client verifies that its
+// session is still valid
+const healthCheckInterval = 5 * time.Minute
+
+// startTimeout bounds how long Login waits for the connection to come up
+const startTimeout = 30 * time.Second
+
+var (
+	// ErrNotRunning is returned when the MTProto connection is not established yet
+	ErrNotRunning = errors.New("userbot is not running")
+	// ErrLoggedOut is returned when Telegram revoked the session
+	ErrLoggedOut = errors.New("userbot session was revoked")
+	// ErrBanned is returned when the account was deactivated or banned
+	ErrBanned = errors.New("userbot account is banned")
+)
+
+// QRAuthenticator shows QR login tokens to the admin and supplies the 2FA
+// password when the account has one
+type QRAuthenticator interface {
+	ShowQR(ctx context.Context, token qrlogin.Token) error
+	Password(ctx context.Context) (string, error)
+}
+
+// MessageHandler receives new, edited and deleted messages from the
+// account's update stream. Deletions outside channels are not handled:
+// updateDeleteMessages does not say which chat the messages were in.
+type MessageHandler interface {
+	OnNewMessage(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error
+	OnNewChannelMessage(ctx context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error
+	OnEditMessage(ctx context.Context, e tg.Entities, u *tg.UpdateEditMessage) error
+	OnEditChannelMessage(ctx context.Context, e tg.Entities, u *tg.UpdateEditChannelMessage) error
+	OnDeleteChannelMessages(ctx context.Context, e tg.Entities, u *tg.UpdateDeleteChannelMessages) error
+}
+
+// TranscriptionHandler receives the results of voice message transcriptions
+// that Telegram finished asynchronously
+type TranscriptionHandler interface {
+	OnTranscribedAudio(ctx context.Context, e tg.Entities, u *tg.UpdateTranscribedAudio) error
+}
+
+// Client wraps the gotd MTProto client used to read monitored chats
+type Client struct {
+	name       string
+	client     *telegram.Client
+	storage    *SessionStorage
+	dispatcher tg.UpdateDispatcher
+	loggedIn   qrlogin.LoggedIn
+	scheduler  *ratelimit.Scheduler
+	device     config.DeviceProfile
+
+	// expectAuthorized marks accounts that were logged in before, so an
+	// unauthorized session on start means Telegram revoked it
+	expectAuthorized bool
+	// onLogin is called after an interactive login succeeds
+	onLogin func(self *tg.User)
+	// onRestore is called when Run resumes an authorized session
+	onRestore func(self *tg.User)
+
+	started   chan struct{}
+	startOnce sync.Once
+
+	mu         sync.RWMutex
+	running    bool
+	authorized bool
+	self       *tg.User
+}
+
+// NewClient creates a userbot client for the named account that persists
+// its session in storage and identifies itself with the device profile. A
+// nil resolver connects to Telegram directly. The scheduler outlives the
+// client, so FLOOD_WAIT backoff carries over when the account restarts.
+func NewClient(name string, cfg *config.Config, device config.DeviceProfile, resolver dcs.Resolver, scheduler *ratelimit.Scheduler, storage *SessionStorage) *Client {
+	c := &Client{
+		name:       name,
+		storage:    storage,
+		dispatcher: tg.NewUpdateDispatcher(),
+		scheduler:  scheduler,
+		device:     device,
+		started:    make(chan struct{}),
+	}
+	c.loggedIn = qrlogin.OnLoginToken(c.dispatcher)
+	c.client = telegram.NewClient(cfg.Telegram.AppID, cfg.Telegram.AppHash, telegram.Options{
+		SessionStorage: storage,
+		Resolver:       resolver,
+		UpdateHandler:  c.dispatcher,
+		Middlewares:    []telegram.Middleware{c.scheduler},
+		Device: telegram.DeviceConfig{
+			DeviceModel:    device.DeviceModel,
+			SystemVersion:  device.SystemVersion,
+			AppVersion:     device.AppVersion,
+			LangCode:       device.LangCode,
+			SystemLangCode: device.SystemLangCode,
+			LangPack:       device.LangPack,
+		},
+	})
+	return c
+}
+
+// Run connects to Telegram and blocks until ctx is cancelled. An existing
+// session is restored automatically; otherwise the client waits for Login.
+// It returns ErrLoggedOut or ErrBanned when the account becomes unusable.
+func (c *Client) Run(ctx context.Context) error {
+	return c.client.Run(ctx, func(ctx context.Context) error {
+		c.setRunning(true)
+		defer c.setRunning(false)
+		c.startOnce.Do(func() { close(c.started) })
+
+		status, err := c.client.Auth().Status(ctx)
+		if err != nil {
+			if authErr := classifyAuthError(err); authErr != nil {
+				return authErr
+			}
+			return fmt.Errorf("failed to get auth status: %w", err)
+		}
+		if status.Authorized {
+			c.setAuthorized(status.User)
+			log.Printf("Userbot %s: session restored for %s", c.name, displayName(status.User))
+			if c.onRestore != nil {
+				go c.onRestore(status.User)
+			}
+		} else if c.expectAuthorized {
+			return ErrLoggedOut
+		} else {
+			log.Printf("Userbot %s is not authorized, use /login in the bot", c.name)
+		}
+
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+				if err := c.checkHealth(ctx); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// checkHealth makes a cheap authorized call and reports ban or logout
+func (c *Client) checkHealth(ctx context.Context) error {
+	if !c.Authorized() {
+		return nil
+	}
+
+	_, err := c.client.API().UpdatesGetState(ctx)
+	if err == nil {
+		return nil
+	}
+	if authErr := classifyAuthError(err); authErr != nil {
+		return authErr
+	}
+
+	log.Printf("Userbot %s: health check failed: %v", c.name, err)
+	return nil
+}
+
+// waitStarted blocks until the MTProto connection is up
+func (c *Client) waitStarted(ctx context.Context) error {
+	timer := time.NewTimer(startTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.started:
+	case <-timer.C:
+		return ErrNotRunning
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if !c.Running() {
+		return ErrNotRunning
+	}
+	return nil
+}
+
+// Login runs the interactive authentication flow with the given authenticator
+func (c *Client) Login(ctx context.Context, authenticator auth.UserAuthenticator) error {
+	if err := c.waitStarted(ctx); err != nil {
+		return err
+	}
+
+	flow := auth.NewFlow(authenticator, auth.SendCodeOptions{})
+	if err := c.client.Auth().IfNecessary(ctx, flow); err != nil {
+		return err
+	}
+
+	return c.finishLogin(ctx)
+}
+
+// LoginQR authenticates by exporting login tokens (auth.exportLoginToken)
+// that the admin scans from a logged in device. Tokens are re-exported and
+// shown again as they expire. The resulting authorization is bound to the
+// auth key gotd keeps in session_storage.
+func (c *Client) LoginQR(ctx context.Context, authenticator QRAuthenticator) error {
+	if err := c.waitStarted(ctx); err != nil {
+		return err
+	}
+
+	_, err := c.client.QR().Auth(ctx, c.loggedIn, authenticator.ShowQR)
+	if tgerr.Is(err, "SESSION_PASSWORD_NEEDED") {
+		password, err := authenticator.Password(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := c.client.Auth().Password(ctx, password); err != nil {
+			return fmt.Errorf("failed to sign in with password: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("QR login failed: %w", err)
+	}
+
+	return c.finishLogin(ctx)
+}
+
+// finishLogin records the logged in user once authorization succeeded
+func (c *Client) finishLogin(ctx context.Context) error {
+	self, err := c.client.Self(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get self after login: %w", err)
+	}
+	c.setAuthorized(self)
+	log.Printf("Userbot %s: logged in as %s", c.name, displayName(self))
+
+	if c.onLogin != nil {
+		c.onLogin(self)
+	}
+	return nil
+}
+
+// Logout terminates the Telegram session and removes it from the database
+func (c *Client) Logout(ctx context.Context) error {
+	if c.Running() && c.Authorized() {
+		if _, err := c.client.API().AuthLogOut(ctx); err != nil {
+			return fmt.Errorf("failed to log out: %w", err)
+		}
+	}
+	if err := c.storage.Clear(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.authorized = false
+	c.self = nil
+	c.mu.Unlock()
+	return nil
+}
+
+// SetMessageHandler routes message updates to h. It must be called before Run.
+func (c *Client) SetMessageHandler(h MessageHandler) {
+	c.dispatcher.OnNewMessage(h.OnNewMessage)
+	c.dispatcher.OnNewChannelMessage(h.OnNewChannelMessage)
+	c.dispatcher.OnEditMessage(h.OnEditMessage)
+	c.dispatcher.OnEditChannelMessage(h.OnEditChannelMessage)
+	c.dispatcher.OnDeleteChannelMessages(h.OnDeleteChannelMessages)
+}
+
+// SetTranscriptionHandler routes transcription results to h. It must be
+// called before Run.
+func (c *Client) SetTranscriptionHandler(h TranscriptionHandler) {
+	c.dispatcher.OnTranscribedAudio(h.OnTranscribedAudio)
+}
+
+// Name returns the account name
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Throttle returns the FLOOD_WAIT backoff state of the account
+func (c *Client) Throttle() ratelimit.State {
+	return c.scheduler.State()
+}
+
+// Running reports whether the MTProto connection is up
+func (c *Client) Running() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.running
+}
+
+// Authorized reports whether the userbot is logged in
+func (c *Client) Authorized() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.authorized
+}
+
+// Self returns the logged in user, or nil
+func (c *Client) Self() *tg.User {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.self
+}
+
+// API returns the raw MTProto API client
+func (c *Client) API() *tg.Client {
+	return c.client.API()
+}
+
+func (c *Client) setRunning(running bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = running
+}
+
+func (c *Client) setAuthorized(self *tg.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authorized = true
+	c.self = self
+}
+
+// classifyAuthError maps RPC errors that make an account unusable to
+// ErrBanned or ErrLoggedOut, returning nil for anything else
+func classifyAuthError(err error) error {
+	switch {
+	case tgerr.Is(err, "USER_DEACTIVATED", "USER_DEACTIVATED_BAN", "PHONE_NUMBER_BANNED"):
+		return fmt.Errorf("%w: %v", ErrBanned, err)
+	case tgerr.Is(err, "AUTH_KEY_UNREGISTERED", "SESSION_REVOKED", "SESSION_EXPIRED", "AUTH_KEY_INVALID"):
+		return fmt.Errorf("%w: %v", ErrLoggedOut, err)
+	default:
+		return nil
+	}
+}
+
+func displayName(u *tg.User) string {
+	if u == nil {
+		return "unknown user"
+	}
+	if username, ok := u.GetUsername(); ok {
+		return "@" + username
+	}
+	return fmt.Sprintf("user %d", u.ID)
+}
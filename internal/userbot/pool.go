@@ -0,0 +1,614 @@
+package userbot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/telegram/dcs"
+	"github.com/gotd/td/tg"
+
+	"telemonitor/internal/config"
+	"telemonitor/internal/database"
+	"telemonitor/internal/database/repository"
+	"telemonitor/internal/ratelimit"
+)
+
+// ReadyHandler is called when an account can read chats, either after its
+// session was restored or after chats were moved to it
+type ReadyHandler func(ctx context.Context, client *Client, chats []*database.MonitoredChat)
+
+// DefaultAccount is the account used when no name is given
+const DefaultAccount = "default"
+
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = 5 * time.Minute
+	// healthyRun is how long a client has to run before its restart delay
+	// starts again from minRestartDelay
+	healthyRun = 10 * time.Minute
+	// restartGrace is how long a starting or restarting account keeps its
+	// chats before Rebalance moves them to another account
+	restartGrace = 2 * time.Minute
+)
+
+// AccountHealth is a snapshot of one account for /status
+type AccountHealth struct {
+	Name       string
+	Username   string
+	Status     string
+	Running    bool
+	Authorized bool
+	Chats      int
+	Restarts   int
+	LastError  string
+	Throttle   ratelimit.State
+}
+
+// member is a supervised account and its current client. The scheduler is
+// kept across restarts so a new client still honours earlier FLOOD_WAITs.
+type member struct {
+	account   *database.Account
+	client    *Client
+	scheduler *ratelimit.Scheduler
+	started   time.Time
+	cancel    context.CancelFunc
+	restarts  int
+	lastErr   error
+}
+
+// Pool supervises one gotd client per account, restarts them on failure and
+// moves chats away from accounts that were banned or logged out
+type Pool struct {
+	cfg      *config.Config
+	sessions *repository.SessionRepository
+	accounts *repository.AccountRepository
+	chats    *repository.MonitoredChatRepository
+	resolver dcs.Resolver
+
+	notify         func(text string)
+	messages       MessageHandler
+	transcriptions TranscriptionHandler
+	ready          ReadyHandler
+
+	ctx     context.Context
+	mu      sync.RWMutex
+	members map[string]*member
+}
+
+// NewPool creates an account pool. All accounts share the proxy from
+// cfg.Telegram.Proxy.
+func NewPool(cfg *config.Config, sessions *repository.SessionRepository, accounts *repository.AccountRepository, chats *repository.MonitoredChatRepository) (*Pool, error) {
+	resolver, err := NewResolver(cfg.Telegram.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{
+		cfg:      cfg,
+		sessions: sessions,
+		accounts: accounts,
+		chats:    chats,
+		resolver: resolver,
+		notify:   func(string) {},
+		ctx:      context.Background(),
+		members:  make(map[string]*member),
+	}, nil
+}
+
+// SetNotifier sets the function used to alert the admin about account
+// failures and chat reassignment
+func (p *Pool) SetNotifier(notify func(text string)) {
+	p.notify = notify
+}
+
+// SetMessageHandler routes message updates from every account to h. It must be
+// called before Run.
+func (p *Pool) SetMessageHandler(h MessageHandler) {
+	p.messages = h
+}
+
+// SetTranscriptionHandler routes transcription results from every account to
+// h. It must be called before Run.
+func (p *Pool) SetTranscriptionHandler(h TranscriptionHandler) {
+	p.transcriptions = h
+}
+
+// SetReadyHandler sets the function that catches up on chats once an account
+// is ready to read them. It must be called before Run.
+func (p *Pool) SetReadyHandler(h ReadyHandler) {
+	p.ready = h
+}
+
+// Run starts a client for every active account and blocks until ctx is
+// cancelled
+func (p *Pool) Run(ctx context.Context) error {
+	p.mu.Lock()
+	p.ctx = ctx
+	p.mu.Unlock()
+
+	accounts, err := p.accounts.GetAll()
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		account, err := p.accounts.Create(DefaultAccount)
+		if err != nil {
+			return err
+		}
+		accounts = append(accounts, account)
+	}
+
+	for _, account := range accounts {
+		if account.Status != database.AccountActive {
+			continue
+		}
+		// Sessions created before profiles were pinned were made with the
+		// fingerprint the userbot always used, whatever the config says now
+		if account.TelegramUserID.Valid && !account.DeviceProfile.Valid {
+			profile := config.DefaultDeviceProfile
+			if err := p.accounts.SetDeviceProfile(account.ID, profile); err != nil {
+				return err
+			}
+			account.DeviceProfile = sql.NullString{String: profile, Valid: true}
+		}
+		p.start(account)
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// Account returns the client for name, creating the account and starting
+// its client if needed. Accounts that were banned or logged out are
+// reactivated so the admin can log in again.
+func (p *Pool) Account(name string) (*Client, error) {
+	p.mu.RLock()
+	m, ok := p.members[name]
+	var client *Client
+	if ok {
+		client = m.client
+	}
+	p.mu.RUnlock()
+	if ok {
+		return client, nil
+	}
+
+	account, err := p.accounts.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if account.Status != database.AccountActive {
+		// A fresh login is required, so forget the previous identity check
+		account.TelegramUserID = sql.NullInt64{}
+		if err := p.accounts.SetStatus(account.ID, database.AccountActive, sql.NullString{}); err != nil {
+			return nil, err
+		}
+		account.Status = database.AccountActive
+	}
+
+	return p.start(account), nil
+}
+
+// ClientForChat returns the client of the account a chat is assigned to
+func (p *Pool) ClientForChat(chat *database.MonitoredChat) *Client {
+	if !chat.AccountID.Valid {
+		return nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, m := range p.members {
+		if int64(m.account.ID) == chat.AccountID.Int64 {
+			return m.client
+		}
+	}
+	return nil
+}
+
+// Logout logs an account out, stops its client and moves its chats to the
+// remaining accounts
+func (p *Pool) Logout(ctx context.Context, name string) error {
+	p.mu.Lock()
+	m, ok := p.members[name]
+	var client *Client
+	if ok {
+		delete(p.members, name)
+		client = m.client
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("account %q is not running", name)
+	}
+
+	err := client.Logout(ctx)
+	m.cancel()
+	if err != nil {
+		return err
+	}
+
+	if err := p.accounts.SetStatus(m.account.ID, database.AccountLoggedOut, sql.NullString{}); err != nil {
+		return err
+	}
+	return p.Rebalance(ctx)
+}
+
+// Rebalance assigns every active chat that has no healthy account to the
+// least loaded healthy one. Accounts that are still starting or restarting
+// keep their chats for restartGrace. Public chats are joined by the new
+// account; private chats need the admin to add the account manually.
+func (p *Pool) Rebalance(ctx context.Context) error {
+	chats, err := p.chats.GetActive()
+	if err != nil {
+		return err
+	}
+	counts, err := p.chats.CountActiveByAccount()
+	if err != nil {
+		return err
+	}
+
+	keep := p.keepingMembers()
+
+	var moved, needsInvite []string
+	received := make(map[*member][]*database.MonitoredChat)
+	for _, chat := range chats {
+		if chat.AccountID.Valid && keep[chat.AccountID.Int64] {
+			continue
+		}
+
+		m := p.leastLoaded(counts)
+		if m == nil {
+			log.Printf("No healthy userbot account for chat %d", chat.ChatID)
+			continue
+		}
+		if err := p.chats.AssignAccount(chat.ChatID, m.account.ID); err != nil {
+			return err
+		}
+		if chat.AccountID.Valid {
+			counts[int(chat.AccountID.Int64)]--
+		}
+		counts[m.account.ID]++
+		received[m] = append(received[m], chat)
+
+		label := chatLabel(chat)
+		moved = append(moved, fmt.Sprintf("%s → %s", label, m.account.Name))
+
+		if !chat.Username.Valid {
+			needsInvite = append(needsInvite, label)
+			continue
+		}
+		if err := m.client.JoinPublic(ctx, chat.Username.String); err != nil {
+			log.Printf("Userbot %s failed to join @%s: %v", m.account.Name, chat.Username.String, err)
+			needsInvite = append(needsInvite, label)
+		}
+	}
+
+	if len(moved) > 0 {
+		text := fmt.Sprintf("🔀 Reassigned %d chat(s):\n", len(moved))
+		for _, line := range moved {
+			text += "• " + line + "\n"
+		}
+		if len(needsInvite) > 0 {
+			text += "\n⚠️ Add the new account to these chats manually:\n"
+			for _, label := range needsInvite {
+				text += "• " + label + "\n"
+			}
+		}
+		p.notify(text)
+	}
+
+	if p.ready != nil {
+		for m, chats := range received {
+			go p.ready(ctx, m.client, chats)
+		}
+	}
+
+	return nil
+}
+
+// Health returns a snapshot of every known account
+func (p *Pool) Health() ([]AccountHealth, error) {
+	accounts, err := p.accounts.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := p.chats.CountActiveByAccount()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	health := make([]AccountHealth, 0, len(accounts))
+	for _, account := range accounts {
+		h := AccountHealth{
+			Name:      account.Name,
+			Username:  account.Username.String,
+			Status:    account.Status,
+			Chats:     counts[account.ID],
+			LastError: account.LastError.String,
+		}
+		if m, ok := p.members[account.Name]; ok {
+			h.Running = m.client.Running()
+			h.Authorized = m.client.Authorized()
+			h.Restarts = m.restarts
+			h.Throttle = m.client.Throttle()
+			if m.lastErr != nil {
+				h.LastError = m.lastErr.Error()
+			}
+		}
+		health = append(health, h)
+	}
+
+	return health, nil
+}
+
+// start launches the supervisor goroutine for an account and returns its
+// current client
+func (p *Pool) start(account *database.Account) *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if m, ok := p.members[account.Name]; ok {
+		return m.client
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	m := &member{
+		account:   account,
+		scheduler: ratelimit.New(p.cfg.RateLimiting),
+		cancel:    cancel,
+		started:   time.Now(),
+	}
+	m.client = p.newClient(m)
+	p.members[account.Name] = m
+
+	go p.supervise(ctx, m)
+	return m.client
+}
+
+func (p *Pool) newClient(m *member) *Client {
+	device := p.deviceProfile(m.account)
+	c := NewClient(m.account.Name, p.cfg, device, p.resolver, m.scheduler, NewSessionStorage(p.sessions, m.account.Name))
+	c.expectAuthorized = m.account.TelegramUserID.Valid
+	c.onLogin = func(self *tg.User) {
+		p.handleLogin(m, self, device.Name)
+	}
+	c.onRestore = func(self *tg.User) {
+		p.handleRestore(m, c, self, device.Name)
+	}
+	if p.messages != nil {
+		c.SetMessageHandler(p.messages)
+	}
+	if p.transcriptions != nil {
+		c.SetTranscriptionHandler(p.transcriptions)
+	}
+	return c
+}
+
+// deviceProfile returns the profile pinned to the account, or the configured
+// one for accounts that never logged in
+func (p *Pool) deviceProfile(account *database.Account) config.DeviceProfile {
+	if account.DeviceProfile.Valid {
+		if profile, ok := config.DeviceProfiles[account.DeviceProfile.String]; ok {
+			return profile
+		}
+		log.Printf("Userbot %s: pinned device profile %q no longer exists, using %q",
+			account.Name, account.DeviceProfile.String, p.cfg.Telegram.DeviceProfile)
+	}
+	return config.DeviceProfiles[p.cfg.Telegram.DeviceProfile]
+}
+
+// supervise runs an account's client, restarting it with backoff until the
+// account is banned, logged out or ctx is cancelled
+func (p *Pool) supervise(ctx context.Context, m *member) {
+	delay := minRestartDelay
+	for {
+		p.mu.RLock()
+		client := m.client
+		p.mu.RUnlock()
+
+		started := time.Now()
+		err := client.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, ErrBanned) || errors.Is(err, ErrLoggedOut) {
+			p.retire(m, err)
+			return
+		}
+		if time.Since(started) >= healthyRun {
+			delay = minRestartDelay
+		}
+
+		p.restart(m, err, delay)
+		log.Printf("Userbot %s stopped: %v; restarting in %s", m.account.Name, err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
+
+// restart replaces the client of m, which stopped with err, by a new one
+// that supervise runs once delay has passed
+func (p *Pool) restart(m *member, err error, delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m.restarts++
+	m.lastErr = err
+	m.client = p.newClient(m)
+	m.started = time.Now().Add(delay)
+}
+
+// retire marks a banned or logged out account and hands its chats over. It
+// works on the pool's context, as the account's own is cancelled here.
+func (p *Pool) retire(m *member, cause error) {
+	status := database.AccountLoggedOut
+	if errors.Is(cause, ErrBanned) {
+		status = database.AccountBanned
+	}
+
+	p.mu.Lock()
+	delete(p.members, m.account.Name)
+	ctx := p.ctx
+	p.mu.Unlock()
+	m.cancel()
+
+	log.Printf("Userbot %s retired: %v", m.account.Name, cause)
+	lastErr := sql.NullString{String: cause.Error(), Valid: true}
+	if err := p.accounts.SetStatus(m.account.ID, status, lastErr); err != nil {
+		log.Printf("Failed to update account %s status: %v", m.account.Name, err)
+	}
+	if status == database.AccountLoggedOut {
+		if err := m.client.storage.Clear(ctx); err != nil {
+			log.Printf("Failed to clear session of %s: %v", m.account.Name, err)
+		}
+	}
+
+	p.notify(fmt.Sprintf("🚨 Userbot account %s is %s: %v", m.account.Name, status, cause))
+	if err := p.Rebalance(ctx); err != nil {
+		log.Printf("Failed to rebalance chats: %v", err)
+	}
+}
+
+// handleLogin records the account identity, pins the device profile used for
+// the login and picks up orphaned chats
+func (p *Pool) handleLogin(m *member, self *tg.User, deviceProfile string) {
+	p.recordIdentity(m, self, deviceProfile)
+
+	p.mu.RLock()
+	ctx := p.ctx
+	client := m.client
+	p.mu.RUnlock()
+
+	go func() {
+		if err := p.Rebalance(ctx); err != nil {
+			log.Printf("Failed to rebalance chats: %v", err)
+		}
+		p.handleReady(m, client)
+	}()
+}
+
+// handleRestore records the identity of a restored session, picks up
+// orphaned chats and hands the account its chats. A session restored before the account knew its
+// Telegram user predates device profiles, so it is pinned to the default
+// fingerprint it was created with rather than the one configured now.
+func (p *Pool) handleRestore(m *member, client *Client, self *tg.User, deviceProfile string) {
+	p.mu.RLock()
+	legacy := !m.account.TelegramUserID.Valid && !m.account.DeviceProfile.Valid
+	ctx := p.ctx
+	p.mu.RUnlock()
+	if legacy {
+		deviceProfile = config.DefaultDeviceProfile
+	}
+
+	p.recordIdentity(m, self, deviceProfile)
+	// Chats added without an account or left by one that is gone are
+	// picked up as soon as an account can read them, not only on login
+	if err := p.Rebalance(ctx); err != nil {
+		log.Printf("Failed to rebalance chats: %v", err)
+	}
+	p.handleReady(m, client)
+}
+
+// recordIdentity stores the Telegram user behind the account and pins
+// deviceProfile unless the account already has one
+func (p *Pool) recordIdentity(m *member, self *tg.User, deviceProfile string) {
+	username := sql.NullString{}
+	if u, ok := self.GetUsername(); ok {
+		username = sql.NullString{String: u, Valid: true}
+	}
+	if err := p.accounts.SetIdentity(m.account.ID, self.ID, username, deviceProfile); err != nil {
+		log.Printf("Failed to record identity of %s: %v", m.account.Name, err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m.account.TelegramUserID = sql.NullInt64{Int64: self.ID, Valid: true}
+	m.account.Username = username
+	if !m.account.DeviceProfile.Valid {
+		m.account.DeviceProfile = sql.NullString{String: deviceProfile, Valid: true}
+	}
+}
+
+// handleReady hands the chats assigned to the account to the ready handler
+func (p *Pool) handleReady(m *member, client *Client) {
+	if p.ready == nil {
+		return
+	}
+
+	chats, err := p.chats.GetActiveByAccount(m.account.ID)
+	if err != nil {
+		log.Printf("Failed to list chats of %s: %v", m.account.Name, err)
+		return
+	}
+	if len(chats) == 0 {
+		return
+	}
+
+	p.mu.RLock()
+	ctx := p.ctx
+	p.mu.RUnlock()
+	p.ready(ctx, client, chats)
+}
+
+func (p *Pool) healthyMembers() []*member {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var healthy []*member
+	for _, m := range p.members {
+		if m.client.Running() && m.client.Authorized() {
+			healthy = append(healthy, m)
+		}
+	}
+	sort.Slice(healthy, func(i, j int) bool {
+		return healthy[i].account.ID < healthy[j].account.ID
+	})
+	return healthy
+}
+
+// keepingMembers returns the IDs of the accounts that keep their chats on a
+// rebalance: the healthy ones and those still within restartGrace of starting
+func (p *Pool) keepingMembers() map[int64]bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	keep := make(map[int64]bool)
+	for _, m := range p.members {
+		if m.client.Running() && m.client.Authorized() || time.Since(m.started) < restartGrace {
+			keep[int64(m.account.ID)] = true
+		}
+	}
+	return keep
+}
+
+func (p *Pool) leastLoaded(counts map[int]int) *member {
+	var best *member
+	for _, m := range p.healthyMembers() {
+		if best == nil || counts[m.account.ID] < counts[best.account.ID] {
+			best = m
+		}
+	}
+	return best
+}
+
+func chatLabel(chat *database.MonitoredChat) string {
+	if chat.Title.Valid && chat.Title.String != "" {
+		return fmt.Sprintf("%s (%d)", chat.Title.String, chat.ChatID)
+	}
+	return fmt.Sprintf("%d", chat.ChatID)
+}
@@ -0,0 +1,54 @@
+package userbot
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"telemonitor/internal/config"
+	"telemonitor/internal/database"
+	"telemonitor/internal/ratelimit"
+)
+
+// TestAccountDuringRestart runs under -race: Account must read the client of
+// a member while supervise replaces it
+func TestAccountDuringRestart(t *testing.T) {
+	p, err := NewPool(&config.Config{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &member{
+		account:   &database.Account{Name: "main"},
+		scheduler: ratelimit.New(config.RateLimitingConfig{}),
+		cancel:    func() {},
+	}
+	m.client = p.newClient(m)
+	p.members["main"] = m
+
+	const restarts = 50
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < restarts; i++ {
+			p.restart(m, errors.New("connection lost"), 0)
+		}
+	}()
+
+	for i := 0; i < restarts; i++ {
+		client, err := p.Account("main")
+		if err != nil {
+			t.Fatalf("Account: %v", err)
+		}
+		if client == nil || client.Name() != "main" {
+			t.Fatalf("Account returned client %v, want the member's", client)
+		}
+	}
+	wg.Wait()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if m.restarts != restarts {
+		t.Errorf("restarts = %d, want %d", m.restarts, restarts)
+	}
+}
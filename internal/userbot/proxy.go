@@ -0,0 +1,68 @@
+package userbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/gotd/td/telegram/dcs"
+	"golang.org/x/net/proxy"
+
+	"telemonitor/internal/config"
+)
+
+// NewResolver builds the gotd DC resolver for the configured proxy. It
+// returns nil when no proxy is set, which makes gotd connect directly.
+func NewResolver(cfg config.ProxyConfig) (dcs.Resolver, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	p, err := cfg.Normalize()
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy config: %w", err)
+	}
+	log.Printf("Userbot connects through %s proxy %s", p.Type, p.Address)
+
+	switch p.Type {
+	case config.ProxySOCKS5:
+		dial, err := socks5Dialer(p)
+		if err != nil {
+			return nil, err
+		}
+		return dcs.Plain(dcs.PlainOptions{Dial: dial}), nil
+	case config.ProxyMTProxy:
+		secret, err := p.MTProxySecret()
+		if err != nil {
+			return nil, err
+		}
+		resolver, err := dcs.MTProxy(p.Address, secret, dcs.MTProxyOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("invalid mtproxy secret: %w", err)
+		}
+		return resolver, nil
+	default:
+		return nil, nil
+	}
+}
+
+// socks5Dialer returns a dial function that tunnels through the SOCKS5 proxy
+func socks5Dialer(p config.ProxyConfig) (dcs.DialFunc, error) {
+	var auth *proxy.Auth
+	if p.Username != "" {
+		auth = &proxy.Auth{User: p.Username, Password: p.Password}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", p.Address, auth, &net.Dialer{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socks5 dialer: %w", err)
+	}
+	ctxDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("socks5 dialer does not support contexts")
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return ctxDialer.DialContext(ctx, network, addr)
+	}, nil
+}
@@ -0,0 +1,277 @@
+package userbot
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"telemonitor/internal/config"
+)
+
+// socksStandIn is a minimal SOCKS5 server (RFC 1928/1929) that accepts
+// username/password auth and CONNECT requests
+type socksStandIn struct {
+	ln       net.Listener
+	user     string
+	password string
+	targets  chan string
+}
+
+func newSocksStandIn(t *testing.T, user, password string) *socksStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socksStandIn{ln: ln, user: user, password: password, targets: make(chan string, 8)}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *socksStandIn) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			if err := s.handle(conn); err != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func (s *socksStandIn) handle(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	// Greeting: VER NMETHODS METHODS...
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return err
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return err
+	}
+	if s.user == "" {
+		_, err := conn.Write([]byte{5, 0})
+		if err != nil {
+			return err
+		}
+	} else {
+		if _, err := conn.Write([]byte{5, 2}); err != nil {
+			return err
+		}
+		// Auth: VER ULEN USER PLEN PASS
+		ver, _ := r.ReadByte()
+		ulen, _ := r.ReadByte()
+		user := make([]byte, ulen)
+		io.ReadFull(r, user)
+		plen, _ := r.ReadByte()
+		pass := make([]byte, plen)
+		if _, err := io.ReadFull(r, pass); err != nil || ver != 1 {
+			return fmt.Errorf("bad auth request")
+		}
+		if string(user) != s.user || string(pass) != s.password {
+			conn.Write([]byte{1, 1})
+			return fmt.Errorf("bad credentials")
+		}
+		if _, err := conn.Write([]byte{1, 0}); err != nil {
+			return err
+		}
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil {
+		return err
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n, _ := r.ReadByte()
+		name := make([]byte, n)
+		io.ReadFull(r, name)
+		host = string(name)
+	default:
+		return fmt.Errorf("unsupported address type %d", req[3])
+	}
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, portBuf); err != nil {
+		return err
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBuf))))
+	s.targets <- target
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0}); err != nil {
+		upstream.Close()
+		return err
+	}
+
+	go func() {
+		io.Copy(upstream, r)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+	return nil
+}
+
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSOCKS5DialerConnectsThroughProxy(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  func(proxyAddr string) config.ProxyConfig
+	}{
+		{"fields", func(addr string) config.ProxyConfig {
+			return config.ProxyConfig{Type: config.ProxySOCKS5, Address: addr, Username: "monitor", Password: "s3cret"}
+		}},
+		{"link", func(addr string) config.ProxyConfig {
+			return config.ProxyConfig{Link: "socks5://monitor:s3cret@" + addr}
+		}},
+		{"tg link", func(addr string) config.ProxyConfig {
+			host, port, _ := net.SplitHostPort(addr)
+			return config.ProxyConfig{Link: "tg://socks?server=" + host + "&port=" + port + "&user=monitor&pass=s3cret"}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			socks := newSocksStandIn(t, "monitor", "s3cret")
+			echo := newEchoServer(t)
+
+			p, err := tc.cfg(socks.ln.Addr().String()).Normalize()
+			if err != nil {
+				t.Fatalf("Normalize: %v", err)
+			}
+			dial, err := socks5Dialer(p)
+			if err != nil {
+				t.Fatalf("socks5Dialer: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := dial(ctx, "tcp", echo)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+
+			select {
+			case target := <-socks.targets:
+				if target != echo {
+					t.Fatalf("proxy connected to %s, want %s", target, echo)
+				}
+			case <-ctx.Done():
+				t.Fatal("proxy did not receive a CONNECT request")
+			}
+
+			msg := []byte("ping")
+			if _, err := conn.Write(msg); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, len(msg))
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.ReadFull(conn, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(msg) {
+				t.Fatalf("echo = %q, want %q", got, msg)
+			}
+		})
+	}
+}
+
+func TestSOCKS5DialerRejectsBadCredentials(t *testing.T) {
+	socks := newSocksStandIn(t, "monitor", "s3cret")
+	echo := newEchoServer(t)
+
+	dial, err := socks5Dialer(config.ProxyConfig{
+		Type:     config.ProxySOCKS5,
+		Address:  socks.ln.Addr().String(),
+		Username: "monitor",
+		Password: "wrong",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if conn, err := dial(ctx, "tcp", echo); err == nil {
+		conn.Close()
+		t.Fatal("dial succeeded with wrong password")
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	// 16-byte secret with the "dd" padded-intermediate prefix
+	const secret = "dd0123456789abcdef0123456789abcdef"
+
+	for _, tc := range []struct {
+		name    string
+		cfg     config.ProxyConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "disabled", cfg: config.ProxyConfig{}, wantNil: true},
+		{name: "socks5", cfg: config.ProxyConfig{Type: config.ProxySOCKS5, Address: "127.0.0.1:1080"}},
+		{name: "mtproxy link", cfg: config.ProxyConfig{Link: "tg://proxy?server=proxy.example.com&port=443&secret=" + secret}},
+		{name: "t.me link", cfg: config.ProxyConfig{Link: "https://t.me/proxy?server=proxy.example.com&port=443&secret=" + secret}},
+		{name: "bad secret", cfg: config.ProxyConfig{Type: config.ProxyMTProxy, Address: "proxy.example.com:443", Secret: "ff"}, wantErr: true},
+		{name: "missing port", cfg: config.ProxyConfig{Type: config.ProxySOCKS5, Address: "127.0.0.1"}, wantErr: true},
+		{name: "unknown type", cfg: config.ProxyConfig{Type: "http", Address: "127.0.0.1:8080"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resolver, err := NewResolver(tc.cfg)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewResolver: %v", err)
+			}
+			if (resolver == nil) != tc.wantNil {
+				t.Fatalf("resolver = %v, wantNil %v", resolver, tc.wantNil)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package userbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gotd/td/session"
+
+	"telemonitor/internal/database/repository"
+)
+
+// defaultSessionTimeout bounds every session_storage round-trip so a slow
+// database cannot hang the MTProto client
+const defaultSessionTimeout = 10 * time.Second
+
+// SessionKey returns the session_storage key for an account
+func SessionKey(account string) string {
+	return "userbot:" + account
+}
+
+// SessionStorage adapts SessionRepository to gotd's session.Storage so the
+// MTProto auth key survives container restarts
+type SessionStorage struct {
+	repo    *repository.SessionRepository
+	key     string
+	timeout time.Duration
+}
+
+var _ session.Storage = (*SessionStorage)(nil)
+
+// NewSessionStorage creates a session.Storage for the given account
+func NewSessionStorage(repo *repository.SessionRepository, account string) *SessionStorage {
+	return &SessionStorage{
+		repo:    repo,
+		key:     SessionKey(account),
+		timeout: defaultSessionTimeout,
+	}
+}
+
+// LoadSession loads the stored session, returning session.ErrNotFound when
+// the account has never logged in
+func (s *SessionStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	data, err := s.repo.LoadContext(ctx, s.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session %s: %w", s.key, err)
+	}
+	if len(data) == 0 {
+		return nil, session.ErrNotFound
+	}
+
+	return data, nil
+}
+
+// StoreSession persists the session produced by gotd
+func (s *SessionStorage) StoreSession(ctx context.Context, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if err := s.repo.SaveContext(ctx, s.key, data); err != nil {
+		return fmt.Errorf("failed to store session %s: %w", s.key, err)
+	}
+	return nil
+}
+
+// Clear removes the stored session, used on logout
+func (s *SessionStorage) Clear(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if err := s.repo.DeleteContext(ctx, s.key); err != nil {
+		return fmt.Errorf("failed to clear session %s: %w", s.key, err)
+	}
+	return nil
+}